@@ -1,6 +1,7 @@
 package fit
 
 import (
+	"context"
 	"go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc/resolver"
 )
@@ -15,6 +16,7 @@ func (b *Builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolv
 		cc:     cc,
 		prefix: target.URL.Path,
 	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
 
 	go r.watcher()
 	return r, nil
@@ -0,0 +1,304 @@
+package fit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"go.etcd.io/etcd/client/v3"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EtcdConfig etcd connection settings that can be loaded by LoadConfig
+type EtcdConfig struct {
+	Endpoints   []string      `json:"endpoints"`
+	Username    string        `json:"username"`
+	Password    string        `json:"password"`
+	DialTimeout time.Duration `json:"dial_timeout" default:"5s"`
+}
+
+// ClientConfig config of InitEtcd
+func (c EtcdConfig) ClientConfig() clientv3.Config {
+	return clientv3.Config{
+		Endpoints:   c.Endpoints,
+		Username:    c.Username,
+		Password:    c.Password,
+		DialTimeout: c.DialTimeout,
+	}
+}
+
+// RedisConfig redis connection settings that can be loaded by LoadConfig
+type RedisConfig struct {
+	Addr     string `json:"addr" default:"127.0.0.1:6379"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+// Options config of NewRedisConnect
+func (c RedisConfig) Options() redis.Options {
+	return redis.Options{Addr: c.Addr, Username: c.Username, Password: c.Password, DB: c.DB}
+}
+
+type configLoader struct {
+	file       string
+	envPrefix  string
+	etcdKey    string
+	etcdClient *clientv3.Client
+	ctx        context.Context
+	onReload   func(value any, err error)
+}
+
+type ConfigOption func(*configLoader)
+
+// ConfigFile yaml/json/toml... file, overrides the etcd layer
+func ConfigFile(path string) ConfigOption {
+	return func(l *configLoader) {
+		l.file = path
+	}
+}
+
+// ConfigEnvPrefix prefix of the environment variables, default "FIT_"
+func ConfigEnvPrefix(prefix string) ConfigOption {
+	return func(l *configLoader) {
+		l.envPrefix = prefix
+	}
+}
+
+// ConfigEtcd load the json value of key from etcd(the lowest layer above the defaults) and watch it until ctx is done,
+// the config is rebuilt on every change and onReload is called with the result: a new pointer of the type of dst,
+// nil when err is not nil. cli nil uses the client of InitEtcd.
+func ConfigEtcd(ctx context.Context, cli *clientv3.Client, key string, onReload func(value any, err error)) ConfigOption {
+	return func(l *configLoader) {
+		l.ctx = ctx
+		l.etcdClient = cli
+		l.etcdKey = key
+		l.onReload = onReload
+	}
+}
+
+// LoadConfig bind dst(pointer to struct) from the layers, in priority order: environment variables, the file,
+// the etcd key and the `default:"..."` tags.
+//
+// The keys of the file and etcd are the json tags(or field names). The environment variable of a field is
+// the prefix followed by its `env` tag, or by the upper-cased json names of the path joined with "_",
+// e.g. FIT_ETCD_ENDPOINTS, slices are comma-separated.
+//
+// dst is only filled by the first load, the reloaded configs are passed to the onReload callback of ConfigEtcd.
+func LoadConfig(dst any, opts ...ConfigOption) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("dst must be a non-nil pointer to struct")
+	}
+	l := &configLoader{envPrefix: "FIT_", ctx: context.Background()}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.etcdKey == "" {
+		return l.load(rv, nil)
+	}
+
+	cli := l.etcdClient
+	if cli == nil {
+		cli = client
+	}
+	if cli == nil {
+		return NewErr("etcd instance not found!")
+	}
+	var raw json.RawMessage
+	var w *ConfigWatcher
+	ready := make(chan struct{})
+	w, err := watchConfig(l.ctx, cli, l.etcdKey, &raw, func(err error) {
+		<-ready
+		if l.onReload == nil {
+			return
+		}
+		// a new value, dst may be read by other goroutines
+		var value reflect.Value
+		if err == nil {
+			value, err = l.build(rv.Elem().Type(), *w.Get().(*json.RawMessage))
+		}
+		if err != nil {
+			l.onReload(nil, err)
+			return
+		}
+		l.onReload(value.Interface(), nil)
+	})
+	if err != nil {
+		return err
+	}
+	close(ready)
+	return l.load(rv, raw)
+}
+
+// load build the config into dst
+func (l *configLoader) load(dst reflect.Value, etcdValue []byte) error {
+	v, err := l.build(dst.Elem().Type(), etcdValue)
+	if err != nil {
+		return err
+	}
+	dst.Elem().Set(v.Elem())
+	return nil
+}
+
+// build a new config of typ from the layers, a pointer
+func (l *configLoader) build(typ reflect.Type, etcdValue []byte) (reflect.Value, error) {
+	v := reflect.New(typ)
+	if err := applyConfigDefaults(v.Elem()); err != nil {
+		return reflect.Value{}, err
+	}
+	if len(etcdValue) > 0 {
+		if err := json.Unmarshal(etcdValue, v.Interface()); err != nil {
+			return reflect.Value{}, fmt.Errorf("etcd config %s: %w", l.etcdKey, err)
+		}
+	}
+	if l.file != "" {
+		vp := viper.New()
+		vp.SetConfigFile(l.file)
+		if err := vp.ReadInConfig(); err != nil {
+			return reflect.Value{}, err
+		}
+		err := vp.Unmarshal(v.Interface(), func(c *mapstructure.DecoderConfig) {
+			c.TagName = "json"
+		})
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("config file %s: %w", l.file, err)
+		}
+	}
+	if err := applyConfigEnv(v.Elem(), l.envPrefix); err != nil {
+		return reflect.Value{}, err
+	}
+	return v, nil
+}
+
+func applyConfigDefaults(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && !isConfigScalar(fv) {
+			if err := applyConfigDefaults(fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if def, ok := f.Tag.Lookup("default"); ok {
+			if err := setConfigValue(fv, def); err != nil {
+				return fmt.Errorf("default of %s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func applyConfigEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n, _, _ := strings.Cut(tag, ","); n != "" {
+				name = n
+			}
+		}
+		key := prefix + strings.ToUpper(name)
+		if env := f.Tag.Get("env"); env != "" {
+			key = prefix + env
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && !isConfigScalar(fv) {
+			if err := applyConfigEnv(fv, key+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+		if val, ok := os.LookupEnv(key); ok {
+			if err := setConfigValue(fv, val); err != nil {
+				return fmt.Errorf("env %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func isConfigScalar(v reflect.Value) bool {
+	return v.Type() == reflect.TypeOf(time.Time{})
+}
+
+// setConfigValue set a string, bool, number, time.Duration or comma-separated slice of them, other kinds are ignored
+func setConfigValue(v reflect.Value, s string) error {
+	if !v.CanSet() {
+		return nil
+	}
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		parts := strings.Split(s, ",")
+		sl := reflect.MakeSlice(v.Type(), 0, len(parts))
+		for _, p := range parts {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := setConfigValue(ev, p); err != nil {
+				return err
+			}
+			sl = reflect.Append(sl, ev)
+		}
+		v.Set(sl)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package fit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type loadTestConfig struct {
+	Port int    `json:"port" default:"8080"`
+	Name string `json:"name"`
+}
+
+func TestLoadConfigEtcdReload(t *testing.T) {
+	c := useEmbedEtcd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const key = "/config/load-test"
+	if _, err := c.Put(ctx, key, `{"name":"a"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan *loadTestConfig, 4)
+	var conf loadTestConfig
+	err := LoadConfig(&conf, ConfigEtcd(ctx, c, key, func(value any, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		reloaded <- value.(*loadTestConfig)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "a" || conf.Port != 8080 {
+		t.Fatalf("first load = %+v", conf)
+	}
+
+	if _, err := c.Put(ctx, key, `{"name":"b","port":9090}`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-reloaded:
+		if v.Name != "b" || v.Port != 9090 {
+			t.Fatalf("reloaded = %+v", v)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("not reloaded")
+	}
+	// dst is not written by the watch goroutine
+	if conf.Name != "a" || conf.Port != 8080 {
+		t.Fatalf("dst changed on reload: %+v", conf)
+	}
+}
@@ -2,9 +2,13 @@ package fit
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"log"
 	"reflect"
+	"strings"
+	"sync"
 )
 
 // H container for map of strings to interface{}
@@ -51,6 +55,75 @@ func MapConvertStruct(input, output interface{}) error {
 	return nil
 }
 
+// MapToStruct decode the map into a T, the fields are matched by json tag or(case-insensitively) by name
+func MapToStruct[T any](m map[string]any) (T, error) {
+	var out T
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:  &out,
+		TagName: "json",
+	})
+	if err != nil {
+		return out, err
+	}
+	err = decoder.Decode(m)
+	return out, err
+}
+
+// StructToMap convert the exported fields of a struct(or pointer to struct) to H,
+// keyed by json tag name or field name, fields tagged "-" are skipped.
+func StructToMap(v any) (H, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, errors.New("nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct, got %s", rv.Kind())
+	}
+
+	fields := structFields(rv.Type())
+	h := make(H, len(fields))
+	for _, f := range fields {
+		h[f.name] = rv.Field(f.index).Interface()
+	}
+	return h, nil
+}
+
+type structField struct {
+	name  string
+	index int
+}
+
+// structFieldsCache the exported fields of a struct type, keyed by reflect.Type
+var structFieldsCache sync.Map
+
+func structFields(t reflect.Type) []structField {
+	if v, ok := structFieldsCache.Load(t); ok {
+		return v.([]structField)
+	}
+	fields := make([]structField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n, _, _ := strings.Cut(tag, ","); n != "" {
+				name = n
+			}
+		}
+		fields = append(fields, structField{name: name, index: i})
+	}
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
 func MapConvertSlice(input interface{}) []interface{} {
 	t := reflect.TypeOf(input).Kind()
 	if t != reflect.Map {
@@ -66,14 +139,16 @@ func MapConvertSlice(input interface{}) []interface{} {
 	return sli
 }
 
+// SliceConvertMap convert key/value pairs to a map, a non-string key is formatted with fmt.Sprint,
+// the unpaired last element is ignored.
 func SliceConvertMap(sli []any) map[string]interface{} {
-	mapObj := make(map[string]any, 0)
-	for i := 0; i < len(sli); i++ {
-		if i+1 >= len(sli) {
-			break
+	mapObj := make(map[string]any, len(sli)/2)
+	for i := 0; i+1 < len(sli); i += 2 {
+		key, ok := sli[i].(string)
+		if !ok {
+			key = fmt.Sprint(sli[i])
 		}
-		mapObj[sli[i].(string)] = sli[i+1]
-		i++
+		mapObj[key] = sli[i+1]
 	}
 	return mapObj
 }
@@ -0,0 +1,108 @@
+package fit
+
+import (
+	"reflect"
+	"testing"
+)
+
+type convertTestUser struct {
+	Id       int     `json:"id"`
+	Name     string  `json:"name"`
+	Email    string  `json:"email"`
+	Age      int     `json:"age"`
+	Balance  float64 `json:"balance"`
+	Disabled bool    `json:"disabled"`
+	Password string  `json:"-"`
+}
+
+var (
+	convertTestMap = map[string]any{
+		"id": 1, "name": "fit", "email": "fit@example.com", "age": 18, "balance": 1.5, "disabled": true,
+	}
+	convertTestValue = convertTestUser{Id: 1, Name: "fit", Email: "fit@example.com", Age: 18, Balance: 1.5, Disabled: true}
+	convertTestSlice = []any{"msg", "hello", "user_id", 1, "path", "/user", "method", "GET", "cost", 1.5, "ok", true}
+
+	// convertSink keeps the results of the benchmarks on the heap, as they are in real callers
+	convertSink any
+)
+
+// oldSliceConvertMap SliceConvertMap before the keys were preallocated and checked
+func oldSliceConvertMap(sli []any) map[string]interface{} {
+	mapObj := make(map[string]any, 0)
+	for i := 0; i < len(sli); i++ {
+		if i+1 >= len(sli) {
+			break
+		}
+		mapObj[sli[i].(string)] = sli[i+1]
+		i++
+	}
+	return mapObj
+}
+
+func TestConvertSameAsOld(t *testing.T) {
+	var old convertTestUser
+	if err := MapConvertStruct(convertTestMap, &old); err != nil {
+		t.Fatal(err)
+	}
+	got, err := MapToStruct[convertTestUser](convertTestMap)
+	if err != nil || got != old || got != convertTestValue {
+		t.Errorf("MapToStruct = %+v, %v, MapConvertStruct = %+v", got, err, old)
+	}
+
+	h, err := StructToMap(&convertTestValue)
+	if want := StructConvertMapByTag(convertTestValue, "json"); err != nil || !reflect.DeepEqual(map[string]any(h), want) {
+		t.Errorf("StructToMap = %v, %v, StructConvertMapByTag = %v", h, err, want)
+	}
+
+	if got, want := SliceConvertMap(convertTestSlice), oldSliceConvertMap(convertTestSlice); !reflect.DeepEqual(got, want) {
+		t.Errorf("SliceConvertMap = %v, old = %v", got, want)
+	}
+	// the old implementation panics on a non-string key
+	if got := SliceConvertMap([]any{1, "a", "b"}); !reflect.DeepEqual(got, map[string]any{"1": "a"}) {
+		t.Errorf("SliceConvertMap non-string key = %v", got)
+	}
+}
+
+func BenchmarkMapConvertStruct(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var out convertTestUser
+		_ = MapConvertStruct(convertTestMap, &out)
+		convertSink = out
+	}
+}
+
+func BenchmarkMapToStruct(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		convertSink, _ = MapToStruct[convertTestUser](convertTestMap)
+	}
+}
+
+func BenchmarkStructConvertMapByTag(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		convertSink = StructConvertMapByTag(convertTestValue, "json")
+	}
+}
+
+func BenchmarkStructToMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		convertSink, _ = StructToMap(&convertTestValue)
+	}
+}
+
+func BenchmarkSliceConvertMapOld(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		convertSink = oldSliceConvertMap(convertTestSlice)
+	}
+}
+
+func BenchmarkSliceConvertMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		convertSink = SliceConvertMap(convertTestSlice)
+	}
+}
@@ -1,9 +1,20 @@
 package fit
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"golang.org/x/crypto/bcrypt"
+	"io"
 )
 
 func PasswordHash(password string) (string, error) {
@@ -22,3 +33,160 @@ func MD5encryption(v string) string {
 	m.Write(d)
 	return hex.EncodeToString(m.Sum(nil))
 }
+
+var (
+	ErrInvalidAESKey  = errors.New("aes key must be 16, 24 or 32 bytes")
+	ErrWeakRSAKey     = errors.New("rsa key must be at least 2048 bits")
+	ErrCiphertextSize = errors.New("ciphertext too short")
+)
+
+const minRSABits = 2048
+
+// AESEncryptGCM encrypt with AES-GCM, a random nonce is generated and prepended to the ciphertext
+func AESEncryptGCM(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// AESDecryptGCM decrypt the output of AESEncryptGCM
+func AESDecryptGCM(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrCiphertextSize
+	}
+	nonce, data := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, ErrInvalidAESKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// RSAEncryptOAEP encrypt with RSA-OAEP(SHA-256), publicKeyPEM is a PKIX("PUBLIC KEY") or PKCS#1("RSA PUBLIC KEY") block
+func RSAEncryptOAEP(publicKeyPEM, plaintext []byte) ([]byte, error) {
+	pub, err := parseRSAPublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, plaintext, nil)
+}
+
+// RSADecryptOAEP decrypt the output of RSAEncryptOAEP, privateKeyPEM is a PKCS#8("PRIVATE KEY") or PKCS#1("RSA PRIVATE KEY") block
+func RSADecryptOAEP(privateKeyPEM, ciphertext []byte) ([]byte, error) {
+	priv, err := parseRSAPrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, ciphertext, nil)
+}
+
+// GenerateRSAKeyPair generate a key pair, PKCS#8 private key and PKIX public key in PEM
+func GenerateRSAKeyPair(bits int) (privateKeyPEM, publicKeyPEM string, err error) {
+	if bits < minRSABits {
+		return "", "", ErrWeakRSAKey
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", "", err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", "", err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	privateKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+	publicKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	return privateKeyPEM, publicKeyPEM, nil
+}
+
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid public key pem")
+	}
+	var pub *rsa.PublicKey
+	if block.Type == "RSA PUBLIC KEY" {
+		k, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = k
+	} else {
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rk, ok := k.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("not a rsa public key")
+		}
+		pub = rk
+	}
+	if pub.N.BitLen() < minRSABits {
+		return nil, ErrWeakRSAKey
+	}
+	return pub, nil
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid private key pem")
+	}
+	var priv *rsa.PrivateKey
+	if block.Type == "RSA PRIVATE KEY" {
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv = k
+	} else {
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not a rsa private key")
+		}
+		priv = rk
+	}
+	if priv.N.BitLen() < minRSABits {
+		return nil, ErrWeakRSAKey
+	}
+	return priv, nil
+}
+
+// HmacSHA256 HMAC-SHA256 of data
+func HmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// SecureCompare constant-time comparison, e.g. of signatures or tokens
+func SecureCompare(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
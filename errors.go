@@ -0,0 +1,113 @@
+package fit
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// CodeError an error carrying a business code(see ResponseOK.Code) and the caller that created it
+type CodeError struct {
+	code int
+	msg  string
+	err  error
+	pc   uintptr
+}
+
+// NewCodeErr an error with the business code, FailErr responds code and msg
+func NewCodeErr(code int, msg string) error {
+	return &CodeError{code: code, msg: msg, pc: callerPC()}
+}
+
+// Wrap annotate err with msg, only the pc of the caller is captured to stay cheap.
+// The code of err is kept, nil is returned when err is nil.
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return &CodeError{msg: msg, err: err, pc: callerPC()}
+}
+
+// Wrapf Wrap with a formatted message
+func Wrapf(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &CodeError{msg: fmt.Sprintf(format, args...), err: err, pc: callerPC()}
+}
+
+func callerPC() uintptr {
+	var pcs [1]uintptr
+	// skip runtime.Callers, callerPC and the constructor
+	if runtime.Callers(3, pcs[:]) == 0 {
+		return 0
+	}
+	return pcs[0]
+}
+
+func (e *CodeError) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	if e.msg == "" {
+		return e.err.Error()
+	}
+	return e.msg + ": " + e.err.Error()
+}
+
+func (e *CodeError) Unwrap() error {
+	return e.err
+}
+
+// Code the business code of the error itself, 0 for errors created by Wrap
+func (e *CodeError) Code() int {
+	return e.code
+}
+
+// Msg the message without the wrapped errors
+func (e *CodeError) Msg() string {
+	return e.msg
+}
+
+// Caller file:line where the error was created, empty when unknown
+func (e *CodeError) Caller() string {
+	if e.pc == 0 {
+		return ""
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{e.pc}).Next()
+	if frame.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+}
+
+// codeError the outermost CodeError of the chain with a non-zero code
+func codeError(err error) *CodeError {
+	for err != nil {
+		if ce, ok := err.(*CodeError); ok && ce.code != 0 {
+			return ce
+		}
+		err = errors.Unwrap(err)
+	}
+	return nil
+}
+
+// Code the business code of err, 0 when no error of the chain carries one(e.g. errors created by NewErr)
+func Code(err error) int {
+	if ce := codeError(err); ce != nil {
+		return ce.code
+	}
+	return 0
+}
+
+// RootCause the innermost error of the chain
+func RootCause(err error) error {
+	for err != nil {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+	return nil
+}
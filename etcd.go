@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -140,6 +144,100 @@ func (e *EtcdHandle) GetByPrefix(prefix string) (*clientv3.GetResponse, error) {
 	return getResp, nil
 }
 
+var (
+	// ErrEtcdKeyNotFound the key does not exist
+	ErrEtcdKeyNotFound = errors.New("etcd key not found")
+	// ErrEtcdUnavailable the request failed, the original error is kept, use errors.Is to check
+	ErrEtcdUnavailable = errors.New("etcd unavailable")
+)
+
+type etcdError struct {
+	err error
+}
+
+func (e *etcdError) Error() string {
+	return "etcd: " + e.err.Error()
+}
+
+func (e *etcdError) Is(target error) bool {
+	return target == ErrEtcdUnavailable
+}
+
+func (e *etcdError) Unwrap() error {
+	return e.err
+}
+
+// GetJSON json.Unmarshal the value of key into out, ErrEtcdKeyNotFound when the key does not exist
+func (e *EtcdHandle) GetJSON(key string, out any) error {
+	resp, err := e.EtcdClient.Get(e.ctx, key)
+	if err != nil {
+		return &etcdError{err: err}
+	}
+	if len(resp.Kvs) == 0 {
+		return fmt.Errorf("%w: %s", ErrEtcdKeyNotFound, key)
+	}
+	if err := json.Unmarshal(resp.Kvs[0].Value, out); err != nil {
+		return fmt.Errorf("etcd: decode %s: %w", key, err)
+	}
+	return nil
+}
+
+// GetPrefixJSON json.Unmarshal the values under prefix into the values created by factory, keyed by the full key
+func (e *EtcdHandle) GetPrefixJSON(prefix string, factory func() any) (map[string]any, error) {
+	resp, err := e.EtcdClient.Get(e.ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, &etcdError{err: err}
+	}
+	result := make(map[string]any, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		v := factory()
+		if err := json.Unmarshal(kv.Value, v); err != nil {
+			return nil, fmt.Errorf("etcd: decode %s: %w", kv.Key, err)
+		}
+		result[string(kv.Key)] = v
+	}
+	return result, nil
+}
+
+// ListKeys at most limit keys under prefix in ascending order, starting after startAfter(empty from the beginning).
+// Pass the last key of a page as startAfter to get the next page, limit <= 0 returns all the keys.
+func (e *EtcdHandle) ListKeys(prefix string, limit int64, startAfter string) ([]string, error) {
+	start := prefix
+	if startAfter != "" {
+		start = startAfter + "\x00"
+	}
+	ops := []clientv3.OpOption{
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)),
+		clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+	}
+	if limit > 0 {
+		ops = append(ops, clientv3.WithLimit(limit))
+	}
+	resp, err := e.EtcdClient.Get(e.ctx, start, ops...)
+	if err != nil {
+		return nil, &etcdError{err: err}
+	}
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
+// PutJSON json.Marshal v and put it, see Put for ttl
+func (e *EtcdHandle) PutJSON(key string, v any, ttl ...int64) (*clientv3.PutResponse, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.Put(key, string(data), ttl...)
+	if err != nil {
+		return nil, &etcdError{err: err}
+	}
+	return resp, nil
+}
+
 func (e *EtcdHandle) WatchPrefix() func(prefix string, data chan *clientv3.Event) {
 	return func(prefix string, data chan *clientv3.Event) {
 		watcher := e.EtcdClient.Watch(e.ctx, prefix, clientv3.WithPrefix())
@@ -219,19 +317,260 @@ func ExtractValAndToMap(resp *clientv3.GetResponse) (result map[string]any, err
 	return result, nil
 }
 
+// EndpointHealth status of an etcd endpoint
+type EndpointHealth struct {
+	Endpoint  string        `json:"endpoint"`
+	Reachable bool          `json:"reachable"`
+	Latency   time.Duration `json:"latency"`
+	IsLeader  bool          `json:"is_leader"`
+	Err       string        `json:"err,omitempty"`
+}
+
+type EndpointsHealth []EndpointHealth
+
+// Reachable number of the reachable endpoints
+func (h EndpointsHealth) Reachable() int {
+	n := 0
+	for _, e := range h {
+		if e.Reachable {
+			n++
+		}
+	}
+	return n
+}
+
+// PingEtcdCtx query the status of every endpoint concurrently, an error is returned when none is reachable
+func PingEtcdCtx(ctx context.Context) (EndpointsHealth, error) {
+	cli := MainEtcdClientv3()
+	if cli == nil {
+		return nil, NewErr("etcd instance not found!")
+	}
+
+	endpoints := cli.Endpoints()
+	health := make(EndpointsHealth, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			start := time.Now()
+			resp, err := cli.Status(ctx, endpoint)
+			h := EndpointHealth{Endpoint: endpoint, Latency: time.Since(start)}
+			if err != nil {
+				h.Err = err.Error()
+			} else {
+				h.Reachable = true
+				h.IsLeader = resp.Header != nil && resp.Leader == resp.Header.MemberId
+			}
+			health[i] = h
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	if health.Reachable() == 0 {
+		if err := ctx.Err(); err != nil {
+			return health, &etcdError{err: err}
+		}
+		return health, &etcdError{err: errors.New("no reachable endpoint")}
+	}
+	return health, nil
+}
+
+// PingEtcd see PingEtcdCtx, the default timeout is 3s
 func PingEtcd(ctx ...context.Context) error {
-	var rootCtx context.Context
+	rootCtx := context.Background()
 	if len(ctx) > 0 {
 		rootCtx = ctx[0]
-	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	}
+	if _, ok := rootCtx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		rootCtx, cancel = context.WithTimeout(rootCtx, time.Second*3)
 		defer cancel()
-		rootCtx = ctx
 	}
 
-	_, err := MainEtcdClientv3().Get(rootCtx, "/")
+	_, err := PingEtcdCtx(rootCtx)
+	return err
+}
+
+type watchConfigOption struct {
+	prefix bool
+}
+
+type WatchConfigOption func(*watchConfigOption)
+
+// WatchConfigPrefix directory-style config, the child keys(relative to the prefix) are merged into a map
+// before being unmarshalled, e.g. /config/app/db and /config/app/redis -> {"db": ..., "redis": ...}
+func WatchConfigPrefix() WatchConfigOption {
+	return func(c *watchConfigOption) {
+		c.prefix = true
+	}
+}
+
+// ConfigWatcher keeps the value of WatchConfig up to date
+type ConfigWatcher struct {
+	mux      sync.RWMutex
+	client   *clientv3.Client
+	key      string
+	typ      reflect.Type
+	current  interface{}
+	revision int64
+	prefix   bool
+}
+
+// WatchConfig get the key, json.Unmarshal it into out(pointer to struct or map) and keep watching it,
+// onChange is called after every update with the result of the update.
+//
+// out is only filled by the first load, the updated values are read through Get.
+// When the watch fails(e.g. the revision was compacted) the key is read again and watched from its revision.
+func WatchConfig(ctx context.Context, key string, out interface{}, onChange func(err error), opts ...WatchConfigOption) (*ConfigWatcher, error) {
+	if client == nil {
+		return nil, NewErr("etcd instance not found!")
+	}
+	return watchConfig(ctx, client, key, out, onChange, opts...)
+}
+
+// WatchConfig see WatchConfig
+func (e *EtcdHandle) WatchConfig(key string, out interface{}, onChange func(err error), opts ...WatchConfigOption) (*ConfigWatcher, error) {
+	return watchConfig(e.ctx, e.EtcdClient, key, out, onChange, opts...)
+}
+
+func watchConfig(ctx context.Context, cli *clientv3.Client, key string, out interface{}, onChange func(err error), opts ...WatchConfigOption) (*ConfigWatcher, error) {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, errors.New("out must be a non-nil pointer")
+	}
+	var option watchConfigOption
+	for _, opt := range opts {
+		opt(&option)
+	}
+
+	w := &ConfigWatcher{
+		client: cli,
+		key:    key,
+		typ:    rv.Elem().Type(),
+		prefix: option.prefix,
+	}
+	if err := w.load(ctx); err != nil {
+		return nil, err
+	}
+	// the watch goroutine is not started yet, the later updates only replace current
+	rv.Elem().Set(reflect.ValueOf(w.current).Elem())
+
+	go w.watch(ctx, onChange)
+	return w, nil
+}
+
+// Get the latest value, a pointer of the same type as out, it must not be modified
+func (w *ConfigWatcher) Get() interface{} {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	return w.current
+}
+
+// Revision etcd revision of the latest value
+func (w *ConfigWatcher) Revision() int64 {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	return w.revision
+}
+
+func (w *ConfigWatcher) load(ctx context.Context) error {
+	var ops []clientv3.OpOption
+	if w.prefix {
+		ops = append(ops, clientv3.WithPrefix())
+	}
+	resp, err := w.client.Get(ctx, w.key, ops...)
 	if err != nil {
 		return err
 	}
+
+	var data []byte
+	if w.prefix {
+		children := make(map[string]interface{}, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			name := strings.Trim(strings.TrimPrefix(string(kv.Key), w.key), "/")
+			if json.Valid(kv.Value) {
+				children[name] = json.RawMessage(kv.Value)
+			} else {
+				children[name] = string(kv.Value)
+			}
+		}
+		if data, err = json.Marshal(children); err != nil {
+			return err
+		}
+	} else {
+		if len(resp.Kvs) == 0 {
+			return errors.New("config key not found: " + w.key)
+		}
+		data = resp.Kvs[0].Value
+	}
+
+	return w.apply(data, resp.Header.Revision)
+}
+
+func (w *ConfigWatcher) apply(data []byte, revision int64) error {
+	value := reflect.New(w.typ)
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return err
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.current = value.Interface()
+	w.revision = revision
 	return nil
 }
+
+func (w *ConfigWatcher) watch(ctx context.Context, onChange func(err error)) {
+	for {
+		w.watchFrom(ctx, w.Revision()+1, onChange)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		// the changes since the revision may be lost(e.g. compacted), read the current value
+		err := w.load(ctx)
+		if onChange != nil {
+			onChange(err)
+		}
+	}
+}
+
+// watchFrom watch the changes from revision until the watch fails or ctx is done
+func (w *ConfigWatcher) watchFrom(ctx context.Context, revision int64, onChange func(err error)) {
+	// canceled on return, a failed watch would keep running otherwise
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ops := []clientv3.OpOption{clientv3.WithRev(revision)}
+	if w.prefix {
+		ops = append(ops, clientv3.WithPrefix())
+	}
+	for resp := range w.client.Watch(ctx, w.key, ops...) {
+		if err := resp.Err(); err != nil {
+			if onChange != nil && ctx.Err() == nil {
+				onChange(err)
+			}
+			return
+		}
+		if len(resp.Events) == 0 {
+			continue
+		}
+
+		var err error
+		if w.prefix {
+			// reload the whole directory so that deleted children disappear
+			err = w.load(ctx)
+		} else {
+			ev := resp.Events[len(resp.Events)-1]
+			if ev.Type == clientv3.EventTypeDelete {
+				err = errors.New("config key deleted: " + w.key)
+			} else {
+				err = w.apply(ev.Kv.Value, ev.Kv.ModRevision)
+			}
+		}
+		if onChange != nil {
+			onChange(err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+type watchTestConfig struct {
+	Name string `json:"name"`
+}
+
+func TestWatchConfigGet(t *testing.T) {
+	c := useEmbedEtcd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const key = "/config/watch-test"
+	if _, err := c.Put(ctx, key, `{"name":"a"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg watchTestConfig
+	w, err := watchConfig(ctx, c, key, &cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "a" || w.Get().(*watchTestConfig).Name != "a" {
+		t.Fatalf("first load = %+v, %+v", cfg, w.Get())
+	}
+
+	// the updates are read through Get while they are applied
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = w.Get().(*watchTestConfig).Name
+			}
+		}
+	}()
+	for _, name := range []string{"b", "c"} {
+		if _, err := c.Put(ctx, key, `{"name":"`+name+`"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ok := waitFor(t, time.Second*5, func() bool { return w.Get().(*watchTestConfig).Name == "c" })
+	close(stop)
+	wg.Wait()
+	if !ok {
+		t.Fatalf("Get = %+v, want the update", w.Get())
+	}
+	// out is not written from the watch goroutine
+	if cfg.Name != "a" {
+		t.Fatalf("out = %+v, want the first load", cfg)
+	}
+}
+
+func TestWatchConfigCompacted(t *testing.T) {
+	c := useEmbedEtcd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const key = "/config/compact-test"
+	first, err := c.Put(ctx, key, `{"name":"a"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Put(ctx, key, `{"name":"b"}`); err != nil {
+		t.Fatal(err)
+	}
+	last, err := c.Put(ctx, key, `{"name":"b"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Compact(ctx, last.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+
+	// a watcher that fell behind the compaction, the changes after first were compacted
+	w := &ConfigWatcher{client: c, key: key, typ: reflect.TypeOf(watchTestConfig{}), revision: first.Header.Revision,
+		current: &watchTestConfig{Name: "a"}}
+	changes := make(chan error, 16)
+	go w.watch(ctx, func(err error) { changes <- err })
+
+	next := func() error {
+		t.Helper()
+		select {
+		case err := <-changes:
+			return err
+		case <-time.After(time.Second * 5):
+			t.Fatal("no change reported")
+			return nil
+		}
+	}
+	if err := next(); !errors.Is(err, rpctypes.ErrCompacted) {
+		t.Fatalf("first change = %v, want ErrCompacted", err)
+	}
+	if err := next(); err != nil {
+		t.Fatalf("reload = %v", err)
+	}
+	if got := w.Get().(*watchTestConfig).Name; got != "b" || w.Revision() < last.Header.Revision {
+		t.Fatalf("after reload: %s at %d", got, w.Revision())
+	}
+
+	// watching again from the new revision
+	if _, err := c.Put(ctx, key, `{"name":"c"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := next(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Get().(*watchTestConfig).Name; got != "c" {
+		t.Fatalf("after update: %s", got)
+	}
+}
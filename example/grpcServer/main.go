@@ -8,10 +8,8 @@ import (
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
 	"log"
-	"net"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 )
@@ -46,7 +44,8 @@ func main() {
 		DialTimeout: time.Second * 5,
 	})
 
-	listen, err := net.Listen("tcp", ":0")
+	//监听随机可用端口，addr 为 本机ip:端口
+	listen, addr, err := fit.ListenFreePort("")
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -79,26 +78,9 @@ func main() {
 	//
 	opts = append(opts, grpc.Creds(cred))
 
-	localIp, err := fit.GetOutBoundIP()
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	addr := net.JoinHostPort(localIp, strconv.Itoa(fit.GetListenPort(listen)))
-
-	//日志收集
-	//由于只能设置一个拦截器，如果想使用拦截器，需要添加一个hook
-	//gt.GrpcHook(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	//	//如果不调用handler，将不会继续往下处理
-	//	res, err := handler(ctx, req)
-	//	return res, err
-	//})
-	////注意：这是一元拦截器
-	//opts = append(opts, grpc.UnaryInterceptor(gt.GrpcServerInterceptor()))
-
-	//
+	//日志收集与计数器，按推荐顺序组合拦截器
 	stat := fit.NewStatUnfinished()
-	opts = append(opts, grpc.UnaryInterceptor(stat.GrpcStatUnfinished()))
+	opts = append(opts, fit.ChainDefaultInterceptors(fit.InterceptorStat(stat), fit.InterceptorTrace(gt))...)
 
 	rpcServer := grpc.NewServer(opts...)
 
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"github.com/source-build/go-fit"
+	"github.com/source-build/go-fit/pb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type phoneSms struct {
+	pb.UnimplementedPhoneLoginSmsVerCodeServer
+}
+
+func main() {
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{"127.0.0.1:2379"}, DialTimeout: time.Second * 5})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer client.Close()
+
+	s, err := fit.NewGrpcService(fit.GrpcServiceConfig{
+		CertPool:  &fit.CertPool{CertFile: "keys/server.crt", KeyFile: "keys/server.key", CaCert: "keys/ca.crt"},
+		Stat:      fit.NewStatUnfinished(),
+		LinkTrace: fit.NewLinkTrace("track"),
+		Register:  &fit.ServiceRegister{Client: client, Key: "/serves/rpc/test_system", Lease: 20},
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	pb.RegisterPhoneLoginSmsVerCodeServer(s.Server(), new(phoneSms))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := s.Run(ctx); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -90,9 +90,9 @@ func main() {
 	g.GET("/mysql_gorm", func(c *gin.Context) {
 		var system SystemMenu
 		//使用WithContext(c)传递上下文，将会记录本次查询的行为
-		//需要在初始化mysql时开启才生效
-		//fit.TraceCaller() 记录文件名与行数
-		fit.MainMysql().Set(fit.TraceCaller()).WithContext(c).Where("id = ?", 9).Take(&system)
+		//需要在初始化mysql时开启才生效，自建的 *gorm.DB 可通过 db.Use(fit.NewGormTracePlugin()) 开启
+		//文件名与行数会自动记录
+		fit.MainMysql().WithContext(c).Where("id = ?", 9).Take(&system)
 		c.String(http.StatusOK, "OK")
 	})
 
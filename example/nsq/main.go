@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/nsqio/go-nsq"
 	"github.com/source-build/go-fit"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 type MyHandler struct {
@@ -24,13 +26,23 @@ func main() {
 		Channel: "abnormalError",
 		Address: "127.0.0.1:4161",
 		Handler: &handler,
+		//Addresses:          []string{"127.0.0.1:4161", "127.0.0.1:4261"}, //多个nsqlookupd
+		//MaxInFlight:        10, //同时处理中的最大消息数
+		//HandlerConcurrency: 10, //处理消息的协程数
 	}
-	err := fit.InitConsumer(config)
+	consumer, err := fit.InitConsumer(config)
 	if err != nil {
 		fmt.Printf("init consumer failed, err:%v\n", err)
 		return
 	}
-	c := make(chan os.Signal)        // 定义一个信号的通道
+	c := make(chan os.Signal, 1)     // 定义一个信号的通道
 	signal.Notify(c, syscall.SIGINT) // 转发键盘中断信号到c
 	<-c
+
+	//停止接收消息，并等待处理中的消息完成
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := consumer.Stop(ctx); err != nil {
+		fmt.Printf("stop consumer failed, err:%v\n", err)
+	}
 }
@@ -45,7 +45,7 @@ func main() {
 	// mq.DefExchangeDeclare(名称,模式,持久化,自动删除) 默认交换器。参数模式: 可选值 fit.KIND_*
 	// mq.ExchangeDeclare() 跟官方的参数一致，有点多，自己点进去看😊
 	// 小贴士: 同样支持链式调用,像这样：mq.DefExchangeDeclare().PublishPub()
-	//mq.DefExchangeDeclare("exchange_test", fit.KIND_FANOU,false,false)
+	//mq.DefExchangeDeclare("exchange_test", fit.KIND_FANOUT, false, false)
 
 	// 投递消息
 	// PublishPub(msg,option) 订阅模式。msg:消息 option:可选项,当使用该参数时,其他参数都将失效,需要自己来传字段,key字段不需要传递。
@@ -77,8 +77,7 @@ func main() {
 	for msg := range simple {
 		fmt.Println(string(msg.Body))
 		//主动应答
-		//如果autoAck字段为false(默认)，则需要手动调用msg.Ack(),否则会造成内存溢出
-		//如果autoAck字段为true,则服务器将自动确认每条消息，并且不应调用此方法
+		//手动确认模式(默认)下未确认的消息达到 PrefetchCount 后服务端将不再推送
 		err := msg.Ack(true)
 		if err != nil {
 			log.Fatal("主动应答失败:", err)
@@ -51,7 +51,7 @@ func main() {
 	}
 
 	// 模拟内存使用量为1000字节，因此QPS阈值应为1000
-	//fmt.Println("内存使用量为999:", new(fitutil.ParseTime).HSM(time.Now().Unix()))
+	//fmt.Println("内存使用量为999:", fit.FormatUnix(time.Now().Unix(), fit.HMS))
 	//system_metric.SetSystemMemoryUsage(999)
 	ch := make(chan bool)
 	//for i := 0; i < 10; i++ {
@@ -74,17 +74,17 @@ func main() {
 	//	time.Sleep(time.Second * 5)
 	//	// 模拟内存使用量为1536字节，因此QPS阈值应为550
 	//	system_metric.SetSystemMemoryUsage(1536)
-	//	fmt.Println("内存使用量为1536:", new(fitutil.ParseTime).HSM(time.Now().Unix()))
+	//	fmt.Println("内存使用量为1536:", fit.FormatUnix(time.Now().Unix(), fit.HMS))
 	//
 	//	time.Sleep(time.Second * 5)
 	//	// 模拟内存使用量为1536字节，因此QPS阈值应为100
 	//	system_metric.SetSystemMemoryUsage(2048)
-	//	fmt.Println("内存使用量为2048:", new(fitutil.ParseTime).HSM(time.Now().Unix()))
+	//	fmt.Println("内存使用量为2048:", fit.FormatUnix(time.Now().Unix(), fit.HMS))
 	//
 	//	time.Sleep(time.Second * 5)
 	//	// mock memory usage is 1536 bytes, so QPS threshold should be 100
 	//	system_metric.SetSystemMemoryUsage(100000)
-	//	fmt.Println("内存使用量为100000:", new(fitutil.ParseTime).HSM(time.Now().Unix()))
+	//	fmt.Println("内存使用量为100000:", fit.FormatUnix(time.Now().Unix(), fit.HMS))
 	//	time.Sleep(time.Second * 5)
 	//	ch <- true
 	//}()
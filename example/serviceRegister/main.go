@@ -40,24 +40,12 @@ func main() {
 	/* grpc 使用 */
 	var opts []grpc.ServerOption
 
-	//日志收集
-	//由于只能设置一个拦截器，如果想使用拦截器，需要添加一个hook
+	//日志收集，与计数器一起使用时按推荐顺序组合拦截器
 	gt := fit.NewLinkTrace()
-	gt.GrpcHook(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if err := stat.GrpcHandleStatUnfinished(); err != nil {
-			return nil, err
-		}
-
-		stat.Add()
-		res, err := handler(ctx, req)
-		stat.Sub()
-
-		return res, err
-	})
-	//opts = append(opts, grpc.UnaryInterceptor(gt.GrpcServerInterceptor()))
+	opts = append(opts, fit.ChainDefaultInterceptors(fit.InterceptorStat(stat), fit.InterceptorTrace(gt))...)
 
 	//不使用日志收集的话直接使用拦截器
-	opts = append(opts, grpc.UnaryInterceptor(stat.GrpcStatUnfinished()))
+	//opts = append(opts, grpc.UnaryInterceptor(stat.GrpcStatUnfinished()))
 
 	grpc.NewServer(opts...)
 
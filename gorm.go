@@ -1,13 +1,22 @@
 package fit
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/gin-gonic/gin"
+	mysqlDriver "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+	"gorm.io/plugin/dbresolver"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -74,12 +83,13 @@ func NewMysqlDefConnect(config DefaultConfigMysql, useTrace bool) error {
 	}
 
 	if useTrace {
-		if err = client.Use(new(TracePlugin)); err != nil {
+		if err = client.Use(NewGormTracePlugin()); err != nil {
 			return err
 		}
 	}
 
 	mysqlClient = client
+	mysqlNodes = nil
 
 	// connection pool,use default config
 	sqlDB, err = client.DB()
@@ -99,6 +109,14 @@ func CloseSqlDB() {
 			Error(err)
 		}
 	}
+	for _, node := range mysqlNodes {
+		if node.db == sqlDB {
+			continue
+		}
+		if err := node.db.Close(); err != nil {
+			Error(err)
+		}
+	}
 }
 
 // NewMysqlConnect  init new mysql_gorm client
@@ -111,13 +129,14 @@ func NewMysqlConnect(addr string, config *gorm.Config, useTrace bool) (*sql.DB,
 	}
 
 	if useTrace {
-		err = client.Use(new(TracePlugin))
+		err = client.Use(NewGormTracePlugin())
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	mysqlClient = client
+	mysqlNodes = nil
 	sqlDB, err = client.DB()
 	if err != nil {
 		return nil, err
@@ -130,58 +149,255 @@ func MainMysql() *gorm.DB {
 	return mysqlClient
 }
 
-func before(db *gorm.DB) {
-	db.InstanceSet("startTime", time.Now())
-	return
+// MysqlPoolConfig connection pool of a node group
+type MysqlPoolConfig struct {
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// MysqlClusterConfig primary + read replicas,
+// DSN format: root:123@tcp(127.0.0.1:3369)/foo?charset=utf8mb4&parseTime=True&loc=Local
+type MysqlClusterConfig struct {
+	Primary     string
+	Replicas    []string
+	PrimaryPool MysqlPoolConfig
+	ReplicaPool MysqlPoolConfig
+	// optional, default &gorm.Config{}
+	Gorm     *gorm.Config
+	UseTrace bool
+}
+
+type mysqlNode struct {
+	name string
+	addr string
+	db   *sql.DB
+}
+
+var mysqlNodes []mysqlNode
+
+// NewMysqlCluster init mysql with read/write splitting,
+// queries go to the replicas(round-robin), writes and transactions go to the primary.
+// MainMysql is used the same way as a single node.
+func NewMysqlCluster(config MysqlClusterConfig) error {
+	if config.Primary == "" {
+		return errors.New("primary DSN cannot be empty")
+	}
+	cf := config.Gorm
+	if cf == nil {
+		cf = &gorm.Config{}
+	}
+	defaultMysqlPool(&config.PrimaryPool)
+	defaultMysqlPool(&config.ReplicaPool)
+
+	client, err := gorm.Open(mysql.Open(config.Primary), cf)
+	if err != nil {
+		return err
+	}
+	primary, err := client.DB()
+	if err != nil {
+		return err
+	}
+	setMysqlPool(primary, config.PrimaryPool)
+	nodes := []mysqlNode{{name: "primary", addr: mysqlDSNAddr(config.Primary), db: primary}}
+
+	if len(config.Replicas) > 0 {
+		replicas := make([]gorm.Dialector, 0, len(config.Replicas))
+		for i, dsn := range config.Replicas {
+			db, err := sql.Open("mysql", dsn)
+			if err != nil {
+				closeMysqlNodes(nodes)
+				return err
+			}
+			setMysqlPool(db, config.ReplicaPool)
+			nodes = append(nodes, mysqlNode{name: fmt.Sprintf("replica-%d", i), addr: mysqlDSNAddr(dsn), db: db})
+			replicas = append(replicas, mysql.New(mysql.Config{DSN: dsn, Conn: db}))
+		}
+		err = client.Use(dbresolver.Register(dbresolver.Config{
+			Replicas: replicas,
+			Policy:   &roundRobinPolicy{},
+		}))
+		if err != nil {
+			closeMysqlNodes(nodes)
+			return err
+		}
+	}
+
+	if config.UseTrace {
+		if err = client.Use(NewGormTracePlugin()); err != nil {
+			closeMysqlNodes(nodes)
+			return err
+		}
+	}
+
+	mysqlClient = client
+	sqlDB = primary
+	mysqlNodes = nodes
+	return nil
+}
+
+// MysqlNodeStatus result of MysqlPing for a single node
+type MysqlNodeStatus struct {
+	Name string
+	Addr string
+	Err  error
+}
+
+// MysqlPing check all nodes, the error lists the nodes that are down
+func MysqlPing(ctx context.Context) ([]MysqlNodeStatus, error) {
+	nodes := mysqlNodes
+	if len(nodes) == 0 && sqlDB != nil {
+		nodes = []mysqlNode{{name: "primary", db: sqlDB}}
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("mysql instance not found")
+	}
+
+	result := make([]MysqlNodeStatus, 0, len(nodes))
+	var down []string
+	for _, node := range nodes {
+		err := node.db.PingContext(ctx)
+		if err != nil {
+			down = append(down, node.name)
+		}
+		result = append(result, MysqlNodeStatus{Name: node.name, Addr: node.addr, Err: err})
+	}
+	if len(down) > 0 {
+		return result, fmt.Errorf("mysql nodes are down: %s", strings.Join(down, ","))
+	}
+	return result, nil
+}
+
+type roundRobinPolicy struct {
+	next uint64
+}
+
+func (p *roundRobinPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	n := atomic.AddUint64(&p.next, 1)
+	return connPools[(n-1)%uint64(len(connPools))]
+}
+
+func defaultMysqlPool(c *MysqlPoolConfig) {
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = 25
+	}
+	if c.MaxOpenConns <= 0 {
+		c.MaxOpenConns = 200
+	}
+	if c.ConnMaxLifetime == 0 {
+		c.ConnMaxLifetime = time.Hour
+	}
+}
+
+func setMysqlPool(db *sql.DB, c MysqlPoolConfig) {
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+}
+
+func mysqlDSNAddr(dsn string) string {
+	c, err := mysqlDriver.ParseDSN(dsn)
+	if err != nil {
+		return ""
+	}
+	return c.Addr
+}
+
+func closeMysqlNodes(nodes []mysqlNode) {
+	for _, node := range nodes {
+		_ = node.db.Close()
+	}
+}
+
+const gormTraceStartKey = "fit:trace_start_time"
+
+// NewGormTracePlugin create a gorm plugin that records the executed SQL into the Trace
+// carried by the statement context, for example: fit.MainMysql().WithContext(c).Take(&user)
+//
+// It can be installed on any *gorm.DB by db.Use(fit.NewGormTracePlugin()),
+// statements without a Trace in the context are not recorded.
+func NewGormTracePlugin() *TracePlugin {
+	return &TracePlugin{}
 }
 
 type TracePlugin struct{}
 
 func (t TracePlugin) Name() string {
-	return "tracePlugin"
+	return "fit:trace_plugin"
 }
 
 func (t TracePlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
 	// start
-	_ = db.Callback().Create().Before("gorm:before_create").Register("before_create", before)
-	_ = db.Callback().Query().Before("gorm:query").Register("query", before)
-	_ = db.Callback().Delete().Before("gorm:before_delete").Register("before_delete", before)
-	_ = db.Callback().Update().Before("gorm:setup_reflect_value").Register("setup_reflect_value", before)
-	_ = db.Callback().Row().Before("gorm:row").Register("row", before)
-	_ = db.Callback().Raw().Before("gorm:raw").Register("raw", before)
+	if err := cb.Create().Before("gorm:before_create").Register("fit:trace_before_create", beforeTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("fit:trace_before_query", beforeTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:before_delete").Register("fit:trace_before_delete", beforeTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:setup_reflect_value").Register("fit:trace_before_update", beforeTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("fit:trace_before_row", beforeTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register("fit:trace_before_raw", beforeTraceHandler); err != nil {
+		return err
+	}
 	// end
-	_ = db.Callback().Create().After("gorm:after_create").Register("after_create", afterTraceHandler)
-	_ = db.Callback().Query().After("gorm:after_query").Register("after_query", afterTraceHandler)
-	_ = db.Callback().Delete().After("gorm:after_delete").Register("after_delete", afterTraceHandler)
-	_ = db.Callback().Update().After("gorm:after_update").Register("after_update", afterTraceHandler)
-	_ = db.Callback().Row().After("gorm:row").Register("row_handler", afterTraceHandler)
-	_ = db.Callback().Raw().After("gorm:raw").Register("raw_handler", afterTraceHandler)
-	return nil
+	if err := cb.Create().After("gorm:after_create").Register("fit:trace_after_create", afterTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:after_query").Register("fit:trace_after_query", afterTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:after_delete").Register("fit:trace_after_delete", afterTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:after_update").Register("fit:trace_after_update", afterTraceHandler); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register("fit:trace_after_row", afterTraceHandler); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register("fit:trace_after_raw", afterTraceHandler)
 }
 
-func afterTraceHandler(db *gorm.DB) {
-	ctx := db.Statement.Context
-	gCtx, ok := ctx.(*gin.Context)
-	if !ok {
+func beforeTraceHandler(db *gorm.DB) {
+	if db.Statement.Context == nil {
+		return
+	}
+	if _, ok := GetTraceCtx(db.Statement.Context); !ok {
 		return
 	}
+	db.InstanceSet(gormTraceStartKey, time.Now())
+}
 
-	trace, ok := GetGinTraceCtx(gCtx)
+func afterTraceHandler(db *gorm.DB) {
+	if db.Statement.Context == nil {
+		return
+	}
+	trace, ok := GetTraceCtx(db.Statement.Context)
 	if !ok {
 		return
 	}
 
-	_ts, ok := db.InstanceGet("startTime")
+	_ts, ok := db.InstanceGet(gormTraceStartKey)
 	if !ok {
 		return
 	}
 
 	ts := _ts.(time.Time)
+	elapsed := time.Since(ts)
 	sqlStr := db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...)
 	var stack string
-	link, ok := db.Get("TraceLineNum")
-	if ok {
+	if link, ok := db.Get("TraceLineNum"); ok {
 		stack = link.(string)
+	} else {
+		_, stack = filepath.Split(utils.FileWithLineNum())
 	}
 
 	trace.AppendSQL(&LinkTraceSQL{
@@ -189,17 +405,232 @@ func afterTraceHandler(db *gorm.DB) {
 		Stack:     stack,
 		SQL:       sqlStr,
 		Rows:      db.Statement.RowsAffected,
-		Cost:      time.Since(ts).String(),
+		StartMs:   ts.UnixMilli(),
+		EndMs:     ts.Add(elapsed).UnixMilli(),
+		CostMs:    DurationMs(elapsed),
+		Cost:      elapsed.String(),
 	})
 }
 
+type txCtxKey struct{}
+
+type txHolder struct {
+	tx *gorm.DB
+}
+
+type txConfig struct {
+	maxRetries int
+	backoff    time.Duration
+	opts       []*sql.TxOptions
+}
+
+type TxOption func(*txConfig)
+
+// TxMaxRetries number of retries on deadlock or lock wait timeout, default 3
+func TxMaxRetries(n int) TxOption {
+	return func(c *txConfig) {
+		c.maxRetries = n
+	}
+}
+
+// TxBackoff base interval between retries, doubled per attempt with jitter, default 20ms
+func TxBackoff(d time.Duration) TxOption {
+	return func(c *txConfig) {
+		c.backoff = d
+	}
+}
+
+// TxSqlOptions isolation level and read only flag of the transaction
+func TxSqlOptions(opts *sql.TxOptions) TxOption {
+	return func(c *txConfig) {
+		c.opts = []*sql.TxOptions{opts}
+	}
+}
+
+// WithTransaction run fn in a transaction of MainMysql.
+// The transaction is retried when MySQL reports a deadlock(1213) or lock wait timeout(1205),
+// so fn may be called more than once. Calls nested inside fn (using tx.Statement.Context) join the outer transaction.
+func WithTransaction(ctx context.Context, fn func(tx *gorm.DB) error, opts ...TxOption) error {
+	if holder, ok := ctx.Value(txCtxKey{}).(*txHolder); ok && holder.tx != nil {
+		return fn(holder.tx)
+	}
+	if mysqlClient == nil {
+		return errors.New("mysql instance not found")
+	}
+
+	config := &txConfig{maxRetries: 3, backoff: time.Millisecond * 20}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	trace, hasTrace := GetTraceCtx(ctx)
+	var err error
+	for attempt := 1; ; attempt++ {
+		holder := &txHolder{}
+		txCtx := context.WithValue(ctx, txCtxKey{}, holder)
+		start := time.Now()
+		err = mysqlClient.WithContext(txCtx).Transaction(func(tx *gorm.DB) error {
+			holder.tx = tx
+			return fn(tx)
+		}, config.opts...)
+		elapsed := time.Since(start)
+
+		if hasTrace {
+			result := "commit"
+			if err != nil {
+				result = "rollback: " + err.Error()
+			}
+			trace.AppendSQL(&LinkTraceSQL{
+				Timestamp: GetFullTime(start.Unix()),
+				SQL:       fmt.Sprintf("tx_attempt %d %s", attempt, result),
+				StartMs:   start.UnixMilli(),
+				EndMs:     start.Add(elapsed).UnixMilli(),
+				CostMs:    DurationMs(elapsed),
+				Cost:      elapsed.String(),
+			})
+		}
+
+		if err == nil || attempt > config.maxRetries || !isRetryableTxErr(err) {
+			return err
+		}
+
+		d := config.backoff << (attempt - 1)
+		d += time.Duration(rand.Int63n(int64(config.backoff) + 1))
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func isRetryableTxErr(err error) bool {
+	return IsDeadlockErr(err) || IsLockTimeoutErr(err)
+}
+
+type DBErrorKind int
+
+const (
+	DBErrUnknown DBErrorKind = iota
+	DBErrDuplicateKey
+	DBErrForeignKey
+	DBErrLockTimeout
+	DBErrDeadlock
+)
+
+var (
+	ErrDuplicateKey = errors.New("duplicate key")
+	ErrForeignKey   = errors.New("foreign key constraint fails")
+	ErrLockTimeout  = errors.New("lock wait timeout exceeded")
+	ErrDeadlock     = errors.New("deadlock found")
+)
+
+var dbErrKinds = map[DBErrorKind]error{
+	DBErrDuplicateKey: ErrDuplicateKey,
+	DBErrForeignKey:   ErrForeignKey,
+	DBErrLockTimeout:  ErrLockTimeout,
+	DBErrDeadlock:     ErrDeadlock,
+}
+
+// DBError classified database error, errors.Is matches the sentinel error of its kind,
+// errors.As still reaches the driver error.
+type DBError struct {
+	Kind DBErrorKind
+	// key name of a duplicate entry or constraint name of a foreign key error
+	Constraint string
+	Err        error
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DBError) Is(target error) bool {
+	return dbErrKinds[e.Kind] == target
+}
+
+var (
+	duplicateKeyRegexp = regexp.MustCompile("for key '([^']+)'")
+	foreignKeyRegexp   = regexp.MustCompile("CONSTRAINT `([^`]+)`")
+)
+
+// ClassifyDBError classify the mysql driver error,
+// constraint is the key name(duplicate entry) or constraint name(foreign key) if present.
+func ClassifyDBError(err error) (kind DBErrorKind, constraint string) {
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return dbErr.Kind, dbErr.Constraint
+	}
+
+	var mysqlErr *mysqlDriver.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return DBErrUnknown, ""
+	}
+	switch mysqlErr.Number {
+	case 1062:
+		if m := duplicateKeyRegexp.FindStringSubmatch(mysqlErr.Message); len(m) > 1 {
+			constraint = m[1]
+		}
+		return DBErrDuplicateKey, constraint
+	case 1216, 1217, 1451, 1452:
+		if m := foreignKeyRegexp.FindStringSubmatch(mysqlErr.Message); len(m) > 1 {
+			constraint = m[1]
+		}
+		return DBErrForeignKey, constraint
+	case 1205:
+		return DBErrLockTimeout, ""
+	case 1213:
+		return DBErrDeadlock, ""
+	}
+	return DBErrUnknown, ""
+}
+
+// WrapDBError wrap a classified error into *DBError, other errors are returned unchanged
+func WrapDBError(err error) error {
+	kind, constraint := ClassifyDBError(err)
+	if kind == DBErrUnknown {
+		return err
+	}
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	return &DBError{Kind: kind, Constraint: constraint, Err: err}
+}
+
+func IsDuplicateKeyErr(err error) bool {
+	kind, _ := ClassifyDBError(err)
+	return kind == DBErrDuplicateKey
+}
+
+func IsForeignKeyErr(err error) bool {
+	kind, _ := ClassifyDBError(err)
+	return kind == DBErrForeignKey
+}
+
+func IsLockTimeoutErr(err error) bool {
+	kind, _ := ClassifyDBError(err)
+	return kind == DBErrLockTimeout
+}
+
+func IsDeadlockErr(err error) bool {
+	kind, _ := ClassifyDBError(err)
+	return kind == DBErrDeadlock
+}
+
 func HandleGormQueryErrorFromTx(tx *gorm.DB) (*gorm.DB, error) {
 	return tx, HandleGormQueryError(tx.Error)
 }
 
+// HandleGormQueryError gorm.ErrRecordNotFound is treated as no error,
+// known mysql errors are wrapped into *DBError, use errors.Is(err, fit.ErrDuplicateKey) to check them.
 func HandleGormQueryError(err error) error {
-	if err == gorm.ErrRecordNotFound {
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil
 	}
-	return err
+	return WrapDBError(err)
 }
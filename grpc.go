@@ -1,17 +1,26 @@
 package fit
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	sentinel "github.com/alibaba/sentinel-golang/api"
 	"github.com/avast/retry-go/v4"
 	"go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
 	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -68,16 +77,19 @@ func NewGrpcClientBuilder(g GrpcBuilderConfig) error {
 
 // GrpcDial gRPC client
 //
+// serveName is resolved through etcd, a full target such as "dns:///user.default.svc:50051"
+// is passed to grpc as is, bypassing etcd.
+//
 // Please call NewDefaultBuilder or NewBuilder before calling this function
 func GrpcDial(serveName string, opts ...Option) (*grpc.ClientConn, error) {
 	if creds == nil {
-		return nil, errors.New("first, please call 'NewGrpcClientBuilder'")
+		return nil, fmt.Errorf("%w: first, please call 'NewGrpcClientBuilder'", ErrTLSConfig)
 	}
 	config := &Config{}
 	for _, opt := range opts {
 		opt(config)
 	}
-	target := scheme + "://" + serveName
+	target := grpcTarget(serveName)
 	defaultDialOption(config)
 
 	if len(config.rule) > 0 {
@@ -101,13 +113,13 @@ func GrpcDial(serveName string, opts ...Option) (*grpc.ClientConn, error) {
 
 func GrpcDialContext(serveName string, opts ...Option) (*grpc.ClientConn, error) {
 	if creds == nil {
-		return nil, errors.New("first, please call 'NewGrpcClientBuilder'")
+		return nil, fmt.Errorf("%w: first, please call 'NewGrpcClientBuilder'", ErrTLSConfig)
 	}
 	config := &Config{}
 	for _, opt := range opts {
 		opt(config)
 	}
-	target := scheme + "://" + serveName
+	target := grpcTarget(serveName)
 	defaultDialOption(config)
 	config.dialOptions = append(config.dialOptions, grpc.WithBlock())
 
@@ -137,7 +149,7 @@ func GrpcDialContext(serveName string, opts ...Option) (*grpc.ClientConn, error)
 			}
 			e.Exit()
 		}
-		return conn, err
+		return conn, dialError(serveName, err)
 	}
 
 	if config.attempts > 1 {
@@ -158,11 +170,120 @@ func GrpcDialContext(serveName string, opts ...Option) (*grpc.ClientConn, error)
 				ctx, cancel = context.WithTimeout(context.Background(), config.timeout)
 				return retry.BackOffDelay(n, err, c)
 			}),
+			retry.LastErrorOnly(true),
 		)
-		return conn, err
+		return conn, dialError(serveName, err)
+	}
+
+	conn, err := grpc.DialContext(config.ctx, target, config.dialOptions...)
+	return conn, dialError(serveName, err)
+}
+
+// ErrServiceNotFound there is no available instance of the service
+var ErrServiceNotFound = errors.New("no available services")
+
+var (
+	// ErrNoHealthyEndpoints instances of the service are registered but none of them is running,
+	// it is also an ErrServiceNotFound.
+	ErrNoHealthyEndpoints = fmt.Errorf("%w: no healthy endpoints", ErrServiceNotFound)
+	// ErrTLSConfig the client or server certificates are missing or invalid
+	ErrTLSConfig = errors.New("invalid tls config")
+	// ErrDialTimeout the connection was not ready before the dial timeout
+	ErrDialTimeout = errors.New("grpc dial timeout")
+)
+
+// ServiceError error of the discovery of or the connection to a service, with the service name and
+// the attempted endpoints, use errors.Is with the Err* variables to check the cause.
+type ServiceError struct {
+	Service   string
+	Endpoints []string
+	Err       error
+}
+
+func (e *ServiceError) Error() string {
+	if e.Service == "" {
+		return e.Err.Error()
+	}
+	return "service " + e.Service + ": " + e.Err.Error()
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// IsServiceNotFound see ErrServiceNotFound
+func IsServiceNotFound(err error) bool {
+	return errors.Is(err, ErrServiceNotFound)
+}
+
+// IsRetryable whether the failure is transient: no healthy endpoints, dial timeout, stale resolution
+// or the gRPC codes Unavailable and ResourceExhausted.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrNoHealthyEndpoints) || errors.Is(err, ErrDialTimeout) || errors.Is(err, ErrStaleResolution) {
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code() == codes.Unavailable || s.Code() == codes.ResourceExhausted
+	}
+	return false
+}
+
+func dialError(serveName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: %v", ErrDialTimeout, err)
 	}
+	return &ServiceError{Service: serveName, Err: err}
+}
+
+// GrpcDialStatic gRPC client balancing over a fixed address list instead of etcd,
+// the TLS and balancing options are the same as GrpcDial.
+func GrpcDialStatic(addrs []string, opts ...Option) (*grpc.ClientConn, error) {
+	r, target, err := staticResolver(addrs)
+	if err != nil {
+		return nil, err
+	}
+	return GrpcDial(target, append(opts, withResolvers(r))...)
+}
+
+// GrpcDialStaticContext see GrpcDialStatic and GrpcDialContext
+func GrpcDialStaticContext(addrs []string, opts ...Option) (*grpc.ClientConn, error) {
+	r, target, err := staticResolver(addrs)
+	if err != nil {
+		return nil, err
+	}
+	return GrpcDialContext(target, append(opts, withResolvers(r))...)
+}
+
+func staticResolver(addrs []string) (resolver.Builder, string, error) {
+	if len(addrs) == 0 {
+		return nil, "", ErrServiceNotFound
+	}
+	addresses := make([]resolver.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		addresses = append(addresses, resolver.Address{Addr: addr})
+	}
+	r := manual.NewBuilderWithScheme("static")
+	r.InitialState(resolver.State{Addresses: addresses})
+	return r, r.Scheme() + ":///static", nil
+}
+
+func withResolvers(rs ...resolver.Builder) Option {
+	return func(c *Config) {
+		c.dialOptions = append(c.dialOptions, grpc.WithResolvers(rs...))
+	}
+}
 
-	return grpc.DialContext(config.ctx, target, config.dialOptions...)
+func grpcTarget(serveName string) string {
+	if strings.Contains(serveName, "://") {
+		return serveName
+	}
+	return scheme + "://" + serveName
 }
 
 func CloseGrpc(conn *grpc.ClientConn) {
@@ -233,51 +354,181 @@ type CertPool struct {
 	KeyFile    string
 	CaCert     string
 	ServerName string
+	// Re-read the files at this interval, the new connections use the most recently loaded
+	// certificates, 0 means load once. The previous certificates are kept when the files are invalid.
+	ReloadInterval time.Duration
+	// Called after the changed files were reloaded(err is nil) or failed to reload
+	OnReload func(err error)
+
+	mux       sync.Mutex
+	reloaders []*certReloader
+}
+
+// Close stop reloading the files, call it after the server is stopped or the connections using the credentials are closed.
+// The credentials keep the most recently loaded certificates.
+func (c *CertPool) Close() {
+	c.mux.Lock()
+	reloaders := c.reloaders
+	c.reloaders = nil
+	c.mux.Unlock()
+	for _, r := range reloaders {
+		r.close()
+	}
+}
+
+type certReloader struct {
+	pool     *CertPool
+	env      string
+	config   atomic.Value // *tls.Config
+	raw      []byte
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func newTls(c *CertPool, env string) (credentials.TransportCredentials, error) {
 	if len(c.CertFile) == 0 {
-		return nil, errors.New("certFile Cannot be empty")
+		return nil, fmt.Errorf("%w: certFile Cannot be empty", ErrTLSConfig)
 	}
 	if len(c.KeyFile) == 0 {
-		return nil, errors.New("KeyFile Cannot be empty")
+		return nil, fmt.Errorf("%w: KeyFile Cannot be empty", ErrTLSConfig)
 	}
 	if len(c.CaCert) == 0 {
-		return nil, errors.New("CaCert Cannot be empty")
+		return nil, fmt.Errorf("%w: CaCert Cannot be empty", ErrTLSConfig)
 	}
 
-	pair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
-	if err != nil {
-		return nil, err
+	r := &certReloader{pool: c, env: env}
+	if _, err := r.load(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTLSConfig, err)
+	}
+	if c.ReloadInterval <= 0 {
+		return credentials.NewTLS(r.tlsConfig()), nil
 	}
 
-	certPool := x509.NewCertPool()
+	r.stop = make(chan struct{})
+	c.mux.Lock()
+	c.reloaders = append(c.reloaders, r)
+	c.mux.Unlock()
+	go r.watch()
+	if env == "server" {
+		return credentials.NewTLS(&tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return r.tlsConfig(), nil
+			},
+		}), nil
+	}
+	return &reloadClientCreds{r: r}, nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	return r.config.Load().(*tls.Config)
+}
+
+// load read the files, the config is replaced only when all of them are valid, changed reports whether they differ from the last load
+func (r *certReloader) load() (changed bool, err error) {
+	c := r.pool
+	certPEM, err := ioutil.ReadFile(c.CertFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := ioutil.ReadFile(c.KeyFile)
+	if err != nil {
+		return false, err
+	}
 	ca, err := ioutil.ReadFile(c.CaCert)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	raw := bytes.Join([][]byte{certPEM, keyPEM, ca}, nil)
+	if r.raw != nil && bytes.Equal(raw, r.raw) {
+		return false, nil
 	}
 
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+	certPool := x509.NewCertPool()
 	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		return nil, err
+		return false, errors.New("failed to parse CaCert")
 	}
 
-	var cred credentials.TransportCredentials
-	if env == "server" {
-		cred = credentials.NewTLS(&tls.Config{
+	var cfg *tls.Config
+	if r.env == "server" {
+		cfg = &tls.Config{
 			Certificates: []tls.Certificate{pair},
 			ClientAuth:   tls.RequireAndVerifyClientCert,
 			ClientCAs:    certPool,
-		})
-	}
-	if env == "client" {
-		cred = credentials.NewTLS(&tls.Config{
+			// returned by GetConfigForClient as is, grpc only adds h2 to the outer config
+			NextProtos: []string{"h2"},
+		}
+	} else {
+		cfg = &tls.Config{
 			Certificates: []tls.Certificate{pair},
 			ServerName:   c.ServerName,
 			RootCAs:      certPool,
-		})
+		}
+	}
+	r.config.Store(cfg)
+	r.raw = raw
+	return true, nil
+}
+
+func (r *certReloader) watch() {
+	ticker := time.NewTicker(r.pool.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.load()
+		if r.pool.OnReload != nil && (changed || err != nil) {
+			r.pool.OnReload(err)
+		}
+	}
+}
+
+func (r *certReloader) close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// reloadClientCreds handshake with the most recently loaded client config
+type reloadClientCreds struct {
+	r          *certReloader
+	serverName string
+}
+
+func (c *reloadClientCreds) current() credentials.TransportCredentials {
+	cfg := c.r.tlsConfig()
+	if c.serverName != "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = c.serverName
 	}
+	return credentials.NewTLS(cfg)
+}
+
+func (c *reloadClientCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadClientCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ServerHandshake(conn)
+}
+
+func (c *reloadClientCreds) Info() credentials.ProtocolInfo {
+	return c.current().Info()
+}
 
-	return cred, nil
+func (c *reloadClientCreds) Clone() credentials.TransportCredentials {
+	return &reloadClientCreds{r: c.r, serverName: c.serverName}
+}
+
+func (c *reloadClientCreds) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
 }
 
 func NewServiceTLS(c *CertPool) (credentials.TransportCredentials, error) {
@@ -0,0 +1,105 @@
+package fit
+
+import (
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strconv"
+)
+
+const (
+	grpcErrorDomain = "go-fit"
+	grpcErrorReason = "BUSINESS_ERROR"
+)
+
+// GrpcError a gRPC status error carrying the business code(see ResponseOK.Code) in its details,
+// the status code is codes.Unknown unless grpcCode is given. Use FromGrpcError to read it on the client side.
+func GrpcError(code int, msg string, grpcCode ...codes.Code) error {
+	c := codes.Unknown
+	if len(grpcCode) > 0 {
+		c = grpcCode[0]
+	}
+	st, err := status.New(c, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason:   grpcErrorReason,
+		Domain:   grpcErrorDomain,
+		Metadata: map[string]string{"code": strconv.Itoa(code)},
+	})
+	if err != nil {
+		return status.Error(c, msg)
+	}
+	return st.Err()
+}
+
+// ToGrpcError convert the error returned by a gRPC handler, errors carrying a business code(see NewCodeErr)
+// become GrpcError, status errors are kept and the rest are returned unchanged
+func ToGrpcError(err error, grpcCode ...codes.Code) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if ce := codeError(err); ce != nil {
+		return GrpcError(ce.code, ce.msg, grpcCode...)
+	}
+	return err
+}
+
+// FromGrpcError the business code and message of an error created by GrpcError, ok is false for other errors
+func FromGrpcError(err error) (code int, msg string, ok bool) {
+	st, isStatus := status.FromError(err)
+	if !isStatus || st == nil {
+		return 0, "", false
+	}
+	for _, detail := range st.Details() {
+		info, isInfo := detail.(*errdetails.ErrorInfo)
+		if !isInfo || info.Domain != grpcErrorDomain || info.Reason != grpcErrorReason {
+			continue
+		}
+		code, err := strconv.Atoi(info.Metadata["code"])
+		if err != nil {
+			continue
+		}
+		return code, st.Message(), true
+	}
+	return 0, "", false
+}
+
+// WriteGrpcError respond the error of a gRPC call. NotFound, PermissionDenied, Unauthenticated and ResourceExhausted
+// are mapped to 404, 403, 401 and 429, other errors created by GrpcError respond their business code with 200,
+// the rest are handled by FailErr.
+func WriteGrpcError(c *gin.Context, err error) {
+	code, msg, ok := FromGrpcError(err)
+	st, _ := status.FromError(err)
+
+	httpStatus := http.StatusOK
+	switch st.Code() {
+	case codes.NotFound:
+		httpStatus = http.StatusNotFound
+	case codes.PermissionDenied:
+		httpStatus = http.StatusForbidden
+	case codes.Unauthenticated:
+		httpStatus = http.StatusUnauthorized
+		if !ok {
+			code, msg = StatusUnauthorized, st.Message()
+		}
+	case codes.ResourceExhausted:
+		httpStatus = http.StatusTooManyRequests
+		if !ok {
+			code, msg = StatusTooManyRequests, SLimited
+		}
+	default:
+		if !ok {
+			FailErr(c, err)
+			return
+		}
+	}
+	if !ok && code == 0 {
+		code, msg = StatusCErr, st.Message()
+	}
+
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(httpStatus, newResponse(c, code, msg, nil))
+}
@@ -0,0 +1,179 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type GrpcServiceConfig struct {
+	// Listen address, e.g. :8080, empty listens on a random available port(see ListenFreePort)
+	Addr string
+	// Address registered in etcd, default the ip of GetOutBoundIPWithOptions(see SetAdvertiseIPOptions) with the listened port
+	AdvertiseAddr string
+	// Optional, see NewServiceTLS, the reloading of the files is stopped(CertPool.Close) when Run returns
+	CertPool *CertPool
+
+	// The interceptors are chained by ChainDefaultInterceptors: Stat -> LinkTrace -> Auth -> UnaryInterceptors/StreamInterceptors
+	Stat      *StatUnfinished
+	LinkTrace *LinkTrace
+	// Called before the handler, a non-nil error is returned to the client
+	Auth               func(ctx context.Context, fullMethod string) error
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+	ServerOptions      []grpc.ServerOption
+
+	// Registration in etcd, nil disables it. Value defaults to NewRegisterCenterValue(advertise address, RegisterValueOptions...)
+	// and Ctx to context.Background(), the service is registered once the listener is ready.
+	Register             *ServiceRegister
+	RegisterValueOptions []RegisterValueOption
+
+	// Timeout of deregister -> drain -> GracefulStop, default 30s
+	ShutdownTimeout time.Duration
+
+	// Logger of the lifecycle, default Info
+	Logger func(v ...interface{})
+}
+
+type GrpcService struct {
+	config   GrpcServiceConfig
+	server   *grpc.Server
+	listener net.Listener
+	addr     string
+}
+
+// NewGrpcService listen and create the server, register the services on Server() before Run
+func NewGrpcService(cfg GrpcServiceConfig) (*GrpcService, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = Info
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = time.Second * 30
+	}
+
+	var opts []grpc.ServerOption
+	if cfg.CertPool != nil {
+		cred, err := NewServiceTLS(cfg.CertPool)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(cred))
+	}
+
+	chain := []InterceptorOption{InterceptorUnary(cfg.UnaryInterceptors...), InterceptorStream(cfg.StreamInterceptors...)}
+	if cfg.Stat != nil {
+		chain = append(chain, InterceptorStat(cfg.Stat))
+	}
+	if cfg.LinkTrace != nil {
+		chain = append(chain, InterceptorTrace(cfg.LinkTrace))
+	}
+	if cfg.Auth != nil {
+		chain = append(chain, InterceptorAuth(cfg.Auth))
+	}
+	opts = append(opts, ChainDefaultInterceptors(chain...)...)
+	opts = append(opts, cfg.ServerOptions...)
+
+	listener, addr, err := grpcServiceListen(cfg.Addr)
+	if err != nil {
+		if cfg.CertPool != nil {
+			cfg.CertPool.Close()
+		}
+		return nil, err
+	}
+	if cfg.AdvertiseAddr != "" {
+		addr = cfg.AdvertiseAddr
+	}
+
+	return &GrpcService{
+		config:   cfg,
+		server:   grpc.NewServer(opts...),
+		listener: listener,
+		addr:     addr,
+	}, nil
+}
+
+// grpcServiceListen listen on addr, the advertised address uses advertiseIP when the host is empty or unspecified
+func grpcServiceListen(addr string) (net.Listener, string, error) {
+	if addr == "" {
+		return ListenFreePort("")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		_ = listener.Close()
+		return nil, "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if host, err = advertiseIP(); err != nil {
+			_ = listener.Close()
+			return nil, "", err
+		}
+	}
+	return listener, net.JoinHostPort(host, strconv.Itoa(GetListenPort(listener))), nil
+}
+
+// Server register the services, e.g. pb.RegisterUserServer(s.Server(), ...)
+func (s *GrpcService) Server() *grpc.Server {
+	return s.server
+}
+
+// Addr the advertised address, ip:port
+func (s *GrpcService) Addr() string {
+	return s.addr
+}
+
+// Run serve and register the service, block until ctx is done or the server fails.
+// When ctx is done the service is deregistered, the in-flight requests are drained(Stat)
+// and the server is stopped gracefully within ShutdownTimeout.
+func (s *GrpcService) Run(ctx context.Context) error {
+	if s.config.CertPool != nil {
+		defer s.config.CertPool.Close()
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.server.Serve(s.listener)
+	}()
+
+	sd := NewShutdown(ShutdownTimeout(s.config.ShutdownTimeout))
+	if r := s.config.Register; r != nil {
+		if r.Ctx == nil {
+			r.Ctx = context.Background()
+		}
+		if r.Value == "" {
+			r.Value = NewRegisterCenterValue(s.addr, s.config.RegisterValueOptions...)
+		}
+		if _, err := NewServiceRegister(r); err != nil {
+			s.server.Stop()
+			<-serveErr
+			return err
+		}
+		sd.AttachRegister(r)
+	}
+	if s.config.Stat != nil {
+		sd.AttachStat(s.config.Stat)
+	}
+	sd.AttachGrpcServer(s.server)
+	s.config.Logger("msg", "grpc service started", "addr", s.addr)
+
+	select {
+	case err := <-serveErr:
+		_ = sd.Shutdown()
+		if err == nil {
+			err = errors.New("grpc server stopped")
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	s.config.Logger("msg", "grpc service shutting down", "addr", s.addr)
+	err := sd.Shutdown()
+	<-serveErr
+	return err
+}
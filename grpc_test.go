@@ -0,0 +1,145 @@
+package fit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert write a self-signed certificate, used as its own CA, to dir/cert.pem, key.pem and ca.pem
+func writeTestCert(t *testing.T, dir string, serial int64) *CertPool {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	c := &CertPool{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		CaCert:   filepath.Join(dir, "ca.pem"),
+	}
+	// replaced by rename, a reload in progress never reads a partially written file
+	for name, data := range map[string][]byte{c.CertFile: certPEM, c.KeyFile: keyPEM, c.CaCert: certPEM} {
+		if err := os.WriteFile(name+".tmp", data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(name+".tmp", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestCertPoolClose(t *testing.T) {
+	dir := t.TempDir()
+	pool := writeTestCert(t, dir, 1)
+	reloaded := make(chan error, 16)
+	pool.ReloadInterval = time.Millisecond * 10
+	pool.OnReload = func(err error) {
+		select {
+		case reloaded <- err:
+		default:
+		}
+	}
+
+	if _, err := NewServiceTLS(pool); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClientTLS(pool); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server and the client reloader, a load between the writes of the files may fail and is retried on the next tick
+	writeTestCert(t, dir, 2)
+	timeout := time.After(time.Second * 5)
+	for ok := 0; ok < 2; {
+		select {
+		case err := <-reloaded:
+			if err == nil {
+				ok++
+			}
+		case <-timeout:
+			t.Fatal("the changed files were not reloaded")
+		}
+	}
+
+	pool.Close()
+	pool.Close()
+	// a load in progress when Close was called may still report
+	time.Sleep(time.Millisecond * 50)
+	for len(reloaded) > 0 {
+		<-reloaded
+	}
+	writeTestCert(t, dir, 3)
+	select {
+	case err := <-reloaded:
+		t.Fatalf("reloaded after Close: %v", err)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestGrpcServiceClosesCertPool(t *testing.T) {
+	pool := writeTestCert(t, t.TempDir(), 1)
+	pool.ReloadInterval = time.Millisecond * 10
+	s, err := NewGrpcService(GrpcServiceConfig{
+		Addr:     "127.0.0.1:0",
+		CertPool: pool,
+		Logger:   func(v ...interface{}) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("Run did not return")
+	}
+
+	pool.mux.Lock()
+	defer pool.mux.Unlock()
+	if len(pool.reloaders) != 0 {
+		t.Fatalf("%d reloaders still running after Run returned", len(pool.reloaders))
+	}
+}
@@ -0,0 +1,209 @@
+package fit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type healthCheck struct {
+	name     string
+	fn       func(ctx context.Context) error
+	liveness bool
+}
+
+// HealthChecker aggregate the checks of the connections for the liveness and readiness probes
+type HealthChecker struct {
+	mux     sync.RWMutex
+	checks  []healthCheck
+	stat    *StatUnfinished
+	timeout time.Duration
+}
+
+type HealthOption func(*HealthChecker)
+
+// HealthTimeout timeout of all the checks of a probe, default 3s
+func HealthTimeout(t time.Duration) HealthOption {
+	return func(h *HealthChecker) {
+		h.timeout = t
+	}
+}
+
+func NewHealthChecker(opts ...HealthOption) *HealthChecker {
+	h := &HealthChecker{timeout: time.Second * 3}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// RegisterCheck add a readiness check, e.g. fit.MysqlHealthCheck
+func (h *HealthChecker) RegisterCheck(name string, fn func(ctx context.Context) error) {
+	h.add(healthCheck{name: name, fn: fn})
+}
+
+// RegisterLivenessCheck add a check used by both probes, a failing liveness probe restarts the container
+func (h *HealthChecker) RegisterLivenessCheck(name string, fn func(ctx context.Context) error) {
+	h.add(healthCheck{name: name, fn: fn, liveness: true})
+}
+
+// AttachStat readiness fails while the service is draining(FiringWaitDone) or not available, liveness is not affected
+func (h *HealthChecker) AttachStat(stat *StatUnfinished) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.stat = stat
+}
+
+func (h *HealthChecker) add(c healthCheck) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.checks = append(h.checks, c)
+}
+
+// HealthResult response of the probes
+type HealthResult struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+	Failed []string                     `json:"failed,omitempty"`
+}
+
+type HealthCheckResult struct {
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Check run the checks concurrently, readiness includes all the checks and the draining state
+func (h *HealthChecker) Check(ctx context.Context, readiness bool) HealthResult {
+	h.mux.RLock()
+	checks := make([]healthCheck, 0, len(h.checks))
+	for _, c := range h.checks {
+		if readiness || c.liveness {
+			checks = append(checks, c)
+		}
+	}
+	stat := h.stat
+	h.mux.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	res := HealthResult{Status: "ok", Checks: make(map[string]HealthCheckResult, len(checks)+1)}
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c healthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.fn(ctx)
+			r := HealthCheckResult{LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				r.Error = err.Error()
+			}
+			mux.Lock()
+			res.Checks[c.name] = r
+			mux.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	if readiness && stat != nil && stat.Draining() {
+		res.Checks["draining"] = HealthCheckResult{Error: "service is draining"}
+	}
+	for name, r := range res.Checks {
+		if r.Error != "" {
+			res.Failed = append(res.Failed, name)
+		}
+	}
+	if len(res.Failed) > 0 {
+		res.Status = "fail"
+		sort.Strings(res.Failed)
+	}
+	return res
+}
+
+// Handler see Readiness
+func (h *HealthChecker) Handler() http.Handler {
+	return h.Readiness()
+}
+
+// Readiness 200 when all the checks pass and the service is not draining, otherwise 503
+func (h *HealthChecker) Readiness() http.Handler {
+	return h.handler(true)
+}
+
+// Liveness 200 when the liveness checks pass, otherwise 503
+func (h *HealthChecker) Liveness() http.Handler {
+	return h.handler(false)
+}
+
+// GinHandler readiness probe as gin handler, use gin.WrapH(h.Liveness()) for the liveness probe
+func (h *HealthChecker) GinHandler() gin.HandlerFunc {
+	return gin.WrapH(h.Readiness())
+}
+
+func (h *HealthChecker) handler(readiness bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := h.Check(r.Context(), readiness)
+		code := http.StatusOK
+		if res.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
+
+// MysqlHealthCheck ping the connection of NewMysqlDefConnect
+func MysqlHealthCheck(ctx context.Context) error {
+	if sqlDB == nil {
+		return errors.New("mysql not initialized")
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// RedisHealthCheck PING the client of NewRedisConnect or NewRedisConnectCluster
+func RedisHealthCheck(ctx context.Context) error {
+	rdb, err := redisUniversalClient()
+	if err != nil {
+		return err
+	}
+	return rdb.Ping(ctx).Err()
+}
+
+// EtcdHealthCheck status of an endpoint of the client of InitEtcd
+func EtcdHealthCheck(ctx context.Context) error {
+	if client == nil {
+		return errors.New("etcd not initialized")
+	}
+	endpoints := client.Endpoints()
+	if len(endpoints) == 0 {
+		return errors.New("etcd has no endpoints")
+	}
+	_, err := client.Status(ctx, endpoints[0])
+	return err
+}
+
+// RabbitMQLogHealthCheck the connection of SetRemoteRabbitMQLog is open, the connection is created on demand
+// so an idle(not yet connected) transport passes.
+func RabbitMQLogHealthCheck(context.Context) error {
+	r, ok := getRemoteLogTransport().(*rabbitMQLogTransport)
+	if !ok {
+		return errors.New("rabbitmq remote log not initialized")
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		return errors.New("rabbitmq remote log closed")
+	}
+	if r.inst != nil && r.inst.mq.isClosed() {
+		return errors.New("rabbitmq connection closed")
+	}
+	return nil
+}
@@ -2,6 +2,10 @@ package fit
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -9,64 +13,125 @@ import (
 	"time"
 )
 
-type HttpUtil struct {
-	response *http.Response
-	Err      error
+// HTTPClient http client honoring the context,
+// requests whose context carries a *Trace get the trace headers and are recorded into the trace.
+type HTTPClient struct {
+	client *http.Client
+	header H
 }
 
-func (r *HttpUtil) Get(path string, v H) *HttpUtil {
-	if len(v) > 0 {
-		params := url.Values{}
-		Url, err := url.Parse(path)
-		if err != nil {
-			r.Err = err
-			return r
-		}
-		for k, val := range v {
-			val, ok := val.(string)
-			if ok {
-				params.Set(k, val)
-			}
-		}
-		Url.RawQuery = params.Encode()
-		path = Url.String()
+type HTTPClientOption func(*HTTPClient)
+
+// HTTPTimeout timeout of a whole request, default 10s, the context deadline still applies
+func HTTPTimeout(t time.Duration) HTTPClientOption {
+	return func(c *HTTPClient) {
+		c.client.Timeout = t
 	}
+}
 
-	client := http.Client{Timeout: 10 * time.Second}
-	response, err := client.Get(path)
-	defer client.CloseIdleConnections()
-	if err != nil {
-		r.Err = err
-		return r
+// HTTPHeader header added to every request
+func HTTPHeader(key, value string) HTTPClientOption {
+	return func(c *HTTPClient) {
+		c.header[key] = value
 	}
-	r.response = response
-	return r
 }
 
-func (r *HttpUtil) Post(url string, v H) *HttpUtil {
-	response, err := http.Post(url, "application/json;charset=utf-8", bytes.NewBuffer([]byte(v.ToString())))
-	if err != nil {
-		r.Err = err
-		return r
+// HTTPTransport base transport, wrapped by TraceTransport
+func HTTPTransport(rt http.RoundTripper) HTTPClientOption {
+	return func(c *HTTPClient) {
+		c.client.Transport = TraceTransport(rt)
 	}
-	r.response = response
-	return r
 }
 
-func (r *HttpUtil) NewRequest(method string, url string, body string, header ...H) *HttpUtil {
-	request, err := http.NewRequest(method, url, strings.NewReader(body))
+func NewHTTPClient(opts ...HTTPClientOption) *HTTPClient {
+	c := &HTTPClient{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: TraceTransport(nil),
+		},
+		header: H{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Do send the request, header values are converted with fmt.Sprint
+func (c *HTTPClient) Do(ctx context.Context, method, path string, body io.Reader, header ...H) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.header {
+		request.Header.Set(k, fmt.Sprint(v))
+	}
 	if len(header) > 0 {
 		for k, v := range header[0] {
-			request.Header.Add(k, v.(string))
+			request.Header.Set(k, fmt.Sprint(v))
 		}
 	}
+	return c.client.Do(request)
+}
 
-	response, err := http.DefaultClient.Do(request)
+// Get query values are appended to the url
+func (c *HTTPClient) Get(ctx context.Context, path string, query H, header ...H) (*http.Response, error) {
+	if len(query) > 0 {
+		u, err := url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		params := u.Query()
+		for k, v := range query {
+			params.Set(k, fmt.Sprint(v))
+		}
+		u.RawQuery = params.Encode()
+		path = u.String()
+	}
+	return c.Do(ctx, http.MethodGet, path, nil, header...)
+}
+
+// Post send v as a JSON body
+func (c *HTTPClient) Post(ctx context.Context, path string, v interface{}, header ...H) (*http.Response, error) {
+	body, err := json.Marshal(v)
 	if err != nil {
-		r.Err = err
-		return r
+		return nil, err
+	}
+	h := H{"Content-Type": "application/json;charset=utf-8"}
+	if len(header) > 0 {
+		for k, val := range header[0] {
+			h[k] = val
+		}
+	}
+	return c.Do(ctx, http.MethodPost, path, bytes.NewReader(body), h)
+}
+
+var defaultHTTPClient = NewHTTPClient()
+
+// HttpUtil kept for compatibility, use NewHTTPClient for context and timeout control.
+type HttpUtil struct {
+	response *http.Response
+	Err      error
+}
+
+func (r *HttpUtil) Get(path string, v H) *HttpUtil {
+	query := H{}
+	for k, val := range v {
+		if val, ok := val.(string); ok {
+			query[k] = val
+		}
 	}
-	r.response = response
+	r.response, r.Err = defaultHTTPClient.Get(context.Background(), path, query)
+	return r
+}
+
+func (r *HttpUtil) Post(url string, v H) *HttpUtil {
+	r.response, r.Err = defaultHTTPClient.Do(context.Background(), http.MethodPost, url, strings.NewReader(v.ToString()), H{"Content-Type": "application/json;charset=utf-8"})
+	return r
+}
+
+func (r *HttpUtil) NewRequest(method string, url string, body string, header ...H) *HttpUtil {
+	r.response, r.Err = defaultHTTPClient.Do(context.Background(), method, url, strings.NewReader(body), header...)
 	return r
 }
 
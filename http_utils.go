@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"sync"
 )
 
 /**
@@ -15,6 +16,10 @@ const (
 	StatusSInternalErr = 10500
 	// StatusCErr client error, corresponding http status code is 400
 	StatusCErr = 10400
+	// StatusUnauthorized authentication failed, corresponding http status code is 401
+	StatusUnauthorized = 10401
+	// StatusTooManyRequests request blocked by flow control, corresponding http status code is 429
+	StatusTooManyRequests = 10429
 	// StatusOK success, corresponding http status code is 200
 	StatusOK = 0
 )
@@ -25,6 +30,7 @@ const (
 
 const (
 	SBusy     = "系统繁忙"
+	SLimited  = "请求过于频繁，请稍后再试"
 	HandleErr = "操作失败"
 	HandleOk  = "操作成功"
 )
@@ -48,6 +54,16 @@ type ResponseOK struct {
 	Code   int         `json:"code"`
 	Msg    string      `json:"msg"`
 	Result interface{} `json:"result"`
+	// Set by OK/Fail/FailErr/OKList when the request is traced
+	TraceId string `json:"trace_id,omitempty"`
+}
+
+// ResponseList result of OKList
+type ResponseList struct {
+	List  any   `json:"list"`
+	Total int64 `json:"total"`
+	Page  int   `json:"page"`
+	Size  int   `json:"size"`
 }
 
 type ResponseErr struct {
@@ -122,3 +138,88 @@ func ErrString(c *gin.Context, format string, response interface{}) {
 	}
 	c.String(code, format, response)
 }
+
+/**
+ * response envelope
+ */
+
+type errorCode struct {
+	err        error
+	code       int
+	httpStatus int
+	msg        string
+}
+
+var (
+	errorCodesMux sync.RWMutex
+	errorCodes    []errorCode
+)
+
+// RegisterErrorCode FailErr responds with code, httpStatus and msg when errors.Is(err, target),
+// an empty msg uses err.Error(). Registrations are checked in order.
+func RegisterErrorCode(err error, code int, httpStatus int, msg string) {
+	errorCodesMux.Lock()
+	defer errorCodesMux.Unlock()
+	errorCodes = append(errorCodes, errorCode{err: err, code: code, httpStatus: httpStatus, msg: msg})
+}
+
+// OK respond {code: StatusOK, msg: HandleOk, result: data}
+func OK(c *gin.Context, data any) {
+	c.JSON(http.StatusOK, newResponse(c, StatusOK, HandleOk, data))
+}
+
+// OKList respond a page of items
+func OKList(c *gin.Context, items any, total int64, page, size int) {
+	OK(c, ResponseList{List: items, Total: total, Page: page, Size: size})
+}
+
+// Fail respond the error code, the http status is derived from the predefined codes, default 400
+func Fail(c *gin.Context, code int, msg string) {
+	c.AbortWithStatusJSON(httpStatusOfCode(code), newResponse(c, code, msg, nil))
+}
+
+// FailErr respond the code registered by RegisterErrorCode, then the code of a CodeError(see NewCodeErr),
+// other errors respond StatusSInternalErr with SBusy so that internal details are not leaked.
+func FailErr(c *gin.Context, err error) {
+	_ = c.Error(err)
+
+	errorCodesMux.RLock()
+	defer errorCodesMux.RUnlock()
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			msg := ec.msg
+			if msg == "" {
+				msg = err.Error()
+			}
+			c.AbortWithStatusJSON(ec.httpStatus, newResponse(c, ec.code, msg, nil))
+			return
+		}
+	}
+	if ce := codeError(err); ce != nil {
+		c.AbortWithStatusJSON(httpStatusOfCode(ce.code), newResponse(c, ce.code, ce.msg, nil))
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, newResponse(c, StatusSInternalErr, SBusy, nil))
+}
+
+func newResponse(c *gin.Context, code int, msg string, result any) ResponseOK {
+	res := ResponseOK{Code: code, Msg: msg, Result: result}
+	if trace, ok := GetGinTraceCtx(c); ok {
+		res.TraceId = trace.TraceId
+	}
+	return res
+}
+
+func httpStatusOfCode(code int) int {
+	switch code {
+	case StatusOK:
+		return http.StatusOK
+	case StatusSInternalErr:
+		return http.StatusInternalServerError
+	case StatusUnauthorized:
+		return http.StatusUnauthorized
+	case StatusTooManyRequests:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadRequest
+}
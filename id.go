@@ -0,0 +1,178 @@
+package fit
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	idNodeBits     = 10
+	idSequenceBits = 12
+	idMaxNode      = 1<<idNodeBits - 1
+	idSequenceMask = 1<<idSequenceBits - 1
+)
+
+// ErrClockRollback the system clock moved backwards, no id is generated to avoid duplicates
+var ErrClockRollback = errors.New("clock moved backwards, refusing to generate id")
+
+// ID snowflake-style id: 41 bits milliseconds since the epoch, 10 bits node, 12 bits sequence
+type ID int64
+
+func (id ID) Int64() int64 {
+	return int64(id)
+}
+
+func (id ID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+// Time the millisecond the id was generated at
+func (id ID) Time(epoch ...time.Time) time.Time {
+	e := defaultIDEpoch
+	if len(epoch) > 0 {
+		e = epoch[0]
+	}
+	ms := int64(id) >> (idNodeBits + idSequenceBits)
+	return e.Add(time.Duration(ms) * time.Millisecond)
+}
+
+var defaultIDEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type IDGenerator struct {
+	mux      sync.Mutex
+	epoch    time.Time
+	node     int64
+	lastMs   int64
+	sequence int64
+}
+
+type IDOption func(*IDGenerator)
+
+// IDNode node id 0-1023, by default derived from GetMachineCode, or GetOutBoundIP when it is not available
+func IDNode(node int64) IDOption {
+	return func(g *IDGenerator) {
+		g.node = node
+	}
+}
+
+// IDEpoch start time of the timestamps, default 2020-01-01 UTC, it must not change once ids are in use
+func IDEpoch(epoch time.Time) IDOption {
+	return func(g *IDGenerator) {
+		g.epoch = epoch
+	}
+}
+
+func NewIDGenerator(opts ...IDOption) (*IDGenerator, error) {
+	g := &IDGenerator{epoch: defaultIDEpoch, node: -1}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.node < 0 {
+		g.node = defaultIDNode()
+	}
+	if g.node > idMaxNode {
+		return nil, fmt.Errorf("node id must be between 0 and %d", idMaxNode)
+	}
+	if g.epoch.After(time.Now()) {
+		return nil, errors.New("id epoch must not be in the future")
+	}
+	return g, nil
+}
+
+// Next generate an id, safe for concurrent use and monotonic within the generator.
+// When the sequence of a millisecond is exhausted it waits for the next millisecond.
+func (g *IDGenerator) Next() (ID, error) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	now := time.Since(g.epoch).Milliseconds()
+	if now < g.lastMs {
+		return 0, fmt.Errorf("%w: %dms", ErrClockRollback, g.lastMs-now)
+	}
+	if now == g.lastMs {
+		g.sequence = (g.sequence + 1) & idSequenceMask
+		if g.sequence == 0 {
+			for now <= g.lastMs {
+				time.Sleep(time.Microsecond * 100)
+				now = time.Since(g.epoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMs = now
+
+	return ID(now<<(idNodeBits+idSequenceBits) | g.node<<idSequenceBits | g.sequence), nil
+}
+
+func defaultIDNode() int64 {
+	seed := GetMachineCode()
+	if seed == "" {
+		seed, _ = GetOutBoundIP()
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(seed))
+	return int64(h.Sum32() % (idMaxNode + 1))
+}
+
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulidState struct {
+	sync.Mutex
+	lastMs  int64
+	entropy [10]byte
+}
+
+// NewULID 26 characters, lexicographically sortable, monotonic within the process
+func NewULID() string {
+	ulidState.Lock()
+	defer ulidState.Unlock()
+
+	ms := time.Now().UnixMilli()
+	if ms <= ulidState.lastMs {
+		// same millisecond(or clock rollback): keep the timestamp and increment the entropy
+		ms = ulidState.lastMs
+		for i := len(ulidState.entropy) - 1; i >= 0; i-- {
+			ulidState.entropy[i]++
+			if ulidState.entropy[i] != 0 {
+				break
+			}
+		}
+	} else {
+		_, _ = rand.Read(ulidState.entropy[:])
+		ulidState.lastMs = ms
+	}
+
+	var data [16]byte
+	data[0] = byte(ms >> 40)
+	data[1] = byte(ms >> 32)
+	data[2] = byte(ms >> 24)
+	data[3] = byte(ms >> 16)
+	data[4] = byte(ms >> 8)
+	data[5] = byte(ms)
+	copy(data[6:], ulidState.entropy[:])
+	return encodeULID(data)
+}
+
+// encodeULID crockford base32 of the 128 bits, the first character carries 3 bits
+func encodeULID(data [16]byte) string {
+	out := make([]byte, 26)
+	var acc uint
+	bits := 2 // 130 bits of output for 128 bits of input, pad 2 zero bits at the front
+	idx := 0
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[idx] = ulidEncoding[(acc>>uint(bits))&31]
+			idx++
+		}
+	}
+	return string(out)
+}
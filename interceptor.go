@@ -0,0 +1,122 @@
+package fit
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type interceptorOptions struct {
+	stat         *StatUnfinished
+	requestID    bool
+	trace        *LinkTrace
+	sentinel     bool
+	sentinelFunc func(info *grpc.UnaryServerInfo) string
+	auth         func(ctx context.Context, fullMethod string) error
+	unary        []grpc.UnaryServerInterceptor
+	stream       []grpc.StreamServerInterceptor
+}
+
+type InterceptorOption func(*interceptorOptions)
+
+// InterceptorStat count the in-flight calls, see StatUnfinished
+func InterceptorStat(stat *StatUnfinished) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.stat = stat
+	}
+}
+
+// InterceptorTrace full link tracing, see LinkTrace
+func InterceptorTrace(trace *LinkTrace) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.trace = trace
+	}
+}
+
+// InterceptorRequestID carry the request id, see RequestIDServerInterceptor, only for unary calls
+func InterceptorRequestID() InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.requestID = true
+	}
+}
+
+// InterceptorSentinel flow control and circuit breaking of unary calls, see SentinelGrpcUnaryInterceptor
+func InterceptorSentinel(resourceFunc func(info *grpc.UnaryServerInfo) string) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.sentinel = true
+		o.sentinelFunc = resourceFunc
+	}
+}
+
+// InterceptorAuth called before the handler, a non-nil error is returned to the client
+func InterceptorAuth(auth func(ctx context.Context, fullMethod string) error) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.auth = auth
+	}
+}
+
+// InterceptorUnary interceptors run after the built-in ones
+func InterceptorUnary(interceptors ...grpc.UnaryServerInterceptor) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.unary = append(o.unary, interceptors...)
+	}
+}
+
+// InterceptorStream interceptors run after the built-in ones
+func InterceptorStream(interceptors ...grpc.StreamServerInterceptor) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.stream = append(o.stream, interceptors...)
+	}
+}
+
+// ChainDefaultInterceptors the server options chaining the given interceptors in the recommended order:
+// stat -> request id -> trace -> sentinel -> auth -> InterceptorUnary/InterceptorStream -> handler.
+// The interceptors only pass values through the context, they can be combined freely with grpc.ChainUnaryInterceptor.
+func ChainDefaultInterceptors(opts ...InterceptorOption) []grpc.ServerOption {
+	var o interceptorOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	if o.stat != nil {
+		unary = append(unary, o.stat.GrpcStatUnfinished())
+		stream = append(stream, o.stat.GrpcStreamStatUnfinished())
+	}
+	if o.requestID {
+		unary = append(unary, RequestIDServerInterceptor())
+	}
+	if o.trace != nil {
+		unary = append(unary, o.trace.GrpcServerInterceptor())
+		stream = append(stream, o.trace.GrpcStreamServerInterceptor())
+	}
+	if o.sentinel {
+		unary = append(unary, SentinelGrpcUnaryInterceptor(o.sentinelFunc))
+	}
+	if o.auth != nil {
+		unary = append(unary, authUnaryInterceptor(o.auth))
+		stream = append(stream, authStreamInterceptor(o.auth))
+	}
+	unary = append(unary, o.unary...)
+	stream = append(stream, o.stream...)
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+}
+
+func authUnaryInterceptor(auth func(ctx context.Context, fullMethod string) error) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := auth(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func authStreamInterceptor(auth func(ctx context.Context, fullMethod string) error) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := auth(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
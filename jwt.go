@@ -1,10 +1,25 @@
 package fit
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt"
+	"net/http"
+	"strings"
+	"time"
 )
 
+// ErrTokenRevoked the token is in the revocation list
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+const revokedTokenPrefix = "FIT:JWT:REVOKED:"
+
+var revocationFailOpen bool
+
 type JwtClaims struct {
 	Audience  string `json:"aud,omitempty"`
 	ExpiresAt int64  `json:"exp,omitempty"`
@@ -37,3 +52,193 @@ func Valid(signingKey, t string) (JwtClaims, error) {
 	}
 	return JwtClaims(*sc), nil
 }
+
+// SetRevocationFailOpen decide how ValidWithRevocation behaves when redis is unreachable,
+// true accepts the token(fail-open), false(default) rejects it(fail-closed).
+func SetRevocationFailOpen(v bool) {
+	revocationFailOpen = v
+}
+
+// RevokeToken put the token into the revocation list stored in redis,
+// the record expires together with the token.
+// Please call NewRedisDefConnect or NewRedisDefConnectCluster before calling this function
+func RevokeToken(ctx context.Context, t string) error {
+	sc := &jwt.StandardClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(t, sc); err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if sc.ExpiresAt > 0 {
+		ttl = time.Until(time.Unix(sc.ExpiresAt, 0))
+		if ttl <= 0 {
+			// already expired
+			return nil
+		}
+	}
+
+	_, err := MainRedis(WithCtx(ctx), WithExpire(ttl)).Set(revokedTokenKey(sc.Id, t), 1)
+	return err
+}
+
+// IsTokenRevoked check whether the token is in the revocation list
+func IsTokenRevoked(ctx context.Context, t string) (bool, error) {
+	sc := &jwt.StandardClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(t, sc); err != nil {
+		return false, err
+	}
+	return isTokenRevoked(ctx, sc.Id, t)
+}
+
+func isTokenRevoked(ctx context.Context, id, t string) (bool, error) {
+	_, err := MainRedis(WithCtx(ctx)).Get(revokedTokenKey(id, t))
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ValidWithRevocation Valid plus a check against the revocation list
+func ValidWithRevocation(ctx context.Context, signingKey, t string) (JwtClaims, error) {
+	claims, err := Valid(signingKey, t)
+	if err != nil {
+		return JwtClaims{}, err
+	}
+
+	revoked, err := isTokenRevoked(ctx, claims.Id, t)
+	if err != nil {
+		if revocationFailOpen {
+			return claims, nil
+		}
+		return JwtClaims{}, err
+	}
+	if revoked {
+		return JwtClaims{}, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// revokedTokenKey use jti when present, otherwise the hash of the token
+func revokedTokenKey(id, t string) string {
+	if id != "" {
+		return revokedTokenPrefix + id
+	}
+	sum := sha256.Sum256([]byte(t))
+	return revokedTokenPrefix + hex.EncodeToString(sum[:])
+}
+
+const jwtClaimsCtxName = "FIT_JWT_CLAIMS"
+
+type jwtAuthConfig struct {
+	header       string
+	query        string
+	revocation   bool
+	skipper      func(*gin.Context) bool
+	errorHandler func(*gin.Context, error)
+}
+
+type JwtAuthOption func(*jwtAuthConfig)
+
+// JwtHeader name of the header carrying the token, default Authorization.
+// The "Bearer " prefix is removed if present.
+func JwtHeader(name string) JwtAuthOption {
+	return func(c *jwtAuthConfig) {
+		c.header = name
+	}
+}
+
+// JwtQuery name of the query parameter used when the header is empty
+func JwtQuery(name string) JwtAuthOption {
+	return func(c *jwtAuthConfig) {
+		c.query = name
+	}
+}
+
+// JwtSkipper requests for which fn returns true are not authenticated
+func JwtSkipper(fn func(*gin.Context) bool) JwtAuthOption {
+	return func(c *jwtAuthConfig) {
+		c.skipper = fn
+	}
+}
+
+// JwtErrorHandler replace the default 401 response, the handler is responsible for aborting the request
+func JwtErrorHandler(fn func(*gin.Context, error)) JwtAuthOption {
+	return func(c *jwtAuthConfig) {
+		c.errorHandler = fn
+	}
+}
+
+// JwtRevocation also check the revocation list, see ValidWithRevocation
+func JwtRevocation() JwtAuthOption {
+	return func(c *jwtAuthConfig) {
+		c.revocation = true
+	}
+}
+
+// JwtAuthMiddleware validate the token of the request and save the claims into the context,
+// use GetJwtClaims to read them.
+func JwtAuthMiddleware(signingKey string, opts ...JwtAuthOption) gin.HandlerFunc {
+	config := &jwtAuthConfig{header: "Authorization"}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.errorHandler == nil {
+		config.errorHandler = func(c *gin.Context, err error) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ResponseOK{
+				Code: StatusUnauthorized,
+				Msg:  err.Error(),
+			})
+		}
+	}
+
+	return func(c *gin.Context) {
+		if config.skipper != nil && config.skipper(c) {
+			c.Next()
+			return
+		}
+
+		t := strings.TrimSpace(c.GetHeader(config.header))
+		if len(t) > 7 && strings.EqualFold(t[:7], "Bearer ") {
+			t = strings.TrimSpace(t[7:])
+		}
+		if t == "" && config.query != "" {
+			t = c.Query(config.query)
+		}
+		if t == "" {
+			config.errorHandler(c, errors.New("token is missing"))
+			return
+		}
+
+		var claims JwtClaims
+		var err error
+		if config.revocation {
+			claims, err = ValidWithRevocation(c, signingKey, t)
+		} else {
+			claims, err = Valid(signingKey, t)
+		}
+		if err != nil {
+			config.errorHandler(c, err)
+			return
+		}
+
+		c.Set(jwtClaimsCtxName, claims)
+		if trace, ok := GetGinTraceCtx(c); ok {
+			trace.Set("jwt_subject", claims.Subject)
+			trace.Set("jwt_id", claims.Id)
+		}
+		c.Next()
+	}
+}
+
+// GetJwtClaims get the claims saved by JwtAuthMiddleware
+func GetJwtClaims(c *gin.Context) (JwtClaims, bool) {
+	val, ok := c.Get(jwtClaimsCtxName)
+	if !ok {
+		return JwtClaims{}, false
+	}
+	claims, ok := val.(JwtClaims)
+	return claims, ok
+}
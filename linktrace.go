@@ -3,16 +3,20 @@ package fit
 import (
 	"bytes"
 	"context"
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"io"
 	"net/http"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,31 +37,56 @@ type LinkTraceResponse struct {
 
 // LinkTraceDialog information of calling the third-party interface
 type LinkTraceDialog struct {
-	mux       sync.Mutex
-	Request   *LinkTraceRequest    `json:"request"`
-	Responses []*LinkTraceResponse `json:"responses"`
-	Success   bool                 `json:"success"`
-	Cost      string               `json:"cost"`
+	mux          sync.Mutex
+	Request      *LinkTraceRequest    `json:"request"`
+	Responses    []*LinkTraceResponse `json:"responses"`
+	Success      bool                 `json:"success"`
+	SpanId       string               `json:"span_id,omitempty"`        // span id assigned to the callee
+	ParentSpanId string               `json:"parent_span_id,omitempty"` // span id of the caller
+	StartMs      int64                `json:"start_ms"`                 // unix milliseconds
+	EndMs        int64                `json:"end_ms"`                   // unix milliseconds
+	CostMs       float64              `json:"cost_ms"`                  // execution time in milliseconds, prefer it over Cost
+	Cost         string               `json:"cost"`
 }
 
 // LinkTraceExternal record external operations
 type LinkTraceExternal struct {
-	Url     string      `json:"url"`
-	Type    string      `json:"type"`
-	Request interface{} `json:"request"`
-	Start   int64       `json:"start"`
-	End     int64       `json:"end"`
-	Error   error       `json:"error"`
-	Cost    string      `json:"cost"`
+	Url          string      `json:"url"`
+	Type         string      `json:"type"`
+	Request      interface{} `json:"request"`
+	SpanId       string      `json:"span_id"`        // span id assigned to the callee
+	ParentSpanId string      `json:"parent_span_id"` // span id of the caller
+	Start        int64       `json:"start"`          // unix seconds, prefer StartMs
+	End          int64       `json:"end"`            // unix seconds, prefer EndMs
+	StartMs      int64       `json:"start_ms"`       // unix milliseconds
+	EndMs        int64       `json:"end_ms"`         // unix milliseconds
+	Error        error       `json:"error"`
+	CostMs       float64     `json:"cost_ms"` // execution time in milliseconds, prefer it over Cost
+	Cost         string      `json:"cost"`
+}
+
+// finish set the start, end time and cost of the call started at start
+func (e *LinkTraceExternal) finish(start time.Time) {
+	end := time.Now()
+	elapsed := end.Sub(start)
+	e.Start = start.Unix()
+	e.End = end.Unix()
+	e.StartMs = start.UnixMilli()
+	e.EndMs = end.UnixMilli()
+	e.CostMs = DurationMs(elapsed)
+	e.Cost = elapsed.String()
 }
 
 // LinkTraceSQL information about executing SQL
 type LinkTraceSQL struct {
-	Timestamp string `json:"timestamp"`     // format：2006-01-02 15:04:05
-	Stack     string `json:"stack"`         // 文件地址和行号
-	SQL       string `json:"sql"`           // SQL 语句
-	Rows      int64  `json:"rows_affected"` // 影响行数
-	Cost      string `json:"cost"`          // execution time
+	Timestamp string  `json:"timestamp"`     // format：2006-01-02 15:04:05
+	Stack     string  `json:"stack"`         // 文件地址和行号
+	SQL       string  `json:"sql"`           // SQL 语句
+	Rows      int64   `json:"rows_affected"` // 影响行数
+	StartMs   int64   `json:"start_ms"`      // unix milliseconds
+	EndMs     int64   `json:"end_ms"`        // unix milliseconds
+	CostMs    float64 `json:"cost_ms"`       // execution time in milliseconds, prefer it over Cost
+	Cost      string  `json:"cost"`          // execution time
 }
 
 // LinkTraceRedis redis execution information
@@ -65,7 +94,11 @@ type LinkTraceRedis struct {
 	Timestamp string      `json:"timestamp"` // format：2006-01-02 15:04:05
 	Handle    string      `json:"handle"`    // operation，SET/GET...
 	Args      interface{} `json:"args"`      // args
+	StartMs   int64       `json:"start_ms"`  // unix milliseconds
+	EndMs     int64       `json:"end_ms"`    // unix milliseconds
+	CostMs    float64     `json:"cost_ms"`   // execution time in milliseconds, prefer it over Cost
 	Cost      string      `json:"cost"`      // execution time
+	Slow      bool        `json:"slow,omitempty"`
 }
 
 // Trace recorded parameters
@@ -74,6 +107,8 @@ type Trace struct {
 	ServiceName        string               `json:"service_name"`
 	ServiceType        string               `json:"service_type"`
 	TraceId            string               `json:"trace_id"`
+	SpanId             string               `json:"span_id"`
+	ParentSpanId       string               `json:"parent_span_id"`
 	SourceIp           string               `json:"source_ip"`
 	Request            *LinkTraceRequest    `json:"request"`
 	Response           *LinkTraceResponse   `json:"response"`
@@ -83,35 +118,104 @@ type Trace struct {
 	SQLs               []*LinkTraceSQL      `json:"sqls"`
 	Redis              []*LinkTraceRedis    `json:"redis"`
 	Success            bool                 `json:"success"`
-	Start              int64                `json:"start"`
-	End                int64                `json:"end"`
+	Start              int64                `json:"start"`    // unix seconds, prefer StartMs
+	End                int64                `json:"end"`      // unix seconds, prefer EndMs
+	StartMs            int64                `json:"start_ms"` // unix milliseconds
+	EndMs              int64                `json:"end_ms"`   // unix milliseconds
+	CostMs             float64              `json:"cost_ms"`  // execution time in milliseconds, prefer it over Cost
 	Cost               string               `json:"cost"`
+	StreamSent         int64                `json:"stream_sent,omitempty"` // messages sent by a streaming call
+	StreamRecv         int64                `json:"stream_recv,omitempty"` // messages received by a streaming call
 	Extend             map[string]any       `json:"extend"`
 	LogRows            []any                `json:"log_rows"`
+	Children           []*Trace             `json:"children,omitempty"` // see Child
+	maxSize            int
 }
 
+// traceResult the outcome of the request recorded when the trace is finished
+type traceResult struct {
+	request    *LinkTraceRequest
+	response   *LinkTraceResponse
+	success    bool
+	err        error
+	streamSent int64
+	streamRecv int64
+}
+
+// finish record the result, the end time and cost of the trace started at start
+func (t *Trace) finish(start time.Time, r traceResult) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.Request = r.request
+	t.Response = r.response
+	t.Success = r.success
+	t.Error = r.err
+	t.StreamSent = r.streamSent
+	t.StreamRecv = r.streamRecv
+	end := time.Now()
+	elapsed := end.Sub(start)
+	t.End = end.Unix()
+	t.EndMs = end.UnixMilli()
+	t.CostMs = DurationMs(elapsed)
+	t.Cost = elapsed.String()
+}
+
+// Child a trace for a goroutine started by the handler, it shares the trace id and its span is a child of t.
+// The entries appended to the child keep their order and are serialized in the Children of t,
+// pass it to the goroutine with ContextWithTrace.
+func (t *Trace) Child() *Trace {
+	child := &Trace{
+		TraceId:      t.TraceId,
+		SpanId:       NewSpanId(),
+		ParentSpanId: t.SpanId,
+		ServiceName:  t.ServiceName,
+		ServiceType:  t.ServiceType,
+		maxSize:      t.maxSize,
+	}
+	t.mux.Lock()
+	t.Children = append(t.Children, child)
+	t.mux.Unlock()
+	return child
+}
+
+// The Append methods are safe for concurrent use
+
 func (t *Trace) AppendSQL(sqlInfo *LinkTraceSQL) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	t.SQLs = append(t.SQLs, sqlInfo)
 }
 
 func (t *Trace) AppendRedis(row *LinkTraceRedis) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	t.Redis = append(t.Redis, row)
 }
 
 func (t *Trace) AppendThirdPartyReq(row *LinkTraceDialog) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	t.ThirdPartyRequests = append(t.ThirdPartyRequests, row)
 }
 
+func (t *Trace) AppendExternal(row *LinkTraceExternal) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.External = append(t.External, row)
+}
+
 func (t *Trace) Set(key string, value any) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	if t.Extend == nil {
 		t.Extend = make(map[string]any)
 	}
-	t.mux.Lock()
-	defer t.mux.Unlock()
 	t.Extend[key] = value
 }
 
 func (t *Trace) AppendLogRow(row any) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	if t.LogRows == nil {
 		t.LogRows = make([]any, 0)
 	}
@@ -119,7 +223,7 @@ func (t *Trace) AppendLogRow(row any) {
 }
 
 func (t *Trace) NewLogInfo(row H) H {
-	h := H{"level": "info", "time": time.Now().Format("2006-01-02 15:04:05")}
+	h := H{"level": "info", "time": FormatTime(time.Now(), DateTime)}
 	for k, v := range row {
 		h[k] = v
 	}
@@ -127,7 +231,7 @@ func (t *Trace) NewLogInfo(row H) H {
 }
 
 func (t *Trace) NewLogError(row H) H {
-	h := H{"level": "error", "time": time.Now().Format("2006-01-02 15:04:05")}
+	h := H{"level": "error", "time": FormatTime(time.Now(), DateTime)}
 	for k, v := range row {
 		h[k] = v
 	}
@@ -135,7 +239,7 @@ func (t *Trace) NewLogError(row H) H {
 }
 
 func (t *Trace) NewLogWarning(row H) H {
-	h := H{"level": "warning", "time": time.Now().Format("2006-01-02 15:04:05")}
+	h := H{"level": "warning", "time": FormatTime(time.Now(), DateTime)}
 	for k, v := range row {
 		h[k] = v
 	}
@@ -144,6 +248,113 @@ func (t *Trace) NewLogWarning(row H) H {
 
 const trackCtxName string = "FIT_TRACE_CTX"
 
+const (
+	// TraceIdHeader header(metadata) carrying the trace id
+	TraceIdHeader = "FIT-TRACE-ID"
+	// SpanIdHeader header(metadata) carrying the span id assigned to the callee
+	SpanIdHeader = "FIT-SPAN-ID"
+	// ParentSpanIdHeader header(metadata) carrying the span id of the caller
+	ParentSpanIdHeader = "FIT-PARENT-SPAN-ID"
+	// TraceParentHeader W3C trace context header, format: version-traceid-parentid-flags
+	TraceParentHeader = "traceparent"
+)
+
+// gRPC metadata keys of the trace, metadata keys are always lowercase
+const (
+	TraceIdMetadataKey      = "fit-trace-id"
+	SpanIdMetadataKey       = "fit-span-id"
+	ParentSpanIdMetadataKey = "fit-parent-span-id"
+	TraceParentMetadataKey  = "traceparent"
+	RequestIdMetadataKey    = "x-request-id"
+)
+
+// NewSpanId generate a 16 hex character span id
+func NewSpanId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strings.ReplaceAll(uuid.New().String(), "-", "")[:16]
+	}
+	return hex.EncodeToString(b)
+}
+
+// resolveTraceIds determine the ids of the current span from the incoming headers.
+// A valid traceparent takes precedence over FIT-TRACE-ID, a malformed one is ignored.
+func resolveTraceIds(traceParent, traceId, spanId, parentSpanId string) (string, string, string) {
+	if tid, pid, ok := parseTraceParent(traceParent); ok {
+		if toW3CTraceId(traceId) != tid {
+			// FIT headers belong to another trace
+			traceId = tid
+			spanId = ""
+		}
+		parentSpanId = pid
+	}
+	if traceId == "" {
+		traceId = uuid.New().String()
+		parentSpanId = ""
+	}
+	if spanId == "" {
+		spanId = NewSpanId()
+	}
+	return traceId, spanId, parentSpanId
+}
+
+// parseTraceParent parse the W3C traceparent value.
+func parseTraceParent(v string) (traceId, parentId string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 {
+		return "", "", false
+	}
+	version := parts[0]
+	if !isLowerHex(version, 2) || version == "ff" {
+		return "", "", false
+	}
+	if version == "00" && len(parts) != 4 {
+		return "", "", false
+	}
+	traceId, parentId = parts[1], parts[2]
+	if !isLowerHex(traceId, 32) || traceId == strings.Repeat("0", 32) {
+		return "", "", false
+	}
+	if !isLowerHex(parentId, 16) || parentId == strings.Repeat("0", 16) {
+		return "", "", false
+	}
+	if !isLowerHex(parts[3], 2) {
+		return "", "", false
+	}
+	return traceId, parentId, true
+}
+
+// FormatTraceParent build a W3C traceparent value, returns an empty string
+// if the trace id cannot be represented as a W3C trace id.
+func FormatTraceParent(traceId, spanId string) string {
+	tid := toW3CTraceId(traceId)
+	if tid == "" || !isLowerHex(spanId, 16) {
+		return ""
+	}
+	return StringSpliceTag("-", "00", tid, spanId, "01")
+}
+
+// toW3CTraceId convert the trace id(uuid or 32 hex) to the W3C format.
+func toW3CTraceId(traceId string) string {
+	tid := strings.ToLower(strings.ReplaceAll(traceId, "-", ""))
+	if !isLowerHex(tid, 32) || tid == strings.Repeat("0", 32) {
+		return ""
+	}
+	return tid
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
 type responseWriter struct {
 	gin.ResponseWriter
 	b *bytes.Buffer
@@ -188,6 +399,11 @@ func GetTraceCtx(c context.Context) (trace *Trace, ok bool) {
 	return val, true
 }
 
+// ContextWithTrace returns a copy of ctx carrying trace, e.g. the Child of the trace for a goroutine
+func ContextWithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, trackCtxName, trace)
+}
+
 func GetGinTraceCtx(c *gin.Context) (trace *Trace, ok bool) {
 	val, ok := c.Get(trackCtxName)
 	if !ok {
@@ -209,11 +425,11 @@ type LinkTrace struct {
 	serviceName   string
 	serviceType   string
 	Func          func(*Trace)
-	trace         *Trace
 	hook          Hook
 	grpcHook      GrpcHookHandler
 	env           EnvType
 	devOutputNO   bool
+	maxTraceSize  int
 }
 
 // NewLinkTrace create a new tracker.
@@ -240,6 +456,11 @@ func (g *LinkTrace) SetDevOutputNO() {
 	g.devOutputNO = true
 }
 
+// SetMaxTraceSize the maximum size in bytes of a serialized trace, default 256KB, see Trace.MarshalJSON
+func (g *LinkTrace) SetMaxTraceSize(size int) {
+	g.maxTraceSize = size
+}
+
 func (g *LinkTrace) SetServiceName(name string) {
 	g.serviceName = name
 }
@@ -252,7 +473,15 @@ func (g *LinkTrace) AddHook(hook Hook) {
 	g.hook = hook
 }
 
+var grpcHookWarning sync.Once
+
+// GrpcHook run fn inside GrpcServerInterceptor instead of the handler.
+//
+// Deprecated: chain the interceptors with grpc.ChainUnaryInterceptor or ChainDefaultInterceptors
 func (g *LinkTrace) GrpcHook(fn GrpcHookHandler) {
+	grpcHookWarning.Do(func() {
+		Warning("msg", "LinkTrace.GrpcHook is deprecated, chain the interceptors with grpc.ChainUnaryInterceptor or fit.ChainDefaultInterceptors")
+	})
 	g.grpcHook = fn
 }
 
@@ -268,20 +497,25 @@ func (g *LinkTrace) GinTraceHandler() gin.HandlerFunc {
 			bytes.NewBuffer([]byte{}),
 		}
 		c.Writer = writer
-		traceId := c.GetHeader("FIT-TRACE-ID")
-		if len(traceId) == 0 {
-			traceId = uuid.New().String()
-		}
+		traceId, spanId, parentSpanId := resolveTraceIds(
+			c.GetHeader(TraceParentHeader),
+			c.GetHeader(TraceIdHeader),
+			c.GetHeader(SpanIdHeader),
+			c.GetHeader(ParentSpanIdHeader),
+		)
 
 		t := time.Now()
 		trace := &Trace{
-			TraceId:     traceId,
-			Start:       t.Unix(),
-			ServiceName: g.serviceName,
-			ServiceType: g.serviceType,
-			SourceIp:    c.ClientIP(),
+			TraceId:      traceId,
+			SpanId:       spanId,
+			ParentSpanId: parentSpanId,
+			Start:        t.Unix(),
+			StartMs:      t.UnixMilli(),
+			ServiceName:  g.serviceName,
+			ServiceType:  g.serviceType,
+			SourceIp:     c.ClientIP(),
+			maxSize:      g.maxTraceSize,
 		}
-		g.trace = trace
 		if g.hook != nil {
 			g.hook.BeforeProcess(trace)
 		}
@@ -289,21 +523,18 @@ func (g *LinkTrace) GinTraceHandler() gin.HandlerFunc {
 
 		c.Next()
 
-		trace.Request = &LinkTraceRequest{
-			Method: c.Request.Method,
-			Url:    c.Request.URL.String(),
-			Header: c.Request.Header,
-		}
-		trace.Response = &LinkTraceResponse{
-			Header:   writer.Header(),
-			HttpCode: writer.Status(),
-		}
-		if writer.Status() == http.StatusOK {
-			trace.Success = true
-		}
-
-		trace.End = time.Now().Unix()
-		trace.Cost = time.Since(t).String()
+		trace.finish(t, traceResult{
+			request: &LinkTraceRequest{
+				Method: c.Request.Method,
+				Url:    c.Request.URL.String(),
+				Header: c.Request.Header,
+			},
+			response: &LinkTraceResponse{
+				Header:   writer.Header(),
+				HttpCode: writer.Status(),
+			},
+			success: writer.Status() == http.StatusOK,
+		})
 
 		if g.hook != nil {
 			g.hook.AfterProcess(trace)
@@ -315,11 +546,11 @@ func (g *LinkTrace) GinTraceHandler() gin.HandlerFunc {
 		for _, kv := range g.LogRecordMode {
 			switch kv {
 			case "LOCAL":
-				OtherLog(g.LogFileName, UseLocal()).TranceInfo(trace)
+				OtherLog(g.LogFileName, UseLocal()).TraceInfo(trace)
 			case "REMOTE":
-				OtherLog(g.LogFileName, UseRemote()).TranceInfo(trace)
+				OtherLog(g.LogFileName, UseRemote()).TraceInfo(trace)
 			case "CONSOLE":
-				OtherLog(g.LogFileName, UseConsole()).TranceInfo(trace)
+				OtherLog(g.LogFileName, UseConsole()).TraceInfo(trace)
 			}
 		}
 	}
@@ -331,30 +562,14 @@ func (g *LinkTrace) GrpcServerInterceptor() grpc.UnaryServerInterceptor {
 			return handler(ctx, req)
 		}
 
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, errors.New("metadata.FromIncomingContext get fail")
-		}
-
-		var traceId string
-		fitTraceId, ok := md["fit-trace-id"]
-		if !ok || fitTraceId[0] == "" {
-			traceId = uuid.New().String()
-		} else {
-			traceId = fitTraceId[0]
+		// calls made in-process(e.g. by another interceptor) may have no metadata, a new trace is started then
+		md, _ := metadata.FromIncomingContext(ctx)
+		if md == nil {
+			md = metadata.MD{}
 		}
 
 		t := time.Now()
-		trace := &Trace{
-			TraceId:     traceId,
-			Start:       t.Unix(),
-			ServiceName: g.serviceName,
-			ServiceType: g.serviceType,
-		}
-		g.trace = trace
-		if g.hook != nil {
-			g.hook.BeforeProcess(trace)
-		}
+		trace := g.newGrpcTrace(md, t)
 		ctx = context.WithValue(ctx, trackCtxName, trace)
 
 		var res interface{}
@@ -365,56 +580,343 @@ func (g *LinkTrace) GrpcServerInterceptor() grpc.UnaryServerInterceptor {
 			res, err = handler(ctx, req)
 		}
 
-		trace.Request = &LinkTraceRequest{
-			Method: info.FullMethod,
-			Header: md,
+		g.finishGrpcTrace(trace, t, traceResult{
+			request: &LinkTraceRequest{
+				Method: info.FullMethod,
+				Header: md,
+			},
+			err: err,
+		})
+		return res, err
+	}
+}
+
+// GrpcStreamServerInterceptor trace streaming calls, the numbers of sent and received messages are recorded,
+// the trace is finished when the handler returns.
+func (g *LinkTrace) GrpcStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if g.devOutputNO && g.env == EnvDevelopment {
+			return handler(srv, ss)
 		}
 
-		trace.Error = err
-		trace.End = time.Now().Unix()
-		trace.Cost = time.Since(t).String()
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		if md == nil {
+			md = metadata.MD{}
+		}
 
-		if g.hook != nil {
-			g.hook.AfterProcess(trace)
+		t := time.Now()
+		trace := g.newGrpcTrace(md, t)
+		ws := &traceServerStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), trackCtxName, trace)}
+		err := handler(srv, ws)
+
+		g.finishGrpcTrace(trace, t, traceResult{
+			request: &LinkTraceRequest{
+				Method: info.FullMethod,
+				Header: md,
+			},
+			err:        err,
+			streamSent: atomic.LoadInt64(&ws.sent),
+			streamRecv: atomic.LoadInt64(&ws.recv),
+		})
+		return err
+	}
+}
+
+// traceServerStream carry the trace in the context and count the messages
+type traceServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent int64
+	recv int64
+}
+
+func (s *traceServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *traceServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	}
+	return err
+}
+
+func (s *traceServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.recv, 1)
+	}
+	return err
+}
+
+func (g *LinkTrace) newGrpcTrace(md metadata.MD, t time.Time) *Trace {
+	traceId, spanId, parentSpanId := resolveTraceIds(
+		MetadataValue(md, TraceParentMetadataKey),
+		MetadataValue(md, TraceIdMetadataKey),
+		MetadataValue(md, SpanIdMetadataKey),
+		MetadataValue(md, ParentSpanIdMetadataKey),
+	)
+
+	trace := &Trace{
+		TraceId:      traceId,
+		SpanId:       spanId,
+		ParentSpanId: parentSpanId,
+		Start:        t.Unix(),
+		StartMs:      t.UnixMilli(),
+		ServiceName:  g.serviceName,
+		ServiceType:  g.serviceType,
+		maxSize:      g.maxTraceSize,
+	}
+	if g.hook != nil {
+		g.hook.BeforeProcess(trace)
+	}
+	return trace
+}
+
+func (g *LinkTrace) finishGrpcTrace(trace *Trace, t time.Time, r traceResult) {
+	trace.finish(t, r)
+
+	if g.hook != nil {
+		g.hook.AfterProcess(trace)
+	}
+
+	if g.LogFileName == "" {
+		return
+	}
+	for _, kv := range g.LogRecordMode {
+		switch kv {
+		case "LOCAL":
+			OtherLog(g.LogFileName, UseLocal()).TraceInfo(trace)
+		case "REMOTE":
+			OtherLog(g.LogFileName, UseRemote()).TraceInfo(trace)
+		case "CONSOLE":
+			OtherLog(g.LogFileName, UseConsole()).TraceInfo(trace)
 		}
+	}
+}
 
-		if g.LogFileName == "" {
-			return res, err
+// MetadataValue the last non-empty value of key(case-insensitive), the value closest to the call wins
+// when it was set more than once, e.g. by the user and by an interceptor
+func MetadataValue(md metadata.MD, key string) string {
+	key = strings.ToLower(key)
+	var val string
+	for k, values := range md {
+		if strings.ToLower(k) != key {
+			continue
 		}
-		for _, kv := range g.LogRecordMode {
-			switch kv {
-			case "LOCAL":
-				OtherLog(g.LogFileName, UseLocal()).TranceInfo(trace)
-			case "REMOTE":
-				OtherLog(g.LogFileName, UseRemote()).TranceInfo(trace)
-			case "CONSOLE":
-				OtherLog(g.LogFileName, UseConsole()).TranceInfo(trace)
+		for _, v := range values {
+			if v != "" {
+				val = v
 			}
 		}
-		return res, err
 	}
+	return val
+}
+
+// SetOutgoingMetadata set the key-value pairs in the outgoing metadata of ctx, the values of the same keys(case-insensitive)
+// are replaced, the other metadata(e.g. authorization) is kept
+func SetOutgoingMetadata(ctx context.Context, kv ...string) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if md == nil {
+		md = metadata.MD{}
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		key := strings.ToLower(kv[i])
+		for k := range md {
+			if strings.ToLower(k) == key {
+				delete(md, k)
+			}
+		}
+		md[key] = []string{kv[i+1]}
+	}
+	return metadata.NewOutgoingContext(ctx, md)
 }
 
+// WithGrpcCtx propagate the trace of the context to the callee.
+// A child span id is generated for every call and recorded in the External entry,
+// so that the call tree can be rebuilt from SpanId and ParentSpanId.
 func WithGrpcCtx() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		trace, ok := ctx.Value(trackCtxName).(*Trace)
 		var startT time.Time
+		var childSpanId string
 		if ok {
-			ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("FIT-TRACE-ID", trace.TraceId))
+			ctx, childSpanId = outgoingTraceCtx(ctx, trace)
 			startT = time.Now()
 		}
 		err := invoker(ctx, method, req, reply, cc, opts...)
 		if ok {
-			trace.External = append(trace.External, &LinkTraceExternal{
-				Url:     method,
-				Type:    "gRPC Client",
-				Request: req,
-				Start:   startT.Unix(),
-				End:     time.Now().Unix(),
-				Error:   err,
-				Cost:    time.Since(startT).String(),
-			})
+			external := &LinkTraceExternal{
+				Url:          method,
+				Type:         "gRPC Client",
+				Request:      req,
+				SpanId:       childSpanId,
+				ParentSpanId: trace.SpanId,
+				Error:        err,
+			}
+			external.finish(startT)
+			trace.AppendExternal(external)
 		}
 		return err
 	}
 }
+
+// WithGrpcStreamCtx WithGrpcCtx for streaming calls, the External entry is appended when the stream ends
+// (RecvMsg returns io.EOF or an error, or the single response of a client-streaming call is received)
+// and records the numbers of sent and received messages.
+func WithGrpcStreamCtx() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		trace, ok := ctx.Value(trackCtxName).(*Trace)
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		ctx, childSpanId := outgoingTraceCtx(ctx, trace)
+		cs := &traceClientStream{
+			trace:         trace,
+			method:        method,
+			spanId:        childSpanId,
+			serverStreams: desc.ServerStreams,
+			start:         time.Now(),
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cs.finish(err)
+			return nil, err
+		}
+		cs.ClientStream = stream
+		return cs, nil
+	}
+}
+
+type traceClientStream struct {
+	grpc.ClientStream
+	trace         *Trace
+	method        string
+	spanId        string
+	serverStreams bool
+	start         time.Time
+	sent          int64
+	recv          int64
+	once          sync.Once
+}
+
+func (s *traceClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	} else if err != io.EOF {
+		// io.EOF means the stream was ended by the server, the status is returned by RecvMsg
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *traceClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.recv, 1)
+		if !s.serverStreams {
+			s.finish(nil)
+		}
+		return nil
+	}
+	if err == io.EOF {
+		s.finish(nil)
+	} else {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *traceClientStream) finish(err error) {
+	s.once.Do(func() {
+		external := &LinkTraceExternal{
+			Url:          s.method,
+			Type:         "gRPC Client Stream",
+			Request:      H{"sent": atomic.LoadInt64(&s.sent), "recv": atomic.LoadInt64(&s.recv)},
+			SpanId:       s.spanId,
+			ParentSpanId: s.trace.SpanId,
+			Error:        err,
+		}
+		external.finish(s.start)
+		s.trace.AppendExternal(external)
+	})
+}
+
+// outgoingTraceCtx add the trace metadata with a new child span id to the outgoing context
+func outgoingTraceCtx(ctx context.Context, trace *Trace) (context.Context, string) {
+	childSpanId := NewSpanId()
+	kv := []string{
+		TraceIdMetadataKey, trace.TraceId,
+		SpanIdMetadataKey, childSpanId,
+		ParentSpanIdMetadataKey, trace.SpanId,
+	}
+	if tp := FormatTraceParent(trace.TraceId, trace.SpanId); tp != "" {
+		kv = append(kv, TraceParentMetadataKey, tp)
+	}
+	return SetOutgoingMetadata(ctx, kv...), childSpanId
+}
+
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+// TraceTransport wrap a http.RoundTripper, when the request context carries a *Trace,
+// the trace headers(including W3C traceparent) are injected and the call is recorded by AppendThirdPartyReq,
+// with the child span id sent in FIT-SPAN-ID as SpanId.
+//
+// example: http.Client{Transport: fit.TraceTransport(nil)}, request.WithContext(c)
+func TraceTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &traceTransport{base: base}
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace, ok := GetTraceCtx(req.Context())
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	childSpanId := NewSpanId()
+	req = req.Clone(req.Context())
+	req.Header.Set(TraceIdHeader, trace.TraceId)
+	req.Header.Set(SpanIdHeader, childSpanId)
+	req.Header.Set(ParentSpanIdHeader, trace.SpanId)
+	if tp := FormatTraceParent(trace.TraceId, trace.SpanId); tp != "" {
+		req.Header.Set(TraceParentHeader, tp)
+	}
+
+	dialog := &LinkTraceDialog{
+		Request: &LinkTraceRequest{
+			Method: req.Method,
+			Url:    req.URL.String(),
+			Header: req.Header,
+		},
+		SpanId:       childSpanId,
+		ParentSpanId: trace.SpanId,
+	}
+	startT := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	endT := time.Now()
+	elapsed := endT.Sub(startT)
+	row := &LinkTraceResponse{Cost: elapsed.String()}
+	if err != nil {
+		row.HttpMsg = err.Error()
+	} else {
+		row.Header = resp.Header
+		row.HttpCode = resp.StatusCode
+		row.HttpMsg = resp.Status
+		dialog.Success = resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices
+	}
+	dialog.Responses = append(dialog.Responses, row)
+	dialog.StartMs = startT.UnixMilli()
+	dialog.EndMs = endT.UnixMilli()
+	dialog.CostMs = DurationMs(elapsed)
+	dialog.Cost = row.Cost
+	trace.AppendThirdPartyReq(dialog)
+	return resp, err
+}
@@ -0,0 +1,94 @@
+package fit
+
+import (
+	"encoding/json"
+)
+
+// DefaultMaxTraceSize the default maximum size in bytes of a serialized trace
+const DefaultMaxTraceSize = 256 << 10
+
+type traceAlias Trace
+
+// traceJSON the fields of the outer struct shadow the ones of the embedded Trace
+type traceJSON struct {
+	*traceAlias
+	Error     string            `json:"error"`
+	LogRows   []any             `json:"log_rows"`
+	SQLs      []*LinkTraceSQL   `json:"sqls"`
+	Redis     []*LinkTraceRedis `json:"redis"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// MarshalJSON the errors are serialized as strings. When the result exceeds the maximum size(see LinkTrace.SetMaxTraceSize)
+// the oldest entries of LogRows, SQLs and Redis are dropped and "truncated": true is added.
+func (t *Trace) MarshalJSON() ([]byte, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	v := traceJSON{
+		traceAlias: (*traceAlias)(t),
+		Error:      traceError(t.Error),
+		LogRows:    t.LogRows,
+		SQLs:       t.SQLs,
+		Redis:      t.Redis,
+	}
+	data, err := json.Marshal(&v)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := t.maxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxTraceSize
+	}
+	for len(data) > maxSize && len(v.LogRows)+len(v.SQLs)+len(v.Redis) > 0 {
+		v.Truncated = true
+		excess := len(data) - maxSize
+		// drop the oldest entries of the longest list until the excess is covered, then check again
+		for excess > 0 && len(v.LogRows)+len(v.SQLs)+len(v.Redis) > 0 {
+			var size int
+			switch {
+			case len(v.LogRows) >= len(v.SQLs) && len(v.LogRows) >= len(v.Redis):
+				size = jsonSize(v.LogRows[0])
+				v.LogRows = v.LogRows[1:]
+			case len(v.SQLs) >= len(v.Redis):
+				size = jsonSize(v.SQLs[0])
+				v.SQLs = v.SQLs[1:]
+			default:
+				size = jsonSize(v.Redis[0])
+				v.Redis = v.Redis[1:]
+			}
+			excess -= size + 1
+		}
+		if data, err = json.Marshal(&v); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+type externalAlias LinkTraceExternal
+
+// MarshalJSON the error is serialized as a string
+func (e *LinkTraceExternal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		*externalAlias
+		Error string `json:"error"`
+	}{
+		externalAlias: (*externalAlias)(e),
+		Error:         traceError(e.Error),
+	})
+}
+
+// traceError the error message as logged by errorString, empty when nil
+func traceError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return errorString(err)
+}
+
+func jsonSize(v any) int {
+	data, _ := json.Marshal(v)
+	return len(data)
+}
@@ -0,0 +1,97 @@
+package fit
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTraceMarshalJSONMaxSize(t *testing.T) {
+	const rows = 2000
+	for _, maxSize := range []int{0, 8 << 10} {
+		trace := &Trace{TraceId: "trace-1", Error: errors.New("boom"), maxSize: maxSize}
+		for i := 0; i < rows; i++ {
+			n := strconv.Itoa(i)
+			trace.AppendLogRow(n + strings.Repeat("x", 100))
+			trace.AppendSQL(&LinkTraceSQL{SQL: "select " + n + strings.Repeat(" ", 100)})
+			trace.AppendRedis(&LinkTraceRedis{Handle: "get " + n + strings.Repeat(" ", 100)})
+		}
+		trace.AppendExternal(&LinkTraceExternal{Type: "gRPC Client", Error: errors.New("unavailable")})
+
+		data, err := json.Marshal(trace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		limit := maxSize
+		if limit == 0 {
+			limit = DefaultMaxTraceSize
+		}
+		if len(data) > limit {
+			t.Fatalf("max %d: size %d exceeds the cap", limit, len(data))
+		}
+
+		var got struct {
+			Error     string            `json:"error"`
+			Truncated bool              `json:"truncated"`
+			LogRows   []string          `json:"log_rows"`
+			SQLs      []*LinkTraceSQL   `json:"sqls"`
+			Redis     []*LinkTraceRedis `json:"redis"`
+			External  []struct {
+				Error string `json:"error"`
+			} `json:"external"`
+		}
+		if err = json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Truncated {
+			t.Errorf("max %d: truncated not set", limit)
+		}
+		if got.Error != "boom" || len(got.External) != 1 || got.External[0].Error != "unavailable" {
+			t.Errorf("max %d: errors not serialized as strings: %q %+v", limit, got.Error, got.External)
+		}
+		if len(got.LogRows) == 0 || len(got.LogRows) == rows {
+			t.Errorf("max %d: kept %d of %d log rows", limit, len(got.LogRows), rows)
+		}
+		// the oldest entries are dropped: what is kept is the tail of every list
+		for i, row := range got.LogRows {
+			if want := strconv.Itoa(rows-len(got.LogRows)+i) + strings.Repeat("x", 100); row != want {
+				t.Fatalf("max %d: log row %d = %.10q…, want the newest entries", limit, i, row)
+			}
+		}
+		for i, sql := range got.SQLs {
+			if want := "select " + strconv.Itoa(rows-len(got.SQLs)+i); strings.TrimSpace(sql.SQL) != want {
+				t.Fatalf("max %d: sql %d = %q, want %q", limit, i, strings.TrimSpace(sql.SQL), want)
+			}
+		}
+		for i, r := range got.Redis {
+			if want := "get " + strconv.Itoa(rows-len(got.Redis)+i); strings.TrimSpace(r.Handle) != want {
+				t.Fatalf("max %d: redis %d = %q, want %q", limit, i, strings.TrimSpace(r.Handle), want)
+			}
+		}
+		// the trace itself is left untouched
+		if len(trace.LogRows) != rows || len(trace.SQLs) != rows || len(trace.Redis) != rows {
+			t.Errorf("max %d: marshalling modified the trace", limit)
+		}
+	}
+}
+
+func TestTraceMarshalJSONUnderMaxSize(t *testing.T) {
+	trace := &Trace{TraceId: "trace-1"}
+	trace.AppendLogRow("row")
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err = json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["truncated"]; ok {
+		t.Errorf("truncated set on a small trace: %s", data)
+	}
+	if got["error"] != "" {
+		t.Errorf("error = %v, want empty string", got["error"])
+	}
+}
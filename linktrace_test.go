@@ -0,0 +1,283 @@
+package fit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// marshalHook serialize the trace when the middleware finishes it
+type marshalHook struct {
+	mux    sync.Mutex
+	traces [][]byte
+}
+
+func (h *marshalHook) BeforeProcess(*Trace) {}
+
+func (h *marshalHook) AfterProcess(trace *Trace) {
+	data, _ := json.Marshal(trace)
+	h.mux.Lock()
+	h.traces = append(h.traces, data)
+	h.mux.Unlock()
+}
+
+func newTraceEngine(gt *LinkTrace, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gt.GinTraceHandler())
+	r.GET("/", handler)
+	return r
+}
+
+func TestTraceConcurrentAppend(t *testing.T) {
+	gt := NewLinkTrace("")
+	gt.AddHook(new(marshalHook))
+
+	const goroutines, rows = 50, 20
+	var wg, readers sync.WaitGroup
+	stop := make(chan struct{})
+	var trace *Trace
+	r := newTraceEngine(gt, func(c *gin.Context) {
+		trace, _ = GetGinTraceCtx(c)
+		// serialize the trace continuously while it is appended to and finished
+		for i := 0; i < 2; i++ {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						_, _ = json.Marshal(trace)
+					}
+				}
+			}()
+		}
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				child := trace.Child()
+				for j := 0; j < rows; j++ {
+					trace.AppendSQL(&LinkTraceSQL{SQL: "select 1"})
+					trace.AppendRedis(&LinkTraceRedis{Handle: "get"})
+					trace.AppendThirdPartyReq(&LinkTraceDialog{})
+					trace.AppendExternal(&LinkTraceExternal{Type: "gRPC Client"})
+					trace.AppendLogRow(j)
+					trace.Set("key", j)
+					child.AppendLogRow(j)
+				}
+			}()
+		}
+		// let the readers start, then return while the goroutines are still appending,
+		// the middleware finishes the trace meanwhile
+		time.Sleep(time.Millisecond * 20)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	if len(trace.SQLs) != goroutines*rows || len(trace.Redis) != goroutines*rows ||
+		len(trace.External) != goroutines*rows || len(trace.ThirdPartyRequests) != goroutines*rows ||
+		len(trace.LogRows) != goroutines*rows {
+		t.Fatalf("lost entries: sqls=%d redis=%d external=%d third=%d rows=%d",
+			len(trace.SQLs), len(trace.Redis), len(trace.External), len(trace.ThirdPartyRequests), len(trace.LogRows))
+	}
+	if len(trace.Children) != goroutines {
+		t.Fatalf("children = %d, want %d", len(trace.Children), goroutines)
+	}
+	for _, child := range trace.Children {
+		if child.TraceId != trace.TraceId || child.ParentSpanId != trace.SpanId {
+			t.Fatalf("child not linked to the trace: %+v", child)
+		}
+		for j, row := range child.LogRows {
+			if row != j {
+				t.Fatalf("child rows out of order: %v", child.LogRows)
+			}
+		}
+	}
+	if trace.Response == nil || trace.Response.HttpCode != http.StatusOK || !trace.Success {
+		t.Fatalf("trace not finished: %+v", trace.Response)
+	}
+}
+
+func TestTraceConcurrentRequestsIsolated(t *testing.T) {
+	gt := NewLinkTrace("")
+	hook := new(marshalHook)
+	gt.AddHook(hook)
+	r := newTraceEngine(gt, func(c *gin.Context) {
+		trace, ok := GetGinTraceCtx(c)
+		if !ok {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		// overlap with the other requests
+		n := c.Query("n")
+		trace.AppendSQL(&LinkTraceSQL{SQL: "select " + n})
+		time.Sleep(time.Millisecond * 5)
+		trace.Set("request", n)
+		trace.AppendRedis(&LinkTraceRedis{Handle: "GET", Args: n})
+		trace.AppendSQL(&LinkTraceSQL{SQL: "update " + n})
+		c.String(http.StatusOK, trace.TraceId)
+	})
+
+	const requests = 100
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			traceId := strconv.Itoa(i) + "-trace"
+			req := httptest.NewRequest(http.MethodGet, "/?n="+strconv.Itoa(i), nil)
+			req.Header.Set(TraceIdHeader, traceId)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Body.String() != traceId {
+				t.Errorf("request %d got trace %q, want %q", i, w.Body.String(), traceId)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(hook.traces) != requests {
+		t.Fatalf("finished traces = %d, want %d", len(hook.traces), requests)
+	}
+	for _, data := range hook.traces {
+		var trace struct {
+			TraceId string                 `json:"trace_id"`
+			Extend  map[string]interface{} `json:"extend"`
+			SQLs    []*LinkTraceSQL        `json:"sqls"`
+			Redis   []*LinkTraceRedis      `json:"redis"`
+		}
+		if err := json.Unmarshal(data, &trace); err != nil {
+			t.Fatal(err)
+		}
+		n, _ := trace.Extend["request"].(string)
+		if want := n + "-trace"; trace.TraceId != want {
+			t.Errorf("trace %q recorded the values of request %q", trace.TraceId, want)
+		}
+		// only the rows of its own request, in order
+		n = strings.TrimSuffix(trace.TraceId, "-trace")
+		if len(trace.SQLs) != 2 || trace.SQLs[0].SQL != "select "+n || trace.SQLs[1].SQL != "update "+n {
+			t.Errorf("trace %q: sqls = %s", trace.TraceId, data)
+		}
+		if len(trace.Redis) != 1 || trace.Redis[0].Args != n {
+			t.Errorf("trace %q: redis = %s", trace.TraceId, data)
+		}
+	}
+}
+
+func TestTraceOutgoingMetadataKept(t *testing.T) {
+	trace := &Trace{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7"}
+	newCtx := func() context.Context {
+		// metadata set by the caller, e.g. the token of PerRPC credentials, and a stale trace id in another case
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.MD{
+			"authorization": {"Bearer token"},
+			"x-multi":       {"1", "2"},
+			"Fit-Trace-Id":  {"stale"},
+		})
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-appended", "v")
+		return ContextWithTrace(ctx, trace)
+	}
+	check := func(name string, ctx context.Context) {
+		t.Helper()
+		md, _ := metadata.FromOutgoingContext(ctx)
+		want := map[string][]string{
+			"authorization": {"Bearer token"},
+			"x-multi":       {"1", "2"},
+			"x-appended":    {"v"},
+			// the stale trace id is replaced, not appended to
+			"fit-trace-id": {trace.TraceId},
+		}
+		for k, v := range want {
+			if !reflect.DeepEqual(md[k], v) {
+				t.Errorf("%s: metadata %s = %v, want %v", name, k, md[k], v)
+			}
+		}
+		if v := md[SpanIdMetadataKey]; len(v) != 1 || v[0] == trace.SpanId {
+			t.Errorf("%s: span id = %v, want a child span", name, v)
+		}
+		if v := md[ParentSpanIdMetadataKey]; len(v) != 1 || v[0] != trace.SpanId {
+			t.Errorf("%s: parent span id = %v, want %s", name, v, trace.SpanId)
+		}
+		if v := md[TraceParentMetadataKey]; len(v) != 1 || v[0] != FormatTraceParent(trace.TraceId, trace.SpanId) {
+			t.Errorf("%s: traceparent = %v", name, v)
+		}
+	}
+
+	ctx := newCtx()
+	err := WithGrpcCtx()(ctx, "/test.Service/Unary", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			check("unary", ctx)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the metadata of the caller is not modified
+	if md, _ := metadata.FromOutgoingContext(ctx); !reflect.DeepEqual(md[TraceIdMetadataKey], []string{"stale"}) {
+		t.Errorf("caller metadata modified: %v", md)
+	}
+
+	_, err = WithGrpcStreamCtx()(newCtx(), &grpc.StreamDesc{ServerStreams: true}, nil, "/test.Service/Stream",
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			check("stream", ctx)
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTraceTransportRecordsSpan(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	trace := &Trace{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7"}
+	req, _ := http.NewRequestWithContext(ContextWithTrace(context.Background(), trace), http.MethodGet, srv.URL+"/user", nil)
+	resp, err := (&http.Client{Transport: TraceTransport(nil)}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	h := <-headers
+	if len(trace.ThirdPartyRequests) != 1 {
+		t.Fatalf("third party requests = %d, want 1", len(trace.ThirdPartyRequests))
+	}
+	dialog := trace.ThirdPartyRequests[0]
+	// the span id sent to the callee is the one recorded in the trace of the caller
+	if span := h.Get(SpanIdHeader); span == "" || span == trace.SpanId || dialog.SpanId != span {
+		t.Errorf("span id sent %q, recorded %q", span, dialog.SpanId)
+	}
+	if dialog.ParentSpanId != trace.SpanId || h.Get(ParentSpanIdHeader) != trace.SpanId {
+		t.Errorf("parent span id recorded %q, sent %q", dialog.ParentSpanId, h.Get(ParentSpanIdHeader))
+	}
+	if dialog.Request.Url != srv.URL+"/user" {
+		t.Errorf("url = %s", dialog.Request.Url)
+	}
+	if len(dialog.Responses) != 1 || dialog.Responses[0].HttpCode != http.StatusTeapot || dialog.Success {
+		t.Errorf("responses = %+v, success = %v", dialog.Responses, dialog.Success)
+	}
+	if dialog.Cost == "" || dialog.EndMs < dialog.StartMs {
+		t.Errorf("cost = %q, %d-%d", dialog.Cost, dialog.StartMs, dialog.EndMs)
+	}
+}
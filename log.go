@@ -1,14 +1,19 @@
 package fit
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/natefinch/lumberjack"
 	"github.com/sirupsen/logrus"
+	"io"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
@@ -21,10 +26,16 @@ var logs map[string]*logrus.Logger
 
 var outConsole bool
 
-var remoteRabbitMQLog *RemoteRabbitMQLog
+var consoleLogLevel LogLevel
+
+var consoleLevelSet bool
 
 var customizeLog chan map[string]interface{}
 
+var customizeLogMux sync.RWMutex
+
+var customizeLogDropped uint64
+
 var stackLength = 300
 
 type LogLevel uint8
@@ -46,8 +57,6 @@ const (
 	TextFormatter
 )
 
-var remoteTemplateHandler RemoteLogTemplater
-
 type RemoteRabbitMQLog struct {
 	RabbitMQUrl string
 	Exchange    string
@@ -57,6 +66,8 @@ type RemoteRabbitMQLog struct {
 	AutoDel     bool
 	Simple      bool
 	MaxConnAt   int64
+	// Minimum level published by this config, overrides SetRemoteLogMinLevel, 0 means not set
+	MinLevel LogLevel
 }
 
 type Fields map[string]any
@@ -100,6 +111,15 @@ type LogEntity struct {
 	Formatter    int
 	ReportCaller bool
 	NoColor      bool
+	// Write to the file in a dedicated goroutine, the caller only queues the entry
+	Async bool
+	// Queue capacity of the asynchronous mode, default 4096
+	QueueSize int
+	// Behavior when the queue is full, OverflowPolicyDrop(default) or OverflowPolicyBlock
+	OverflowPolicy int
+	// Write the entries of the given levels to other files(file name without extension) instead of FileName,
+	// e.g. {ErrorLevel: "error"}, the files are created on first use with the same settings.
+	LevelRouting map[LogLevel]string
 }
 
 func GetLogInstances() map[string]*logrus.Logger {
@@ -131,6 +151,35 @@ type RemoteLogTemplater interface {
 	Error(err error)
 }
 
+// RemoteLogLevelTemplater RemoteLogTemplater receiving the level of the log
+type RemoteLogLevelTemplater interface {
+	Before(level LogLevel, body map[string]interface{}) map[string]interface{}
+	Error(level LogLevel, err error)
+}
+
+// remoteLogTemplaterAdapter adapt a RemoteLogTemplater to RemoteLogLevelTemplater
+type remoteLogTemplaterAdapter struct {
+	RemoteLogTemplater
+}
+
+func (a remoteLogTemplaterAdapter) Before(_ LogLevel, body map[string]interface{}) map[string]interface{} {
+	return a.RemoteLogTemplater.Before(body)
+}
+
+func (a remoteLogTemplaterAdapter) Error(_ LogLevel, err error) {
+	a.RemoteLogTemplater.Error(err)
+}
+
+type remoteLogHook struct {
+	src  interface{}
+	hook RemoteLogLevelTemplater
+}
+
+var (
+	remoteLogHooksMux sync.RWMutex
+	remoteLogHooks    []remoteLogHook
+)
+
 type Local struct {
 	instanceName string
 }
@@ -185,6 +234,7 @@ func (l Local) Fatal(v ...interface{}) {
 		caller.join = StringSpliceTag(":", fileName, strconv.Itoa(line))
 	}
 	writeLocalLogInstance(l.instanceName, FatalLevel, getBody(v...), caller)
+	callFatalHandler()
 }
 
 type LogBodyContent struct {
@@ -193,48 +243,107 @@ type LogBodyContent struct {
 	text string
 }
 
+// getBody convert the arguments to the log fields, the arguments are a single error, string or map(Fields, H),
+// or key/value pairs. A non-string key is formatted with fmt.Sprint, the unpaired last element is kept
+// under the key "arg_n"(n is its index) and error values are rendered as strings.
 func getBody(v ...interface{}) map[string]interface{} {
-	var body string
 	if len(v) == 1 {
-		eVal, ok := v[0].(error)
-		if ok {
-			body = eVal.Error()
-			if utf8.RuneCountInString(body) > stackLength {
-				body = SubStrDecodeRuneInString(body, stackLength)
+		switch val := v[0].(type) {
+		case error:
+			if errorExpansion {
+				return map[string]interface{}{"msg": "An error has occurred", "err_chain": errorChain(val)}
 			}
-			return map[string]interface{}{"msg": "An error has occurred", "err": body}
+			return map[string]interface{}{"msg": "An error has occurred", "err": errorString(val)}
+		case string:
+			return map[string]interface{}{"msg": val}
+		case Fields:
+			return mapBody(val)
+		case H:
+			return mapBody(val)
+		case map[string]interface{}:
+			return mapBody(val)
 		}
+		// any other value(number, struct...) is kept as the message
+		return map[string]interface{}{"msg": fmt.Sprint(v[0])}
+	}
 
-		bVal, ok := v[0].(string)
+	body := make(map[string]interface{}, len(v)/2+1)
+	for i := 0; i < len(v); i += 2 {
+		if i+1 >= len(v) {
+			body["arg_"+strconv.Itoa(i)] = fieldValue(v[i])
+			break
+		}
+		key, ok := v[i].(string)
 		if !ok {
-			body = ""
-			return map[string]interface{}{"msg": body}
+			key = fmt.Sprint(v[i])
+		}
+		if _, ok := v[i+1].(error); ok && key == "err" && errorExpansion {
+			key = "err_chain"
 		}
+		body[key] = fieldValue(v[i+1])
+	}
+	return body
+}
 
-		if utf8.RuneCountInString(body) > stackLength {
-			body = SubStrDecodeRuneInString(body, stackLength)
+// mapBody copy the map, the log functions modify the body
+func mapBody(m map[string]interface{}) map[string]interface{} {
+	body := make(map[string]interface{}, len(m))
+	for k, val := range m {
+		body[k] = fieldValue(val)
+	}
+	return body
+}
+
+func fieldValue(v interface{}) interface{} {
+	if er, ok := v.(error); ok {
+		if errorExpansion {
+			return errorChain(er)
 		}
-		body = bVal
-		return map[string]interface{}{"msg": body}
+		return errorString(er)
+	}
+	return v
+}
+
+// errorString intercept stack information of specified length, a marker with the number of
+// the dropped characters is appended
+func errorString(err error) string {
+	return truncateStack(err.Error())
+}
+
+func truncateStack(s string) string {
+	n := utf8.RuneCountInString(s)
+	if n <= stackLength {
+		return s
 	}
+	return SubStrDecodeRuneInString(s, stackLength) + fmt.Sprintf("…(truncated, %d more chars)", n-stackLength)
+}
+
+var errorExpansion bool
 
-	//intercept stack information of specified length
-	indx := -1
-	for i, kv := range v {
-		if kv == "err" {
-			indx = i + 1
+// SetErrorExpansion log the errors as an "err_chain" array, one element(type and message) for
+// each error of the Unwrap chain, instead of a single string, default false.
+func SetErrorExpansion(v bool) {
+	errorExpansion = v
+}
+
+func errorChain(err error) []map[string]interface{} {
+	var chain []map[string]interface{}
+	errs := []error{err}
+	for len(errs) > 0 {
+		e := errs[0]
+		errs = errs[1:]
+		if e == nil {
 			continue
 		}
-		if indx == i {
-			er, ok := kv.(error)
-			if ok {
-				v[i] = SubStrDecodeRuneInString(er.Error(), stackLength)
-			}
-			break
+		chain = append(chain, map[string]interface{}{"type": fmt.Sprintf("%T", e), "msg": truncateStack(e.Error())})
+		switch u := e.(type) {
+		case interface{ Unwrap() error }:
+			errs = append(errs, u.Unwrap())
+		case interface{ Unwrap() []error }:
+			errs = append(errs, u.Unwrap()...)
 		}
 	}
-
-	return SliceConvertMap(v)
+	return chain
 }
 
 func writeLocalLog(level LogLevel, body map[string]interface{}, rc ...reportCaller) {
@@ -242,7 +351,7 @@ func writeLocalLog(level LogLevel, body map[string]interface{}, rc ...reportCall
 	if !ok {
 		return
 	}
-	writeFile(el, level, body, rc)
+	writeFile(routeLogger(defLog, el, level), level, body, rc)
 }
 
 func writeLocalLogToJson(level LogLevel, body map[string]interface{}, rc ...reportCaller) {
@@ -251,7 +360,7 @@ func writeLocalLogToJson(level LogLevel, body map[string]interface{}, rc ...repo
 		return
 	}
 
-	writeJsonToFile(el, level, body, rc)
+	writeJsonToFile(routeLogger(defLog, el, level), level, body, rc)
 }
 
 func writeLocalLogInstance(instance string, level LogLevel, body map[string]interface{}, rc ...reportCaller) {
@@ -260,13 +369,42 @@ func writeLocalLogInstance(instance string, level LogLevel, body map[string]inte
 		if len(logs) == 0 {
 			return
 		}
-		for _, v := range logs {
-			el = v
+		for k, v := range logs {
+			instance, el = k, v
 			break
 		}
 	}
 
-	writeFile(el, level, body, rc)
+	writeFile(routeLogger(instance, el, level), level, body, rc)
+}
+
+type levelRouter struct {
+	entity  LogEntity
+	mux     sync.Mutex
+	loggers map[string]*logrus.Logger
+}
+
+var logRouters map[string]*levelRouter
+
+// routeLogger the logger of the file the level is routed to, el when the level has no mapping
+func routeLogger(name string, el *logrus.Logger, level LogLevel) *logrus.Logger {
+	r, ok := logRouters[name]
+	if !ok {
+		return el
+	}
+	file, ok := r.entity.LevelRouting[level]
+	if !ok || file == "" || file == r.entity.FileName {
+		return el
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if l, ok := r.loggers[file]; ok {
+		return l
+	}
+	l := newLogger(r.entity, file)
+	r.loggers[file] = l
+	return l
 }
 
 func writeFile(el *logrus.Logger, level LogLevel, body map[string]interface{}, rc []reportCaller) {
@@ -287,7 +425,7 @@ func writeFile(el *logrus.Logger, level LogLevel, body map[string]interface{}, r
 		case WarnLevel:
 			entry.Warning(msg)
 		case FatalLevel:
-			entry.Fatal(msg)
+			entry.Log(logrus.FatalLevel, msg)
 		case InfoLevel:
 			entry.Info(msg)
 		case DebugLevel:
@@ -310,7 +448,7 @@ func writeFile(el *logrus.Logger, level LogLevel, body map[string]interface{}, r
 	case WarnLevel:
 		entry.Warning(msg)
 	case FatalLevel:
-		entry.Fatal(msg)
+		entry.Log(logrus.FatalLevel, msg)
 	case InfoLevel:
 		entry.Info(msg)
 	case DebugLevel:
@@ -339,7 +477,7 @@ func writeJsonToFile(el *logrus.Logger, level LogLevel, body map[string]interfac
 		case WarnLevel:
 			entry.Warning(msg)
 		case FatalLevel:
-			entry.Fatal(msg)
+			entry.Log(logrus.FatalLevel, msg)
 		case InfoLevel:
 			entry.Info(msg)
 		case DebugLevel:
@@ -366,7 +504,7 @@ func writeJsonToFile(el *logrus.Logger, level LogLevel, body map[string]interfac
 	case WarnLevel:
 		entry.Warning(msg)
 	case FatalLevel:
-		entry.Fatal(msg)
+		entry.Log(logrus.FatalLevel, msg)
 	case InfoLevel:
 		entry.Info(msg)
 	case DebugLevel:
@@ -374,42 +512,70 @@ func writeJsonToFile(el *logrus.Logger, level LogLevel, body map[string]interfac
 	}
 }
 
+// printConsole print the message when console output is enabled and the level is enabled for the console
+func printConsole(level LogLevel, caller reportCaller, v ...interface{}) {
+	if !outConsole {
+		return
+	}
+	max := globalLogLevel
+	if consoleLevelSet {
+		max = consoleLogLevel
+	}
+	if level > max {
+		return
+	}
+
+	var levelInitial string
+	switch level {
+	case ErrorLevel:
+		levelInitial = "E"
+		color.Set(color.FgRed)
+	case WarnLevel:
+		levelInitial = "W"
+		color.Set(color.FgHiYellow)
+	case FatalLevel:
+		levelInitial = "F"
+		color.Set(color.FgHiRed)
+	case InfoLevel:
+		levelInitial = "I"
+		color.Set(color.BgHiMagenta)
+	case DebugLevel:
+		levelInitial = "D"
+		color.Set(color.BgGreen)
+	}
+	sprintf := fmt.Sprintf("[%s] ", levelInitial)
+	if isReportCaller {
+		sprintf += fmt.Sprintf("[%s] ", caller.join)
+	}
+	sprintf += fmt.Sprint(v...)
+	fmt.Println(sprintf)
+	color.Unset()
+}
+
 func output(level LogLevel, v ...interface{}) {
+	outputFields(level, outputCaller(), nil, v...)
+}
+
+// outputCtx output with the request id of ctx, see LogCtx
+func outputCtx(ctx context.Context, level LogLevel, v ...interface{}) {
+	outputFields(level, outputCaller(), requestIDFields(ctx), v...)
+}
+
+// outputCaller the caller of the log function calling output or outputCtx
+func outputCaller() reportCaller {
 	var caller reportCaller
 	if isReportCaller {
-		if _, file, line, ok := runtime.Caller(2); ok {
+		if _, file, line, ok := runtime.Caller(3); ok {
 			_, fileName := filepath.Split(file)
 			caller.join = StringSpliceTag(":", fileName, strconv.Itoa(line))
 		}
 	}
+	return caller
+}
 
-	if outConsole {
-		var levelInitial string
-		switch level {
-		case ErrorLevel:
-			levelInitial = "E"
-			color.Set(color.FgRed)
-		case WarnLevel:
-			levelInitial = "W"
-			color.Set(color.FgHiYellow)
-		case FatalLevel:
-			levelInitial = "F"
-			color.Set(color.FgHiRed)
-		case InfoLevel:
-			levelInitial = "I"
-			color.Set(color.BgHiMagenta)
-		case DebugLevel:
-			levelInitial = "D"
-			color.Set(color.BgGreen)
-		}
-		sprintf := fmt.Sprintf("[%s] ", levelInitial)
-		if isReportCaller {
-			sprintf += fmt.Sprintf("[%s] ", caller.join)
-		}
-		sprintf += fmt.Sprint(v...)
-		fmt.Println(sprintf)
-		color.Unset()
-	}
+// outputFields write the log to all sinks, fields are added to the body
+func outputFields(level LogLevel, caller reportCaller, fields map[string]interface{}, v ...interface{}) {
+	printConsole(level, caller, v...)
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -419,29 +585,18 @@ func output(level LogLevel, v ...interface{}) {
 
 	//slice to json
 	body := getBody(v...)
-	if customizeLog != nil {
-		customizeLog <- body
+	for k, val := range fields {
+		body[k] = val
 	}
+	sendCustomizeLog(body)
 
 	// Remote log
-	if remoteRabbitMQLog != nil {
-		mq, err := getRabbitMQInstance()
-		if err != nil {
-			if caller.join != "" {
-				writeLocalLog(ErrorLevel, H{"msg": "Failed to create rabbitmq!", "err": err.Error()}, caller)
-			} else {
-				writeLocalLog(ErrorLevel, H{"msg": "Failed to create rabbitmq!", "err": err.Error()})
-			}
-			return
-		}
-
+	if transport := remoteLogFor(level); transport != nil {
 		if caller.join != "" {
 			body["caller"] = caller.join
 		}
 
-		if remoteTemplateHandler != nil {
-			body = remoteTemplateHandler.Before(body)
-		}
+		body = remoteLogBefore(level, body)
 
 		rest, err := json.Marshal(&body)
 		if err != nil {
@@ -452,24 +607,7 @@ func output(level LogLevel, v ...interface{}) {
 			}
 		}
 
-		message := string(rest)
-
-		if remoteRabbitMQLog.Simple {
-			err = mq.DefQueueDeclare(remoteRabbitMQLog.Key, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishSimple(message)
-		} else {
-			var key string
-			if remoteRabbitMQLog.Kind == KIND_DIRECT {
-				key = GetLevelStringByType(level)
-			} else {
-				key = remoteRabbitMQLog.Key
-			}
-			err = mq.DefExchangeDeclare(remoteRabbitMQLog.Exchange, remoteRabbitMQLog.Kind, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishRouting(message, key)
-		}
-
-		if err != nil {
-			if remoteTemplateHandler != nil {
-				remoteTemplateHandler.Error(err)
-			}
+		if err = publishRemoteLog(transport, level, rest); err != nil {
 			if caller.join != "" {
 				writeLocalLog(ErrorLevel, H{"msg": "Remote log sending failed!", "err": err.Error()}, caller)
 			} else {
@@ -495,33 +633,7 @@ func outputJSON(level LogLevel, s map[string]interface{}) {
 		}
 	}
 
-	if outConsole {
-		var levelInitial string
-		switch level {
-		case ErrorLevel:
-			levelInitial = "E"
-			color.Set(color.FgRed)
-		case WarnLevel:
-			levelInitial = "W"
-			color.Set(color.FgHiYellow)
-		case FatalLevel:
-			levelInitial = "F"
-			color.Set(color.FgHiRed)
-		case InfoLevel:
-			levelInitial = "I"
-			color.Set(color.BgHiMagenta)
-		case DebugLevel:
-			levelInitial = "D"
-			color.Set(color.BgGreen)
-		}
-		sprintf := fmt.Sprintf("[%s] ", levelInitial)
-		if isReportCaller {
-			sprintf += fmt.Sprintf("[%s] ", caller.join)
-		}
-		sprintf += fmt.Sprint(s)
-		fmt.Println(sprintf)
-		color.Unset()
-	}
+	printConsole(level, caller, s)
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -529,25 +641,15 @@ func outputJSON(level LogLevel, s map[string]interface{}) {
 		}
 	}()
 
-	if customizeLog != nil {
-		customizeLog <- s
-	}
+	sendCustomizeLog(s)
 
 	//remote log
-	if remoteRabbitMQLog != nil {
-		mq, err := getRabbitMQInstance()
-		if err != nil {
-			writeLocalLog(ErrorLevel, H{"msg": "Failed to create rabbitmq!", "err": err.Error()})
-			return
-		}
-
+	if transport := remoteLogFor(level); transport != nil {
 		if caller.join != "" {
 			s["caller"] = caller.join
 		}
 
-		if remoteTemplateHandler != nil {
-			s = remoteTemplateHandler.Before(s)
-		}
+		s = remoteLogBefore(level, s)
 
 		rest, err := json.Marshal(&s)
 		if err != nil {
@@ -558,24 +660,7 @@ func outputJSON(level LogLevel, s map[string]interface{}) {
 			}
 		}
 
-		message := string(rest)
-
-		if remoteRabbitMQLog.Simple {
-			err = mq.DefQueueDeclare(remoteRabbitMQLog.Key, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishSimple(message)
-		} else {
-			var key string
-			if remoteRabbitMQLog.Kind == KIND_DIRECT {
-				key = GetLevelStringByType(level)
-			} else {
-				key = remoteRabbitMQLog.Key
-			}
-			err = mq.DefExchangeDeclare(remoteRabbitMQLog.Exchange, remoteRabbitMQLog.Kind, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishRouting(message, key)
-		}
-
-		if err != nil {
-			if remoteTemplateHandler != nil {
-				remoteTemplateHandler.Error(err)
-			}
+		if err = publishRemoteLog(transport, level, rest); err != nil {
 			writeLocalLog(ErrorLevel, H{"msg": "Remote log sending failed!", "err": err.Error()})
 			return
 		}
@@ -620,70 +705,91 @@ func WarningJSON(h map[string]interface{}) {
 	outputJSON(ErrorLevel, h)
 }
 
+// Fatal log at fatal severity, the process is not terminated,
+// use SetFatalHandler to decide what happens after the log has been written.
 func Fatal(v ...interface{}) {
 	output(FatalLevel, v...)
+	callFatalHandler()
 }
 
 func FatalJSON(h map[string]interface{}) {
 	output(FatalLevel, h)
+	callFatalHandler()
 }
 
-func SetConsoleLogNoColor() {
-	color.NoColor = true
+// CtxLogger the log functions carrying the request id of a context, see LogCtx
+type CtxLogger struct {
+	ctx context.Context
 }
 
-func SetOutputToConsole(v bool) {
-	if globalLogLevel == DebugLevel {
-		outConsole = v
-	}
+// LogCtx log functions adding the request id of ctx(see RequestIDMiddleware and RequestIDServerInterceptor)
+// as the "request_id" field, when ctx carries a Trace the field is not added.
+//
+// example: fit.LogCtx(c).Info("msg", "user_id", 1)
+func LogCtx(ctx context.Context) CtxLogger {
+	return CtxLogger{ctx: ctx}
 }
 
-type remoteRabbit struct {
-	inst      *RabbitMQ
-	useTime   time.Time
-	createdAt int64
+func (l CtxLogger) Debug(v ...interface{}) {
+	outputCtx(l.ctx, DebugLevel, v...)
 }
 
-var _remoteRabbitInstance *remoteRabbit
+func (l CtxLogger) Info(v ...interface{}) {
+	outputCtx(l.ctx, InfoLevel, v...)
+}
 
-func SetRemoteRabbitMQLog(config *RemoteRabbitMQLog) {
-	_remoteRabbitInstance = &remoteRabbit{}
-	remoteRabbitMQLog = config
+func (l CtxLogger) Warning(v ...interface{}) {
+	outputCtx(l.ctx, WarnLevel, v...)
 }
 
-func getRabbitMQInstance() (*RabbitMQ, error) {
-	in := _remoteRabbitInstance
-	in.useTime = time.Now()
-	if in.inst == nil {
-		mq, err := NewRabbitMQ(remoteRabbitMQLog.RabbitMQUrl)
-		if err != nil {
-			writeLocalLog(ErrorLevel, H{"msg": "Failed to create rabbitmq!", "err": err.Error()})
-			return nil, err
-		}
-		in.inst = mq
-		in.createdAt = time.Now().Unix()
-		go in.upholdInstance()
-	}
-	return in.inst, nil
+func (l CtxLogger) Error(v ...interface{}) {
+	outputCtx(l.ctx, ErrorLevel, v...)
 }
 
-func (r *remoteRabbit) upholdInstance() {
-	for {
-		ct := time.Now().Unix()
-		if remoteRabbitMQLog.MaxConnAt > 0 && ct-r.createdAt > remoteRabbitMQLog.MaxConnAt {
-			r.inst.Close()
-			r.inst = nil
-			return
-		}
-		time.Sleep(time.Second * 2)
-		if ct-r.useTime.Unix() > 10 {
-			r.inst.Close()
-			r.inst = nil
-			return
-		}
+// Fatal see Fatal
+func (l CtxLogger) Fatal(v ...interface{}) {
+	outputCtx(l.ctx, FatalLevel, v...)
+	callFatalHandler()
+}
+
+var fatalHandler func()
+
+// SetFatalHandler set the function called after a fatal log has been written to all sinks,
+// for example to run the cleanup and call os.Exit.
+func SetFatalHandler(fn func()) {
+	fatalHandler = fn
+}
+
+// maximum time a fatal log waits for the asynchronous local logs to be written
+var fatalFlushTimeout = time.Second * 5
+
+// callFatalHandler flush the asynchronous local logs, so the entries queued before the fatal log are written
+// even when the handler exits the process, then call the handler if it is set
+func callFatalHandler() {
+	ctx, cancel := context.WithTimeout(context.Background(), fatalFlushTimeout)
+	defer cancel()
+	_ = FlushLogs(ctx)
+	if fatalHandler != nil {
+		fatalHandler()
 	}
 }
 
+func SetConsoleLogNoColor() {
+	color.NoColor = true
+}
+
+// SetOutputToConsole enable printing to the console, the messages at or above the log level(see SetLogLevel)
+// are printed, use SetConsoleLogLevel to make the console noisier or quieter than the file.
+func SetOutputToConsole(v bool) {
+	outConsole = v
+}
+
+// SetConsoleLogLevel set the level of the console output independently of SetLogLevel
+func SetConsoleLogLevel(level LogLevel) {
+	consoleLogLevel = level
+	consoleLevelSet = true
+}
+
 func GetLevelStringByType(level LogLevel) string {
 	switch level {
 	case ErrorLevel:
@@ -698,20 +804,56 @@ func GetLevelStringByType(level LogLevel) string {
 		return "panic"
 	case FatalLevel:
 		return "fatal"
+	case TranceInfoLevel:
+		return "trace"
 	}
 	return ""
 }
 
-func CustomizeLog() <-chan map[string]interface{} {
+// CustomizeLog receive a copy of every log entry, size is the buffer size of the channel, default 1024.
+// The entries are dropped when the buffer is full, see GetCustomizeLogDropped.
+func CustomizeLog(size ...int) <-chan map[string]interface{} {
+	customizeLogMux.Lock()
+	defer customizeLogMux.Unlock()
 	if customizeLog == nil {
-		customizeLog = make(chan map[string]interface{})
+		n := 1024
+		if len(size) > 0 && size[0] >= 0 {
+			n = size[0]
+		}
+		customizeLog = make(chan map[string]interface{}, n)
 	}
 	return customizeLog
 }
 
+// CloseCustomizeLog close the channel returned by CustomizeLog, safe to call concurrently with logging
 func CloseCustomizeLog() {
+	customizeLogMux.Lock()
+	defer customizeLogMux.Unlock()
 	if customizeLog != nil {
 		close(customizeLog)
+		customizeLog = nil
+	}
+}
+
+// GetCustomizeLogDropped number of entries dropped because the CustomizeLog buffer was full
+func GetCustomizeLogDropped() uint64 {
+	return atomic.LoadUint64(&customizeLogDropped)
+}
+
+func sendCustomizeLog(body map[string]interface{}) {
+	customizeLogMux.RLock()
+	defer customizeLogMux.RUnlock()
+	if customizeLog == nil {
+		return
+	}
+	entry := make(map[string]interface{}, len(body))
+	for k, v := range body {
+		entry[k] = v
+	}
+	select {
+	case customizeLog <- entry:
+	default:
+		atomic.AddUint64(&customizeLogDropped, 1)
 	}
 }
 
@@ -782,6 +924,14 @@ func (u *useOtherConfig) Info(v ...interface{}) {
 	u.output(InfoLevel, v...)
 }
 
+// TraceInfo write a trace(see LinkTrace), every destination receives the same JSON: {"trace": {...}, "caller": "..."}
+func (u *useOtherConfig) TraceInfo(v interface{}) {
+	u.output(TranceInfoLevel, v)
+}
+
+// TranceInfo alias of TraceInfo.
+//
+// Deprecated: use TraceInfo
 func (u *useOtherConfig) TranceInfo(v interface{}) {
 	u.output(TranceInfoLevel, v)
 }
@@ -796,10 +946,11 @@ func (u *useOtherConfig) Warning(v ...interface{}) {
 
 func (u *useOtherConfig) Fatal(v ...interface{}) {
 	u.output(FatalLevel, v...)
+	callFatalHandler()
 }
 
 func (u *useOtherConfig) output(level LogLevel, v ...interface{}) {
-	if u.console {
+	if u.console && level != TranceInfoLevel {
 		fmt.Println(v...)
 	}
 	defer func() {
@@ -822,30 +973,32 @@ func (u *useOtherConfig) output(level LogLevel, v ...interface{}) {
 		}
 	}
 
-	var body map[string]interface{}
 	if level == TranceInfoLevel {
 		if len(v) == 1 {
-			u.writeLocalLogTrance(v[0])
+			u.outputTrace(v[0], caller)
 		}
+		return
+	}
+
+	body := getBody(v...)
+	if caller.join != "" {
+		u.writeLocalLog(level, body, caller)
 	} else {
-		body = getBody(v...)
-		if caller.join != "" {
-			u.writeLocalLog(level, body, caller)
-		} else {
-			u.writeLocalLog(level, body)
-		}
+		u.writeLocalLog(level, body)
 	}
 
 	//remote log
-	if u.remote {
-		if body == nil {
-			return
+	if transport := u.remoteLogFor(level); transport != nil {
+		if caller.join != "" {
+			body["caller"] = caller.join
 		}
 
-		mq, err := getRabbitMQInstance()
+		body = remoteLogBefore(level, body)
+
+		str, err := json.Marshal(&body)
 		if err != nil {
-			by := H{"msg": "Failed to create rabbitmq!", "err": err.Error()}
-			if len(caller.join) > 0 {
+			by := H{"msg": "JSON serialization failed!", "err": err.Error()}
+			if caller.join != "" {
 				u.writeLocalLog(ErrorLevel, by, caller)
 			} else {
 				u.writeLocalLog(ErrorLevel, by)
@@ -853,89 +1006,86 @@ func (u *useOtherConfig) output(level LogLevel, v ...interface{}) {
 			return
 		}
 
-		if caller.join != "" {
-			body["caller"] = caller.join
-		}
+		u.publishRemote(transport, level, str, caller)
+	}
+}
+
+// outputTrace write the trace to every enabled destination with the same envelope: {"trace": {...}, "caller": "..."}
+func (u *useOtherConfig) outputTrace(v interface{}, caller reportCaller) {
+	envelope := H{"trace": v}
+	if caller.join != "" {
+		envelope["caller"] = caller.join
+	}
 
-		if remoteTemplateHandler != nil {
-			body = remoteTemplateHandler.Before(body)
+	if u.log != nil {
+		u.log.WithFields(logrus.Fields(envelope)).Info()
+	}
+
+	if u.console {
+		if text, ok := u.marshalTrace(envelope, caller); ok {
+			fmt.Println(string(text))
 		}
+	}
 
-		var message string
-		if level == TranceInfoLevel {
-			if len(v) == 1 {
-				text, err := json.Marshal(&H{"trace": v[0]})
-				if err != nil {
-					by := H{"msg": "[remote log]:json Marshal err", "err": err.Error()}
-					if caller.join != "" {
-						u.writeLocalLog(ErrorLevel, by, caller)
-					} else {
-						u.writeLocalLog(ErrorLevel, by)
-					}
-					return
-				}
-				message = string(text)
-			}
-		} else {
-			str, err := json.Marshal(&body)
-			if err != nil {
-				by := H{"msg": "Failed to create rabbitmq!", "err": err.Error()}
-				if caller.join != "" {
-					u.writeLocalLog(ErrorLevel, by, caller)
-				} else {
-					u.writeLocalLog(ErrorLevel, by)
-				}
-				return
-			}
-			message = string(str)
+	// like every remote entry the envelope goes through the remote log hooks(redaction, enrichment),
+	// they receive a copy so the local and console output are not affected
+	if transport := u.remoteLogFor(TranceInfoLevel); transport != nil {
+		body := remoteLogBefore(TranceInfoLevel, mapBody(envelope))
+		if text, ok := u.marshalTrace(body, caller); ok {
+			u.publishRemote(transport, TranceInfoLevel, text, caller)
 		}
+	}
+}
 
-		if remoteRabbitMQLog.Simple {
-			err = mq.DefQueueDeclare(remoteRabbitMQLog.Key, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishSimple(message)
+func (u *useOtherConfig) marshalTrace(body map[string]interface{}, caller reportCaller) ([]byte, bool) {
+	text, err := json.Marshal(&body)
+	if err != nil {
+		by := H{"msg": "[trace log]:json Marshal err", "err": err.Error()}
+		if caller.join != "" {
+			u.writeLocalLog(ErrorLevel, by, caller)
 		} else {
-			var key string
-			if remoteRabbitMQLog.Kind == KIND_DIRECT {
-				key = GetLevelStringByType(level)
-			} else {
-				key = remoteRabbitMQLog.Key
-			}
-			err = mq.DefExchangeDeclare(remoteRabbitMQLog.Exchange, remoteRabbitMQLog.Kind, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishRouting(message, key)
+			u.writeLocalLog(ErrorLevel, by)
 		}
+		return nil, false
+	}
+	return text, true
+}
 
-		if err != nil {
-			if remoteTemplateHandler != nil {
-				remoteTemplateHandler.Error(err)
-			}
-			by := H{"msg": "Remote log sending failed!", "err": err.Error()}
-			if caller.join != "" {
-				u.writeLocalLog(ErrorLevel, by, caller)
-			} else {
-				u.writeLocalLog(ErrorLevel, by)
-			}
+// remoteLogFor see remoteLogFor, nil without UseRemote
+func (u *useOtherConfig) remoteLogFor(level LogLevel) RemoteLogTransport {
+	if !u.remote {
+		return nil
+	}
+	return remoteLogFor(level)
+}
 
+func (u *useOtherConfig) publishRemote(transport RemoteLogTransport, level LogLevel, message []byte, caller reportCaller) {
+	if err := publishRemoteLog(transport, level, message); err != nil {
+		by := H{"msg": "Remote log sending failed!", "err": err.Error()}
+		if caller.join != "" {
+			u.writeLocalLog(ErrorLevel, by, caller)
+		} else {
+			u.writeLocalLog(ErrorLevel, by)
 		}
 	}
 }
 
-func (u *useOtherConfig) writeLocalLogTrance(v interface{}) {
+func (u *useOtherConfig) writeLocalLog(level LogLevel, body map[string]interface{}, rc ...reportCaller) {
 	if u.log == nil {
 		return
 	}
-	u.log.WithFields(logrus.Fields{"trace": v}).Info()
-}
 
-func (u *useOtherConfig) writeLocalLog(level LogLevel, body map[string]interface{}, rc ...reportCaller) {
+	// body is published to the remote log afterwards, the fields are copied instead of modified
 	var msg string
-	if val, ok := body["msg"]; ok {
-		if s, o := val.(string); o {
+	fields := make(map[string]interface{}, len(body)+1)
+	for k, v := range body {
+		if s, ok := v.(string); ok && k == "msg" {
 			msg = s
-			delete(body, "msg")
+			continue
 		}
+		fields[k] = v
 	}
-
-	if u.log == nil {
-		return
-	}
+	body = fields
 	if len(rc) > 0 {
 		body["caller"] = rc[0].join
 		entry := u.log.WithFields(body)
@@ -945,9 +1095,9 @@ func (u *useOtherConfig) writeLocalLog(level LogLevel, body map[string]interface
 		case WarnLevel:
 			entry.Warning(msg)
 		case FatalLevel:
-			entry.Fatal(msg)
+			entry.Log(logrus.FatalLevel, msg)
 		case DebugLevel:
-			entry.Fatal(msg)
+			entry.Debug(msg)
 		case InfoLevel:
 			entry.Info(msg)
 		}
@@ -961,16 +1111,86 @@ func (u *useOtherConfig) writeLocalLog(level LogLevel, body map[string]interface
 	case WarnLevel:
 		entry.Warning(msg)
 	case FatalLevel:
-		entry.Fatal(msg)
+		entry.Log(logrus.FatalLevel, msg)
 	case DebugLevel:
-		entry.Fatal(msg)
+		entry.Debug(msg)
 	case InfoLevel:
 		entry.Info(msg)
 	}
 }
 
+// AddRemoteLogHook add a hook called before a remote log is sent and when sending fails,
+// the hooks are called in the order they were added.
 func AddRemoteLogHook(r RemoteLogTemplater) {
-	remoteTemplateHandler = r
+	addRemoteLogHook(r, remoteLogTemplaterAdapter{r})
+}
+
+// AddRemoteLogLevelHook AddRemoteLogHook with a hook receiving the level of the log
+func AddRemoteLogLevelHook(r RemoteLogLevelTemplater) {
+	addRemoteLogHook(r, r)
+}
+
+func addRemoteLogHook(src interface{}, hook RemoteLogLevelTemplater) {
+	remoteLogHooksMux.Lock()
+	defer remoteLogHooksMux.Unlock()
+	remoteLogHooks = append(remoteLogHooks, remoteLogHook{src: src, hook: hook})
+}
+
+// RemoveRemoteLogHook remove a hook added by AddRemoteLogHook or AddRemoteLogLevelHook, the hook must be comparable(e.g. a pointer)
+func RemoveRemoteLogHook(r interface{}) {
+	if r == nil || !reflect.TypeOf(r).Comparable() {
+		return
+	}
+	remoteLogHooksMux.Lock()
+	defer remoteLogHooksMux.Unlock()
+	hooks := make([]remoteLogHook, 0, len(remoteLogHooks))
+	for _, h := range remoteLogHooks {
+		if reflect.TypeOf(h.src).Comparable() && h.src == r {
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	remoteLogHooks = hooks
+}
+
+func getRemoteLogHooks() []remoteLogHook {
+	remoteLogHooksMux.RLock()
+	defer remoteLogHooksMux.RUnlock()
+	return remoteLogHooks
+}
+
+// remoteLogBefore pass body through the Before of every hook, a panicking hook is logged locally and skipped
+func remoteLogBefore(level LogLevel, body map[string]interface{}) map[string]interface{} {
+	for _, h := range getRemoteLogHooks() {
+		if b := callRemoteLogBefore(h.hook, level, body); b != nil {
+			body = b
+		}
+	}
+	return body
+}
+
+func callRemoteLogBefore(hook RemoteLogLevelTemplater, level LogLevel, body map[string]interface{}) (b map[string]interface{}) {
+	defer func() {
+		if err := recover(); err != nil {
+			writeLocalLog(ErrorLevel, H{"msg": "remote log hook Before panic", "err": err})
+			b = nil
+		}
+	}()
+	return hook.Before(level, body)
+}
+
+// remoteLogError call the Error of every hook, a panicking hook is logged locally and skipped
+func remoteLogError(level LogLevel, err error) {
+	for _, h := range getRemoteLogHooks() {
+		func() {
+			defer func() {
+				if e := recover(); e != nil {
+					writeLocalLog(ErrorLevel, H{"msg": "remote log hook Error panic", "err": e})
+				}
+			}()
+			h.hook.Error(level, err)
+		}()
+	}
 }
 
 func SetLogLevel(level LogLevel) {
@@ -981,7 +1201,12 @@ func SetLocalLogConfig(entity ...LogEntity) {
 	if len(entity) == 1 {
 		defLog = entity[0].FileName
 	}
+	// the previous writers are stopped once the new loggers are in place
+	old := swapAsyncLogWriters()
+	defer stopAsyncLogWriters(old)
+	resetLogFileWriters()
 	logs = make(map[string]*logrus.Logger)
+	logRouters = make(map[string]*levelRouter)
 	for _, k := range entity {
 		if _, ok := logs[k.FileName]; ok {
 			continue
@@ -990,29 +1215,69 @@ func SetLocalLogConfig(entity ...LogEntity) {
 		if k.IsDefaultLog {
 			defLog = k.FileName
 		}
-		l := logrus.New()
-		l.SetOutput(&lumberjack.Logger{
-			Filename:   StringSpliceTag("/", k.LogPath, k.FileName+".log"),
-			MaxSize:    k.FileMaxSize,
-			MaxBackups: k.MaxBackups,
-			MaxAge:     k.MaxAge,
-			Compress:   k.Compress,
-		})
-		if k.Formatter == TextFormatter {
-			l.SetFormatter(&logrus.TextFormatter{
-				TimestampFormat: "2006-01-02 15:04:05",
-			})
-		} else {
-			l.SetFormatter(&logrus.JSONFormatter{
-				TimestampFormat: "2006-01-02 15:04:05",
-			})
+		logs[k.FileName] = newLogger(k, k.FileName)
+		if len(k.LevelRouting) > 0 {
+			logRouters[k.FileName] = &levelRouter{entity: k, loggers: make(map[string]*logrus.Logger)}
 		}
-		l.SetLevel(logrus.Level(globalLogLevel))
-		logs[k.FileName] = l
 		isReportCaller = !k.ReportCaller
 	}
 }
 
+func newLogger(k LogEntity, fileName string) *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(logFileWriter(k, fileName, l))
+	if k.Formatter == TextFormatter {
+		l.SetFormatter(&logrus.TextFormatter{
+			TimestampFormat: string(DateTime),
+		})
+	} else {
+		l.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: string(DateTime),
+		})
+	}
+	l.SetLevel(logrus.Level(globalLogLevel))
+	return l
+}
+
+// logFileWriters the writer of every log file keyed by path, the loggers of the same file(e.g. the levels
+// of several entities routed to it) share it so that the file is rotated by a single lumberjack
+var (
+	logFileWriters    = make(map[string]io.Writer)
+	logFileWritersMux sync.Mutex
+)
+
+// logFileWriter the writer of the file fileName, created with the settings of k on first use, l reports its dropped entries
+func logFileWriter(k LogEntity, fileName string, l *logrus.Logger) io.Writer {
+	path := StringSpliceTag("/", k.LogPath, fileName+".log")
+	logFileWritersMux.Lock()
+	defer logFileWritersMux.Unlock()
+	if out, ok := logFileWriters[path]; ok {
+		return out
+	}
+
+	var out io.Writer = &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    k.FileMaxSize,
+		MaxBackups: k.MaxBackups,
+		MaxAge:     k.MaxAge,
+		Compress:   k.Compress,
+	}
+	if k.Async {
+		w := newAsyncLogWriter(out, k.QueueSize, k.OverflowPolicy)
+		w.logger = l
+		addAsyncLogWriter(fileName, w)
+		out = w
+	}
+	logFileWriters[path] = out
+	return out
+}
+
+func resetLogFileWriters() {
+	logFileWritersMux.Lock()
+	defer logFileWritersMux.Unlock()
+	logFileWriters = make(map[string]io.Writer)
+}
+
 func SetLogStackLength(len int) {
 	if len <= 0 {
 		return
@@ -1036,11 +1301,15 @@ func defaultConfig(entity *LogEntity) *LogEntity {
 	if entity.FileMaxSize == 0 {
 		entity.FileMaxSize = 5
 	}
+	if entity.QueueSize <= 0 {
+		entity.QueueSize = 4096
+	}
 	return entity
 }
 
 func RemoteLog(t LogLevel, v ...interface{}) {
-	if remoteRabbitMQLog == nil || len(v) == 0 {
+	transport := remoteLogFor(t)
+	if transport == nil || len(v) == 0 {
 		return
 	}
 
@@ -1054,23 +1323,11 @@ func RemoteLog(t LogLevel, v ...interface{}) {
 
 	body := getBody(v...)
 
-	mq, err := getRabbitMQInstance()
-	if err != nil {
-		if caller.join != "" {
-			writeLocalLog(t, H{"msg": "connect rabbitMQ error", "err": err.Error()}, caller)
-		} else {
-			writeLocalLog(t, H{"msg": "connect rabbitMQ error", "err": err.Error()})
-		}
-		return
-	}
-
 	if caller.join != "" {
 		body["caller"] = caller.join
 	}
 
-	if remoteTemplateHandler != nil {
-		body = remoteTemplateHandler.Before(body)
-	}
+	body = remoteLogBefore(t, body)
 
 	rest, err := json.Marshal(&body)
 	if err != nil {
@@ -1081,23 +1338,7 @@ func RemoteLog(t LogLevel, v ...interface{}) {
 		}
 	}
 
-	message := string(rest)
-
-	if remoteRabbitMQLog.Simple {
-		err = mq.DefQueueDeclare(remoteRabbitMQLog.Key, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishSimple(message)
-	} else {
-		var key string
-		if remoteRabbitMQLog.Kind == KIND_DIRECT {
-			key = GetLevelStringByType(t)
-		} else {
-			key = remoteRabbitMQLog.Key
-		}
-		err = mq.DefExchangeDeclare(remoteRabbitMQLog.Exchange, remoteRabbitMQLog.Kind, remoteRabbitMQLog.Durable, remoteRabbitMQLog.AutoDel).PublishRouting(message, key)
-	}
-	if err != nil {
-		if remoteTemplateHandler != nil {
-			remoteTemplateHandler.Error(err)
-		}
+	if err = publishRemoteLog(transport, t, rest); err != nil {
 		if caller.join != "" {
 			writeLocalLog(t, H{"msg": "Remote log sending failed!", "err": err.Error()}, caller)
 		} else {
@@ -0,0 +1,213 @@
+package fit
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// OverflowPolicyDrop discard the entry when the queue is full
+	OverflowPolicyDrop = iota
+	// OverflowPolicyBlock wait until the queue has space
+	OverflowPolicyBlock
+)
+
+// interval of reporting the number of dropped entries
+var droppedReportInterval = time.Second * 10
+
+var asyncLogWriters = make(map[string]*asyncLogWriter)
+var asyncLogWritersMux sync.Mutex
+
+// asyncLogWriter queue the formatted entries and write them to the file in a dedicated goroutine.
+type asyncLogWriter struct {
+	out      io.Writer
+	logger   *logrus.Logger
+	policy   int
+	queue    chan []byte
+	flush    chan chan struct{}
+	quit     chan struct{}
+	stopped  chan struct{}
+	dropped  uint64
+	reported uint64
+
+	// mux guards closed, Write holds it while queueing so that no entry is queued once stopped
+	mux    sync.RWMutex
+	closed bool
+}
+
+func newAsyncLogWriter(out io.Writer, queueSize, policy int) *asyncLogWriter {
+	w := &asyncLogWriter{
+		out:     out,
+		policy:  policy,
+		queue:   make(chan []byte, queueSize),
+		flush:   make(chan chan struct{}),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *asyncLogWriter) Write(p []byte) (int, error) {
+	// logrus reuses the buffer after writing
+	b := make([]byte, len(p))
+	copy(b, p)
+
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if w.closed {
+		// stopped(e.g. SetLocalLogConfig) while the logger is still in use
+		return w.out.Write(b)
+	}
+	if w.policy == OverflowPolicyBlock {
+		// the queue is consumed until stop, which waits for this Write
+		w.queue <- b
+		return len(p), nil
+	}
+
+	select {
+	case w.queue <- b:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+	return len(p), nil
+}
+
+func (w *asyncLogWriter) run() {
+	defer close(w.stopped)
+	t := time.NewTicker(droppedReportInterval)
+	defer t.Stop()
+	for {
+		select {
+		case b := <-w.queue:
+			_, _ = w.out.Write(b)
+		case done := <-w.flush:
+			w.drain()
+			close(done)
+		case <-t.C:
+			w.reportDropped()
+		case <-w.quit:
+			w.drain()
+			return
+		}
+	}
+}
+
+func (w *asyncLogWriter) drain() {
+	for {
+		select {
+		case b := <-w.queue:
+			_, _ = w.out.Write(b)
+		default:
+			return
+		}
+	}
+}
+
+func (w *asyncLogWriter) reportDropped() {
+	dropped := atomic.LoadUint64(&w.dropped)
+	n := dropped - w.reported
+	if n == 0 || w.logger == nil {
+		return
+	}
+	w.reported = dropped
+	w.logger.WithFields(logrus.Fields{"dropped": n, "total_dropped": dropped}).Warning("async log queue is full, entries have been dropped")
+}
+
+// Flush wait until the entries queued before the call have been written.
+func (w *asyncLogWriter) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case w.flush <- done:
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop write the queued entries and stop the goroutine, the later entries are written synchronously
+func (w *asyncLogWriter) stop() {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return
+	}
+	w.closed = true
+	w.mux.Unlock()
+	close(w.quit)
+	<-w.stopped
+}
+
+// FlushLogs drain the queues of the asynchronous local logs, usually called on shutdown.
+func FlushLogs(ctx context.Context) error {
+	asyncLogWritersMux.Lock()
+	writers := make([]*asyncLogWriter, 0, len(asyncLogWriters))
+	for _, w := range asyncLogWriters {
+		writers = append(writers, w)
+	}
+	asyncLogWritersMux.Unlock()
+
+	for _, w := range writers {
+		if err := w.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLogDroppedCount number of entries dropped by the asynchronous local log of the given name.
+func GetLogDroppedCount(name string) uint64 {
+	asyncLogWritersMux.Lock()
+	defer asyncLogWritersMux.Unlock()
+	w, ok := asyncLogWriters[name]
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(&w.dropped)
+}
+
+func resetAsyncLogWriters() {
+	stopAsyncLogWriters(swapAsyncLogWriters())
+}
+
+// swapAsyncLogWriters replace the registered writers, they keep running until stopAsyncLogWriters
+func swapAsyncLogWriters() map[string]*asyncLogWriter {
+	asyncLogWritersMux.Lock()
+	defer asyncLogWritersMux.Unlock()
+	old := asyncLogWriters
+	asyncLogWriters = make(map[string]*asyncLogWriter)
+	return old
+}
+
+func stopAsyncLogWriters(writers map[string]*asyncLogWriter) {
+	for _, w := range writers {
+		w.stop()
+	}
+}
+
+// addAsyncLogWriter register w to be flushed and stopped, when another file of the same name(in another LogPath)
+// is registered already, w is registered under a unique name and GetLogDroppedCount reports the first one
+func addAsyncLogWriter(name string, w *asyncLogWriter) {
+	asyncLogWritersMux.Lock()
+	defer asyncLogWritersMux.Unlock()
+	key := name
+	for i := 1; ; i++ {
+		if old, ok := asyncLogWriters[key]; !ok || old == w {
+			break
+		}
+		key = name + "#" + strconv.Itoa(i)
+	}
+	asyncLogWriters[key] = w
+}
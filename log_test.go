@@ -0,0 +1,518 @@
+package fit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// slowWriter io.Writer taking a while for every write, like a busy disk
+type slowWriter struct {
+	mux   sync.Mutex
+	delay time.Duration
+	buf   bytes.Buffer
+}
+
+func (s *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *slowWriter) String() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.buf.String()
+}
+
+func TestFatalFlushesAsyncLogs(t *testing.T) {
+	useBufferLogger(t, "fatal_test")
+	oldDefLog := defLog
+	defLog = "fatal_test"
+	t.Cleanup(func() { defLog = oldDefLog })
+	remote := useMemoryTransport(t)
+
+	fatal := map[string]func(msg string){
+		"Fatal":     func(msg string) { Fatal(msg) },
+		"FatalJSON": func(msg string) { FatalJSON(H{"msg": msg}) },
+		"LocalLog":  func(msg string) { LocalLog("fatal_test").Fatal(msg) },
+		"OtherLog":  func(msg string) { OtherLog("fatal_test", UseLocal(), UseRemote()).Fatal(msg) },
+	}
+	for name, fn := range fatal {
+		// the local log file behind a slow asynchronous writer
+		out := &slowWriter{delay: time.Millisecond * 20}
+		w := newAsyncLogWriter(out, 16, OverflowPolicyDrop)
+		addAsyncLogWriter("fatal_test", w)
+		logs["fatal_test"].SetOutput(w)
+		for i := 0; i < 5; i++ {
+			_, _ = w.Write([]byte("queued\n"))
+		}
+
+		// the process is not terminated, the handler sees what was written before it is called
+		var local string
+		var published [][]byte
+		SetFatalHandler(func() {
+			local = out.String()
+			published = remote.published()
+		})
+		msg := "fatal from " + name
+		fn(msg)
+		SetFatalHandler(nil)
+		resetAsyncLogWriters()
+
+		if n := strings.Count(local, "queued"); n != 5 {
+			t.Errorf("%s: %d of 5 queued entries written before the fatal handler", name, n)
+		}
+		if !strings.Contains(local, msg) {
+			t.Errorf("%s: fatal entry not in the local log: %q", name, local)
+		}
+		// LocalLog only writes the local log
+		var found bool
+		for _, p := range published {
+			found = found || strings.Contains(string(p), msg)
+		}
+		if want := name != "LocalLog"; found != want {
+			t.Errorf("%s: fatal entry published = %v, want %v", name, found, want)
+		}
+	}
+}
+
+func TestAsyncLogWriteAfterStop(t *testing.T) {
+	for _, policy := range []int{OverflowPolicyDrop, OverflowPolicyBlock} {
+		out := &slowWriter{delay: time.Microsecond * 100}
+		w := newAsyncLogWriter(out, 8, policy)
+
+		const goroutines, writes = 8, 50
+		var wg sync.WaitGroup
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < writes; j++ {
+					_, _ = w.Write([]byte("entry\n"))
+				}
+			}()
+		}
+		// stopped while the loggers are still writing
+		time.Sleep(time.Millisecond * 5)
+		w.stop()
+		wg.Wait()
+		w.stop()
+
+		_, _ = w.Write([]byte("entry\n"))
+		written := uint64(strings.Count(out.String(), "entry"))
+		dropped := atomic.LoadUint64(&w.dropped)
+		if written+dropped != goroutines*writes+1 {
+			t.Errorf("policy %d: written %d + dropped %d, want %d: entries were lost", policy, written, dropped, goroutines*writes+1)
+		}
+		if policy == OverflowPolicyBlock && dropped != 0 {
+			t.Errorf("policy %d: dropped = %d", policy, dropped)
+		}
+	}
+}
+
+type logTestErr struct{ msg string }
+
+func (e logTestErr) Error() string { return e.msg }
+
+func TestGetBody(t *testing.T) {
+	wrapped := fmt.Errorf("query user: %w", logTestErr{msg: "connection refused"})
+	long := errors.New(strings.Repeat("x", stackLength+10))
+
+	tests := []struct {
+		name      string
+		expansion bool
+		args      []interface{}
+		want      map[string]interface{}
+	}{
+		{"string", false, []interface{}{"hello"}, H{"msg": "hello"}},
+		{"number", false, []interface{}{42}, H{"msg": "42"}},
+		{"struct", false, []interface{}{struct{ A int }{1}}, H{"msg": "{1}"}},
+		{"nil", false, []interface{}{nil}, H{"msg": "<nil>"}},
+		{"error", false, []interface{}{wrapped}, H{"msg": "An error has occurred", "err": "query user: connection refused"}},
+		{"map", false, []interface{}{map[string]interface{}{"a": 1, "err": wrapped}}, H{"a": 1, "err": "query user: connection refused"}},
+		{"H", false, []interface{}{H{"a": "b"}}, H{"a": "b"}},
+		{"Fields", false, []interface{}{Fields{"a": "b"}}, H{"a": "b"}},
+		{"pairs", false, []interface{}{"msg", "hi", "user", 7}, H{"msg": "hi", "user": 7}},
+		{"odd length", false, []interface{}{"msg", "hi", "dangling"}, H{"msg": "hi", "arg_2": "dangling"}},
+		{"non-string key", false, []interface{}{"msg", "hi", 1, "one"}, H{"msg": "hi", "1": "one"}},
+		{"truncated stack", false, []interface{}{"err", long}, H{"err": strings.Repeat("x", stackLength) + "…(truncated, 10 more chars)"}},
+		{"error chain", true, []interface{}{"err", wrapped}, H{"err_chain": []map[string]interface{}{
+			{"type": "*fmt.wrapError", "msg": "query user: connection refused"},
+			{"type": "fit.logTestErr", "msg": "connection refused"},
+		}}},
+		{"single error chain", true, []interface{}{wrapped}, H{"msg": "An error has occurred", "err_chain": []map[string]interface{}{
+			{"type": "*fmt.wrapError", "msg": "query user: connection refused"},
+			{"type": "fit.logTestErr", "msg": "connection refused"},
+		}}},
+	}
+
+	defer SetErrorExpansion(false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetErrorExpansion(tt.expansion)
+			got := getBody(tt.args...)
+			if !reflect.DeepEqual(got, map[string]interface{}(tt.want)) {
+				t.Errorf("getBody(%v) = %#v, want %#v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// useBufferLogger register a JSON logger writing to a buffer as the local log name until the end of the test
+func useBufferLogger(t *testing.T, name string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	old := logs
+	logs = map[string]*logrus.Logger{name: l}
+	t.Cleanup(func() { logs = old })
+	return &buf
+}
+
+// captureStdout the output printed to stdout by fn
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	fn()
+	_ = w.Close()
+	os.Stdout = stdout
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+// tagHook RemoteLogLevelTemplater adding the tag field and recording the levels
+type tagHook struct {
+	tag    string
+	mux    sync.Mutex
+	levels []LogLevel
+	errs   []LogLevel
+}
+
+func (h *tagHook) Before(level LogLevel, body map[string]interface{}) map[string]interface{} {
+	h.mux.Lock()
+	h.levels = append(h.levels, level)
+	h.mux.Unlock()
+	order, _ := body["order"].(string)
+	body["order"] = order + h.tag
+	return body
+}
+
+func (h *tagHook) Error(level LogLevel, _ error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.errs = append(h.errs, level)
+}
+
+func TestTraceInfoEnvelope(t *testing.T) {
+	buf := useBufferLogger(t, "trace_test")
+	remote := useMemoryTransport(t)
+	hook := &tagHook{tag: "r"}
+	AddRemoteLogLevelHook(hook)
+	t.Cleanup(func() { RemoveRemoteLogHook(hook) })
+
+	trace := &Trace{TraceId: "trace-1", Error: errors.New("boom")}
+	OtherLog("trace_test", UseLocal()).TraceInfo(trace)
+	OtherLog("trace_test", UseRemote()).TraceInfo(trace)
+	console := captureStdout(t, func() {
+		OtherLog("trace_test", UseConsole()).TraceInfo(trace)
+	})
+
+	published := remote.published()
+	if len(published) != 1 {
+		t.Fatalf("remote messages = %d, want 1", len(published))
+	}
+	outputs := map[string]string{"local": buf.String(), "remote": string(published[0]), "console": console}
+	for mode, out := range outputs {
+		var envelope map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &envelope); err != nil {
+			t.Fatalf("%s: %v: %s", mode, err, out)
+		}
+		tr, ok := envelope["trace"].(map[string]interface{})
+		if !ok || tr["trace_id"] != "trace-1" || tr["error"] != "boom" {
+			t.Errorf("%s: trace = %v", mode, envelope["trace"])
+		}
+		if caller, _ := envelope["caller"].(string); !strings.HasPrefix(caller, "log_test.go:") {
+			t.Errorf("%s: caller = %v", mode, envelope["caller"])
+		}
+		// only the remote entry goes through the remote log hooks
+		if got, want := envelope["order"] != nil, mode == "remote"; got != want {
+			t.Errorf("%s: hook applied = %v, want %v", mode, got, want)
+		}
+	}
+	if len(hook.levels) != 1 || hook.levels[0] != TranceInfoLevel {
+		t.Errorf("hook levels = %v", hook.levels)
+	}
+}
+
+func TestTraceInfoWithoutRemote(t *testing.T) {
+	CloseRemoteLog()
+	buf := useBufferLogger(t, "trace_test")
+	oldDef := defLog
+	defLog = "trace_test"
+	t.Cleanup(func() { defLog = oldDef })
+
+	// no backend configured: nothing is sent and no error is logged
+	OtherLog("trace_test", UseRemote()).TraceInfo(&Trace{TraceId: "trace-1"})
+	if buf.Len() != 0 {
+		t.Errorf("unexpected log: %s", buf.String())
+	}
+}
+
+func TestCustomizeLogStalledConsumer(t *testing.T) {
+	ch := CustomizeLog(2)
+	t.Cleanup(CloseCustomizeLog)
+	dropped := GetCustomizeLogDropped()
+
+	// nobody reads the channel: logging must not block
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Error("msg", "first")
+		ErrorJSON(H{"msg": "second"})
+		Error("msg", "third")
+		ErrorJSON(H{"msg": "fourth"})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("logging blocked on a stalled CustomizeLog consumer")
+	}
+
+	if n := GetCustomizeLogDropped() - dropped; n != 2 {
+		t.Errorf("dropped = %d, want 2", n)
+	}
+	for _, want := range []string{"first", "second"} {
+		if entry := <-ch; entry["msg"] != want {
+			t.Errorf("entry = %v, want msg %q", entry, want)
+		}
+	}
+}
+
+func TestCustomizeLogWriteAfterClose(t *testing.T) {
+	ch := CustomizeLog(1)
+	CloseCustomizeLog()
+	dropped := GetCustomizeLogDropped()
+
+	Error("msg", "after close")
+	ErrorJSON(H{"msg": "after close"})
+
+	if _, ok := <-ch; ok {
+		t.Error("entry sent on a closed CustomizeLog")
+	}
+	if n := GetCustomizeLogDropped() - dropped; n != 0 {
+		t.Errorf("dropped = %d, want 0 once closed", n)
+	}
+	// closing again is a no-op
+	CloseCustomizeLog()
+}
+
+func TestCustomizeLogCloseDuringSend(t *testing.T) {
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if i%2 == 0 {
+						Error("msg", "concurrent")
+					} else {
+						ErrorJSON(H{"msg": "concurrent"})
+					}
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < 200; i++ {
+		ch := CustomizeLog(4)
+		go func() {
+			for range ch {
+			}
+		}()
+		CloseCustomizeLog()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestConsoleLogLevelIndependentOfFile(t *testing.T) {
+	buf := useBufferLogger(t, "console_test")
+	oldDef, oldLevel, oldConsole := defLog, globalLogLevel, outConsole
+	defLog = "console_test"
+	t.Cleanup(func() {
+		defLog, globalLogLevel, outConsole = oldDef, oldLevel, oldConsole
+		consoleLevelSet = false
+	})
+	SetOutputToConsole(true)
+
+	logAll := func() string {
+		buf.Reset()
+		return captureStdout(t, func() {
+			Debug("msg", "debug entry")
+			Info("msg", "info entry")
+			Warning("msg", "warning entry")
+			Error("msg", "error entry")
+		})
+	}
+	all := []string{"debug", "info", "warning", "error"}
+	tests := []struct {
+		name           string
+		file, console  LogLevel
+		setConsole     bool
+		inFile, onTerm []string
+	}{
+		{"console follows the file level by default", WarnLevel, 0, false, all[2:], all[2:]},
+		{"console noisier than the file", WarnLevel, DebugLevel, true, all[2:], all},
+		{"console quieter than the file", DebugLevel, ErrorLevel, true, all, all[3:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consoleLevelSet = false
+			SetLogLevel(tt.file)
+			logs["console_test"].SetLevel(logrus.Level(tt.file))
+			if tt.setConsole {
+				SetConsoleLogLevel(tt.console)
+			}
+
+			console := logAll()
+			file := buf.String()
+			for _, level := range all {
+				entry := level + " entry"
+				if got, want := strings.Contains(file, entry), contains(tt.inFile, level); got != want {
+					t.Errorf("%s in the file = %v, want %v", level, got, want)
+				}
+				if got, want := strings.Contains(console, entry), contains(tt.onTerm, level); got != want {
+					t.Errorf("%s on the console = %v, want %v", level, got, want)
+				}
+			}
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogCtxRequestID(t *testing.T) {
+	buf := useBufferLogger(t, "ctx_test")
+	oldDefLog := defLog
+	defLog = "ctx_test"
+	t.Cleanup(func() { defLog = oldDefLog })
+	remote := useMemoryTransport(t)
+	oldCaller := isReportCaller
+	isReportCaller = true
+	t.Cleanup(func() { isReportCaller = oldCaller })
+
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want interface{}
+	}{
+		{"request id", ctx, "req-1"},
+		// the trace id identifies the request
+		{"trace", ContextWithTrace(ctx, &Trace{TraceId: "trace-1"}), nil},
+		{"none", context.Background(), nil},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		LogCtx(tt.ctx).Error("msg", tt.name, "user_id", 1)
+
+		var local map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &local); err != nil {
+			t.Fatalf("%s: %v: %s", tt.name, err, buf.String())
+		}
+		published := remote.published()
+		var rem map[string]interface{}
+		if err := json.Unmarshal(published[len(published)-1], &rem); err != nil {
+			t.Fatal(err)
+		}
+		for sink, body := range map[string]map[string]interface{}{"local": local, "remote": rem} {
+			if body["request_id"] != tt.want || body["msg"] != tt.name || body["user_id"] != float64(1) {
+				t.Errorf("%s: %s body = %v, want request_id %v", tt.name, sink, body, tt.want)
+			}
+			if caller, _ := body["caller"].(string); !strings.HasPrefix(caller, "log_test.go:") {
+				t.Errorf("%s: %s caller = %v", tt.name, sink, body["caller"])
+			}
+		}
+	}
+}
+
+func TestLogRoutingSharesFileWriter(t *testing.T) {
+	oldLogs, oldRouters, oldDefLog, oldCaller := logs, logRouters, defLog, isReportCaller
+	t.Cleanup(func() {
+		resetAsyncLogWriters()
+		resetLogFileWriters()
+		logs, logRouters, defLog, isReportCaller = oldLogs, oldRouters, oldDefLog, oldCaller
+	})
+
+	dir := t.TempDir()
+	routing := map[LogLevel]string{ErrorLevel: "shared", WarnLevel: "shared"}
+	SetLocalLogConfig(
+		LogEntity{FileName: "a", LogPath: dir, Async: true, LevelRouting: routing},
+		LogEntity{FileName: "b", LogPath: dir, Async: true, LevelRouting: routing},
+		LogEntity{FileName: "shared", LogPath: dir, Async: true},
+	)
+
+	out := logs["shared"].Out
+	for _, name := range []string{"a", "b"} {
+		for _, level := range []LogLevel{ErrorLevel, WarnLevel} {
+			if l := routeLogger(name, logs[name], level); l.Out != out {
+				t.Errorf("%s level %d: a second writer of shared.log", name, level)
+			}
+		}
+	}
+	asyncLogWritersMux.Lock()
+	n := len(asyncLogWriters)
+	asyncLogWritersMux.Unlock()
+	if n != 3 {
+		t.Errorf("%d async writers registered, want one per file", n)
+	}
+
+	LocalLog("a").Error("from a")
+	LocalLog("b").Warning("from b")
+	LocalLog("shared").Error("from shared")
+	if err := FlushLogs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(dir + "/shared.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"from a", "from b", "from shared"} {
+		if !strings.Contains(string(data), msg) {
+			t.Errorf("shared.log misses %q: %s", msg, data)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"github.com/nsqio/go-nsq"
+	"github.com/segmentio/kafka-go"
+	"sync"
+	"time"
+)
+
+// RemoteLogTransport backend the remote logs are sent to
+type RemoteLogTransport interface {
+	Publish(level LogLevel, body []byte) error
+	Close() error
+}
+
+var (
+	remoteLogTransportMux sync.RWMutex
+	remoteLogTransport    RemoteLogTransport
+)
+
+// SetRemoteLogTransport set the backend of the remote logs, the previous one is closed.
+// The RemoteLogTemplater hooks are called before Publish regardless of the backend.
+func SetRemoteLogTransport(t RemoteLogTransport) {
+	remoteLogTransportMux.Lock()
+	old := remoteLogTransport
+	remoteLogTransport = t
+	remoteLogTransportMux.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+}
+
+func getRemoteLogTransport() RemoteLogTransport {
+	remoteLogTransportMux.RLock()
+	defer remoteLogTransportMux.RUnlock()
+	return remoteLogTransport
+}
+
+var remoteLogMinLevel = WarnLevel
+
+// SetRemoteLogMinLevel entries below the level are only written locally, default WarnLevel.
+// The trace entries(TranceInfoLevel) are not filtered.
+func SetRemoteLogMinLevel(level LogLevel) {
+	remoteLogMinLevel = level
+}
+
+type remoteLogLeveler interface {
+	minLevel() (LogLevel, bool)
+}
+
+// remoteLogFor the backend the entries of level are published to, nil when they are not sent remotely.
+// A log call takes the backend once and publishes to it, when it is replaced meanwhile the publish fails
+// with the error of the closed backend.
+func remoteLogFor(level LogLevel) RemoteLogTransport {
+	t := getRemoteLogTransport()
+	if t == nil {
+		return nil
+	}
+	if level == TranceInfoLevel {
+		return t
+	}
+	min := remoteLogMinLevel
+	if l, ok := t.(remoteLogLeveler); ok {
+		if v, ok := l.minLevel(); ok {
+			min = v
+		}
+	}
+	if level > min {
+		return nil
+	}
+	return t
+}
+
+// CloseRemoteLog close the backend of the remote logs, e.g. the rabbitMQ connection and its janitor goroutine
+func CloseRemoteLog() {
+	SetRemoteLogTransport(nil)
+}
+
+func publishRemoteLog(t RemoteLogTransport, level LogLevel, body []byte) error {
+	err := t.Publish(level, body)
+	if err != nil {
+		remoteLogError(level, err)
+	}
+	return err
+}
+
+/* rabbitMQ */
+
+type rabbitMQLogTransport struct {
+	config *RemoteRabbitMQLog
+	// mux guards the state below, it is never held during network I/O,
+	// so a slow publish does not block the janitor nor RabbitMQLogHealthCheck
+	mux     sync.Mutex
+	inst    *rabbitMQLogConn
+	useTime time.Time
+	janitor bool
+	closed  bool
+	stop    chan struct{}
+
+	// dial, now and tick are replaced in tests
+	dial func(url string) (rabbitMQLogClient, error)
+	now  func() time.Time
+	tick time.Duration
+}
+
+// rabbitMQLogClient the connection used by rabbitMQLogTransport
+type rabbitMQLogClient interface {
+	publish(config *RemoteRabbitMQLog, level LogLevel, body []byte) error
+	// broken whether the connection cannot be used anymore, e.g. a failed declaration closed the channel
+	broken() bool
+	isClosed() bool
+	close()
+}
+
+// rabbitMQLogConn a connection and the publishes using it. Once retired(idle, expired, broken or the transport closed)
+// no new publish uses it and it is closed when the last publish in progress returns.
+type rabbitMQLogConn struct {
+	mq rabbitMQLogClient
+	// pub serializes the publishes, the instance is not safe for concurrent use
+	pub       sync.Mutex
+	createdAt time.Time
+	refs      int
+	retired   bool
+	closed    bool
+}
+
+// SetRemoteRabbitMQLog send the remote logs to rabbitMQ,
+// with KIND_DIRECT the level name(see GetLevelStringByType) is used as routing key.
+func SetRemoteRabbitMQLog(config *RemoteRabbitMQLog) {
+	SetRemoteLogTransport(newRabbitMQLogTransport(config))
+}
+
+func newRabbitMQLogTransport(config *RemoteRabbitMQLog) *rabbitMQLogTransport {
+	return &rabbitMQLogTransport{
+		config: config,
+		stop:   make(chan struct{}),
+		dial:   dialRabbitMQLog,
+		now:    time.Now,
+		tick:   time.Second * 2,
+	}
+}
+
+func (r *rabbitMQLogTransport) Publish(level LogLevel, body []byte) error {
+	r.mux.Lock()
+	c, err := r.instance()
+	if err != nil {
+		r.mux.Unlock()
+		return err
+	}
+	c.refs++
+	r.mux.Unlock()
+
+	c.pub.Lock()
+	err = c.mq.publish(r.config, level, body)
+	broken := c.mq.broken()
+	c.pub.Unlock()
+
+	r.mux.Lock()
+	c.refs--
+	if broken {
+		// a failed declaration closes the channel, reconnect on the next publish
+		r.retire(c)
+	}
+	closable := c.closable()
+	r.mux.Unlock()
+	if closable {
+		c.mq.close()
+	}
+	return err
+}
+
+func (r *rabbitMQLogTransport) minLevel() (LogLevel, bool) {
+	return r.config.MinLevel, r.config.MinLevel != 0
+}
+
+// Close stop the janitor and close the connection, when a publish is in progress the connection is closed once it returns
+func (r *rabbitMQLogTransport) Close() error {
+	r.mux.Lock()
+	if r.closed {
+		r.mux.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.stop)
+	c := r.inst
+	var closable bool
+	if c != nil {
+		r.retire(c)
+		closable = c.closable()
+	}
+	r.mux.Unlock()
+	if closable {
+		c.mq.close()
+	}
+	return nil
+}
+
+// instance the connection, created on demand, must be called with mux held
+func (r *rabbitMQLogTransport) instance() (*rabbitMQLogConn, error) {
+	if r.closed {
+		return nil, errors.New("remote log closed")
+	}
+	r.useTime = r.now()
+	if r.inst == nil {
+		mq, err := r.dial(r.config.RabbitMQUrl)
+		if err != nil {
+			writeLocalLog(ErrorLevel, H{"msg": "Failed to create rabbitmq!", "err": err.Error()})
+			return nil, err
+		}
+		r.inst = &rabbitMQLogConn{mq: mq, createdAt: r.useTime}
+		if !r.janitor {
+			r.janitor = true
+			go r.upholdInstance()
+		}
+	}
+	return r.inst, nil
+}
+
+// retire c so that no new publish uses it, must be called with mux held
+func (r *rabbitMQLogTransport) retire(c *rabbitMQLogConn) {
+	if r.inst == c {
+		r.inst = nil
+	}
+	c.retired = true
+}
+
+// closable whether c is retired and no longer used, then it is marked closed and the caller closes it after releasing mux.
+// Must be called with mux held.
+func (c *rabbitMQLogConn) closable() bool {
+	if !c.retired || c.refs > 0 || c.closed {
+		return false
+	}
+	c.closed = true
+	return true
+}
+
+// upholdInstance close the connection when it is idle for 10s or older than MaxConnAt,
+// only one runs at a time, it exits once the connection is closed.
+func (r *rabbitMQLogTransport) upholdInstance() {
+	ticker := time.NewTicker(r.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		r.mux.Lock()
+		c := r.inst
+		if c == nil {
+			r.janitor = false
+			r.mux.Unlock()
+			return
+		}
+		now := r.now()
+		expired := r.config.MaxConnAt > 0 && now.Sub(c.createdAt) > time.Duration(r.config.MaxConnAt)*time.Second
+		// a connection is not idle while it is publishing
+		idle := c.refs == 0 && now.Sub(r.useTime) > time.Second*10
+		if !expired && !idle {
+			r.mux.Unlock()
+			continue
+		}
+		r.retire(c)
+		closable := c.closable()
+		r.janitor = false
+		r.mux.Unlock()
+		if closable {
+			c.mq.close()
+		}
+		return
+	}
+}
+
+// rabbitMQLog a *RabbitMQ as rabbitMQLogClient
+type rabbitMQLog struct {
+	mq *RabbitMQ
+}
+
+func dialRabbitMQLog(url string) (rabbitMQLogClient, error) {
+	// errors of the log connection are only written locally, logging them remotely would publish through it again
+	mq, err := newRabbitMQ(url, []int{LOCAL})
+	if err != nil {
+		return nil, err
+	}
+	return rabbitMQLog{mq: mq}, nil
+}
+
+func (l rabbitMQLog) publish(config *RemoteRabbitMQLog, level LogLevel, body []byte) error {
+	// the message id lets the log sink deduplicate retried messages
+	opts := []PublishOpt{PublishContentType("application/json"), PublishMessageId(NewULID()), PublishTimestamp()}
+	if config.Simple {
+		l.mq.DefQueueDeclare(config.Key, config.Durable, config.AutoDel)
+		return l.mq.PublishMsg(body, l.mq.Queue.Name, opts...)
+	}
+	key := config.Key
+	if config.Kind == KIND_DIRECT {
+		key = GetLevelStringByType(level)
+	}
+	return l.mq.DefExchangeDeclare(config.Exchange, config.Kind, config.Durable, config.AutoDel).PublishMsg(body, key, opts...)
+}
+
+func (l rabbitMQLog) broken() bool {
+	return l.mq.Err() != nil
+}
+
+func (l rabbitMQLog) isClosed() bool {
+	return l.mq.conn.IsClosed()
+}
+
+func (l rabbitMQLog) close() {
+	l.mq.Close()
+}
+
+/* nsq */
+
+type RemoteNsqLog struct {
+	// nsqd address
+	Addr  string
+	Topic string
+	// Publish to Topic.level(e.g. logs.error) instead of Topic
+	LevelTopic bool
+	// Optional, default nsq.NewConfig()
+	Config *nsq.Config
+}
+
+type nsqLogTransport struct {
+	config   *RemoteNsqLog
+	producer *nsq.Producer
+}
+
+// NewNsqLogTransport remote log backend publishing to nsqd
+func NewNsqLogTransport(config *RemoteNsqLog) (RemoteLogTransport, error) {
+	if config.Addr == "" || config.Topic == "" {
+		return nil, errors.New("nsq address and topic cannot be empty")
+	}
+	cfg := config.Config
+	if cfg == nil {
+		cfg = nsq.NewConfig()
+	}
+	producer, err := nsq.NewProducer(config.Addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	producer.SetLoggerLevel(nsq.LogLevelError)
+	return &nsqLogTransport{config: config, producer: producer}, nil
+}
+
+func (n *nsqLogTransport) Publish(level LogLevel, body []byte) error {
+	return n.producer.Publish(levelTopic(n.config.Topic, n.config.LevelTopic, level), body)
+}
+
+func (n *nsqLogTransport) Close() error {
+	n.producer.Stop()
+	return nil
+}
+
+/* kafka */
+
+type RemoteKafkaLog struct {
+	Brokers []string
+	Topic   string
+	// Publish to Topic.level(e.g. logs.error) instead of Topic
+	LevelTopic bool
+	// Timeout of a single write, default 5s
+	WriteTimeout time.Duration
+	// How long a write waits for more messages of the same batch, default 10ms.
+	// The writes are synchronous, a log call waits this long before the message is sent.
+	BatchTimeout time.Duration
+}
+
+type kafkaLogTransport struct {
+	config *RemoteKafkaLog
+	writer *kafka.Writer
+}
+
+// NewKafkaLogTransport remote log backend publishing to kafka, topics are created automatically
+func NewKafkaLogTransport(config *RemoteKafkaLog) (RemoteLogTransport, error) {
+	if len(config.Brokers) == 0 || config.Topic == "" {
+		return nil, errors.New("kafka brokers and topic cannot be empty")
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = time.Second * 5
+	}
+	// kafka-go waits 1s by default
+	if config.BatchTimeout <= 0 {
+		config.BatchTimeout = time.Millisecond * 10
+	}
+	return &kafkaLogTransport{
+		config: config,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(config.Brokers...),
+			Balancer:               &kafka.LeastBytes{},
+			WriteTimeout:           config.WriteTimeout,
+			BatchTimeout:           config.BatchTimeout,
+			AllowAutoTopicCreation: true,
+		},
+	}, nil
+}
+
+func (k *kafkaLogTransport) Publish(level LogLevel, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), k.config.WriteTimeout)
+	defer cancel()
+	return k.writer.WriteMessages(ctx, kafka.Message{
+		Topic: levelTopic(k.config.Topic, k.config.LevelTopic, level),
+		Value: body,
+	})
+}
+
+func (k *kafkaLogTransport) Close() error {
+	return k.writer.Close()
+}
+
+func levelTopic(topic string, perLevel bool, level LogLevel) string {
+	if !perLevel {
+		return topic
+	}
+	if name := GetLevelStringByType(level); name != "" {
+		return topic + "." + name
+	}
+	return topic
+}
@@ -0,0 +1,393 @@
+package fit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryTransport RemoteLogTransport keeping the published messages in memory
+type memoryTransport struct {
+	mux      sync.Mutex
+	messages [][]byte
+	levels   []LogLevel
+	closed   bool
+	err      error
+}
+
+func (m *memoryTransport) Publish(level LogLevel, body []byte) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.closed {
+		return errors.New("transport closed")
+	}
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, body)
+	m.levels = append(m.levels, level)
+	return nil
+}
+
+func (m *memoryTransport) Close() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *memoryTransport) published() [][]byte {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([][]byte(nil), m.messages...)
+}
+
+// useMemoryTransport set a memoryTransport as the remote log backend until the end of the test
+func useMemoryTransport(t *testing.T) *memoryTransport {
+	t.Helper()
+	m := &memoryTransport{}
+	SetRemoteLogTransport(m)
+	t.Cleanup(CloseRemoteLog)
+	return m
+}
+
+func TestRemoteLogFor(t *testing.T) {
+	CloseRemoteLog()
+	if remoteLogFor(ErrorLevel) != nil {
+		t.Fatal("no transport: expected nil")
+	}
+
+	m := useMemoryTransport(t)
+	if remoteLogFor(ErrorLevel) != m {
+		t.Fatal("error level: expected the transport")
+	}
+	if remoteLogFor(InfoLevel) != nil {
+		t.Fatal("info level is below the default min level: expected nil")
+	}
+	if remoteLogFor(TranceInfoLevel) != m {
+		t.Fatal("trace entries are not filtered by level")
+	}
+}
+
+func TestKafkaLogBatchTimeout(t *testing.T) {
+	// kafka-go would wait 1s for a batch in every synchronous write
+	for _, tt := range []struct{ set, want time.Duration }{{0, time.Millisecond * 10}, {time.Millisecond * 50, time.Millisecond * 50}} {
+		tr, err := NewKafkaLogTransport(&RemoteKafkaLog{Brokers: []string{"127.0.0.1:9092"}, Topic: "logs", BatchTimeout: tt.set})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.(*kafkaLogTransport).writer.BatchTimeout; got != tt.want {
+			t.Errorf("BatchTimeout %v: writer.BatchTimeout = %v, want %v", tt.set, got, tt.want)
+		}
+		_ = tr.Close()
+	}
+}
+
+func TestRemoteLogConcurrentPublishAndClose(t *testing.T) {
+	t.Cleanup(CloseRemoteLog)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					RemoteLog(ErrorLevel, "msg", "concurrent")
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		SetRemoteLogTransport(&memoryTransport{})
+		if i%10 == 0 {
+			CloseRemoteLog()
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// fakeRabbitMQLog rabbitMQLogClient recording the misuses of the connection
+type fakeRabbitMQLog struct {
+	mux       sync.Mutex
+	closes    int
+	published int
+	// publishes on a closed connection
+	afterClose int
+	isBroken   bool
+	failEvery  int
+}
+
+func (f *fakeRabbitMQLog) publish(_ *RemoteRabbitMQLog, _ LogLevel, _ []byte) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.closes > 0 {
+		f.afterClose++
+		return errors.New("connection closed")
+	}
+	f.published++
+	if f.failEvery > 0 && f.published%f.failEvery == 0 {
+		f.isBroken = true
+		return errors.New("channel closed")
+	}
+	return nil
+}
+
+func (f *fakeRabbitMQLog) broken() bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.isBroken
+}
+
+func (f *fakeRabbitMQLog) isClosed() bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.closes > 0
+}
+
+func (f *fakeRabbitMQLog) close() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.closes++
+}
+
+func TestRabbitMQLogPublishCloseJanitor(t *testing.T) {
+	var mux sync.Mutex
+	var conns []*fakeRabbitMQLog
+	var clock int64
+	r := newRabbitMQLogTransport(&RemoteRabbitMQLog{RabbitMQUrl: "amqp://fake", MaxConnAt: 1})
+	r.tick = time.Millisecond
+	r.now = func() time.Time { return time.Unix(0, atomic.LoadInt64(&clock)) }
+	r.dial = func(string) (rabbitMQLogClient, error) {
+		c := &fakeRabbitMQLog{}
+		mux.Lock()
+		// every other connection breaks, the others are retired by the janitor
+		if len(conns)%2 == 1 {
+			c.failEvery = 50
+		}
+		conns = append(conns, c)
+		mux.Unlock()
+		return c, nil
+	}
+	SetRemoteLogTransport(r)
+	t.Cleanup(CloseRemoteLog)
+
+	// the clock moves past MaxConnAt and the idle timeout while publishing
+	stop := make(chan struct{})
+	var clockWg sync.WaitGroup
+	clockWg.Add(1)
+	go func() {
+		defer clockWg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			step := time.Millisecond * 300
+			if i%20 == 0 {
+				step = time.Second * 11
+			}
+			atomic.AddInt64(&clock, int64(step))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var published int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := r.Publish(ErrorLevel, []byte(`{"msg":"stress"}`))
+				if err != nil && err.Error() == "remote log closed" {
+					return
+				}
+				if err == nil {
+					atomic.AddInt64(&published, 1)
+				}
+				if n := atomic.LoadInt64(&published); n%100 == 0 {
+					_ = RabbitMQLogHealthCheck(context.Background())
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond * 200)
+	var closeWg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		closeWg.Add(1)
+		go func() {
+			defer closeWg.Done()
+			_ = r.Close()
+		}()
+	}
+	closeWg.Wait()
+	wg.Wait()
+	close(stop)
+	clockWg.Wait()
+
+	if err := r.Publish(ErrorLevel, []byte(`{}`)); err == nil {
+		t.Error("Publish after Close succeeded")
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	var retired int
+	for i, c := range conns {
+		c.mux.Lock()
+		if c.closes != 1 || c.afterClose != 0 {
+			t.Errorf("connection %d: closed %d times, %d publishes after close", i, c.closes, c.afterClose)
+		}
+		// closed by the janitor, not by a failed publish nor Close
+		if !c.isBroken && i < len(conns)-1 {
+			retired++
+		}
+		c.mux.Unlock()
+	}
+	if retired == 0 || atomic.LoadInt64(&published) == 0 {
+		t.Fatalf("%d connections retired by the janitor, %d published", retired, published)
+	}
+}
+
+// legacyHook RemoteLogTemplater without the level
+type legacyHook struct {
+	errs int
+}
+
+func (h *legacyHook) Before(body map[string]interface{}) map[string]interface{} {
+	order, _ := body["order"].(string)
+	body["order"] = order + "l"
+	return body
+}
+
+func (h *legacyHook) Error(error) { h.errs++ }
+
+// panicHook RemoteLogLevelTemplater panicking in every call
+type panicHook struct{}
+
+func (panicHook) Before(LogLevel, map[string]interface{}) map[string]interface{} { panic("before") }
+
+func (panicHook) Error(LogLevel, error) { panic("error") }
+
+func useRemoteLogHooks(t *testing.T, hooks ...interface{}) {
+	t.Helper()
+	for _, h := range hooks {
+		switch h := h.(type) {
+		case RemoteLogLevelTemplater:
+			AddRemoteLogLevelHook(h)
+		case RemoteLogTemplater:
+			AddRemoteLogHook(h)
+		}
+	}
+	t.Cleanup(func() {
+		for _, h := range hooks {
+			RemoveRemoteLogHook(h)
+		}
+	})
+}
+
+func lastOrder(t *testing.T, m *memoryTransport) interface{} {
+	t.Helper()
+	published := m.published()
+	if len(published) == 0 {
+		t.Fatal("nothing published")
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(published[len(published)-1], &body); err != nil {
+		t.Fatal(err)
+	}
+	return body["order"]
+}
+
+func TestRemoteLogHooks(t *testing.T) {
+	m := useMemoryTransport(t)
+	a, b, legacy := &tagHook{tag: "a"}, &tagHook{tag: "b"}, &legacyHook{}
+	useRemoteLogHooks(t, a, legacy, b)
+
+	// called in the order they were added, each one receiving the body of the previous
+	Error("msg", "ordered")
+	if order := lastOrder(t, m); order != "alb" {
+		t.Errorf("order = %v, want alb", order)
+	}
+	ErrorJSON(H{"msg": "ordered"})
+	if order := lastOrder(t, m); order != "alb" {
+		t.Errorf("json order = %v, want alb", order)
+	}
+
+	// below the remote min level: not sent, the hooks are not called
+	Info("msg", "local only")
+	Warning("msg", "warning")
+	if n := len(m.published()); n != 3 {
+		t.Errorf("published = %d, want 3", n)
+	}
+	if want := []LogLevel{ErrorLevel, ErrorLevel, WarnLevel}; !reflect.DeepEqual(a.levels, want) {
+		t.Errorf("levels = %v, want %v", a.levels, want)
+	}
+
+	// a failed publish reaches the Error of every hook with the level
+	m.mux.Lock()
+	m.err = errors.New("unavailable")
+	m.mux.Unlock()
+	Error("msg", "failed")
+	if !reflect.DeepEqual(a.errs, []LogLevel{ErrorLevel}) || !reflect.DeepEqual(b.errs, []LogLevel{ErrorLevel}) || legacy.errs != 1 {
+		t.Errorf("errors: a=%v b=%v legacy=%d", a.errs, b.errs, legacy.errs)
+	}
+	m.mux.Lock()
+	m.err = nil
+	m.mux.Unlock()
+
+	RemoveRemoteLogHook(a)
+	RemoveRemoteLogHook(legacy)
+	Error("msg", "removed")
+	if order := lastOrder(t, m); order != "b" {
+		t.Errorf("order after removal = %v, want b", order)
+	}
+	// removing an unknown or non comparable hook is a no-op
+	RemoveRemoteLogHook(&tagHook{tag: "a"})
+	RemoveRemoteLogHook(map[string]int{})
+	Error("msg", "removed")
+	if order := lastOrder(t, m); order != "b" {
+		t.Errorf("order = %v, want b", order)
+	}
+}
+
+func TestRemoteLogHookPanic(t *testing.T) {
+	m := useMemoryTransport(t)
+	buf := useBufferLogger(t, "hook_test")
+	oldDef := defLog
+	defLog = "hook_test"
+	t.Cleanup(func() { defLog = oldDef })
+	a, b := &tagHook{tag: "a"}, &tagHook{tag: "b"}
+	useRemoteLogHooks(t, a, panicHook{}, b)
+
+	// the panicking hook is skipped, the log is still sent
+	Error("msg", "recovered")
+	if order := lastOrder(t, m); order != "ab" {
+		t.Errorf("order = %v, want ab", order)
+	}
+	if !strings.Contains(buf.String(), "remote log hook Before panic") {
+		t.Errorf("panic not logged locally: %s", buf.String())
+	}
+
+	m.mux.Lock()
+	m.err = errors.New("unavailable")
+	m.mux.Unlock()
+	Error("msg", "failed")
+	if len(a.errs) != 1 || len(b.errs) != 1 {
+		t.Errorf("errors: a=%v b=%v", a.errs, b.errs)
+	}
+	if !strings.Contains(buf.String(), "remote log hook Error panic") {
+		t.Errorf("panic not logged locally: %s", buf.String())
+	}
+}
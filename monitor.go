@@ -9,12 +9,20 @@ import (
 	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,6 +35,9 @@ const (
 	WORK_MODE = "WORK"
 )
 
+// monitorHTTPClient client used to push monitor data over http
+var monitorHTTPClient = NewHTTPClient(HTTPTimeout(5 * time.Second))
+
 type mqRemoteConfig struct {
 	mqDeclareName    string
 	mqDeclareDurable bool
@@ -37,6 +48,100 @@ type mqRemoteConfig struct {
 	mqRoutingKey      string
 }
 
+// MonitorCommand monitoring command read from etcd
+type MonitorCommand struct {
+	//INIT | WORK
+	Stage string `json:"stage"`
+	//HTTP | MQ
+	SubType string `json:"subType"`
+	//collection interval in seconds, default 5
+	Duration json.Number `json:"duration"`
+	//number of retries when etcd is unavailable, default 5
+	RetryCount     json.Number `json:"retryCount"`
+	ReturnWorkTask bool        `json:"returnWorkTask"`
+	ReturnCpu      bool        `json:"returnCpu"`
+	ReturnMem      bool        `json:"returnMem"`
+	ReturnIoCount  bool        `json:"returnIoCount"`
+	ReturnRuntime  bool        `json:"returnRuntime"`
+
+	//simple | work | publish | routing
+	MqWorkType        string `json:"mqWorkType"`
+	MqDeclareName     string `json:"mqDeclareName"`
+	MqDeclareDurable  bool   `json:"mqDeclareDurable"`
+	MqAutoDelete      *bool  `json:"mqAutoDelete"`
+	MqExchangeName    string `json:"mqExchangeName"`
+	MqExchangeDurable bool   `json:"mqExchangeDurable"`
+	MqRoutingKey      string `json:"mqRoutingKey"`
+
+	SubHttpUrl    string `json:"subHttpUrl"`
+	SubHttpToken  string `json:"subHttpToken"`
+	SubHttpHeader H      `json:"subHttpHeader"`
+
+	duration   time.Duration
+	retryCount uint
+}
+
+// ParseMonitorCommand unmarshal and validate the monitoring command
+func ParseMonitorCommand(data []byte) (*MonitorCommand, error) {
+	var cmd MonitorCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return nil, fmt.Errorf("monitor command decode failed: %w", err)
+	}
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return &cmd, nil
+}
+
+// Validate check the command and fill in the defaults
+func (c *MonitorCommand) Validate() error {
+	if c.Stage != INIT_MODE && c.Stage != WORK_MODE {
+		return fmt.Errorf("monitor command: invalid stage %q, expected %s or %s", c.Stage, INIT_MODE, WORK_MODE)
+	}
+
+	switch c.SubType {
+	case pushTypeIsNil:
+		return NewErr("monitor command: subType cannot be empty")
+	case pushTypeIsMQ:
+		switch c.MqWorkType {
+		case "":
+			return NewErr("monitor command: mqWorkType cannot be empty")
+		case "simple", "work", "publish", "routing":
+		default:
+			return fmt.Errorf("monitor command: invalid mqWorkType %q", c.MqWorkType)
+		}
+	case pushTypeIsHttp:
+		if c.SubHttpUrl == "" {
+			return NewErr("monitor command: subHttpUrl cannot be empty")
+		}
+	default:
+		return fmt.Errorf("monitor command: invalid subType %q, expected %s or %s", c.SubType, pushTypeIsHttp, pushTypeIsMQ)
+	}
+
+	c.duration = 5 * time.Second
+	if c.Duration != "" {
+		n, err := c.Duration.Int64()
+		if err != nil || n <= 0 {
+			return fmt.Errorf("monitor command: duration must be a positive integer, got %q", c.Duration.String())
+		}
+		c.duration = time.Duration(n) * time.Second
+	}
+
+	c.retryCount = 5
+	if c.RetryCount != "" {
+		n, err := c.RetryCount.Int64()
+		if err != nil || n < 0 {
+			return fmt.Errorf("monitor command: retryCount must be a non-negative integer, got %q", c.RetryCount.String())
+		}
+		c.retryCount = uint(n)
+	}
+
+	if c.MqRoutingKey == "" {
+		c.MqRoutingKey = "monitor"
+	}
+	return nil
+}
+
 type IoCounter struct {
 	BytesSent   uint64 `json:"bytes_sent"`
 	BytesRecv   uint64 `json:"bytes_recv"`
@@ -59,10 +164,26 @@ type VirtualMemory struct {
 	UsedPercent float64
 }
 
+// RuntimeInfo metrics of the current go process
+type RuntimeInfo struct {
+	NumGoroutine int    `json:"num_goroutine,omitempty"`
+	HeapAlloc    uint64 `json:"heap_alloc,omitempty"`
+	HeapInuse    uint64 `json:"heap_inuse,omitempty"`
+	Sys          uint64 `json:"sys,omitempty"`
+	NumGC        uint32 `json:"num_gc,omitempty"`
+	PauseTotalNs uint64 `json:"pause_total_ns,omitempty"`
+	RSS          uint64 `json:"rss,omitempty"`
+	NumFDs       int32  `json:"num_fds,omitempty"`
+	//seconds since the process started
+	Uptime int64 `json:"uptime,omitempty"`
+}
+
 type RedisInfo struct {
 	RedisInfoClients string `json:"redis_info_clients"`
 	RedisInfoStats   string `json:"redis_info_stats"`
 	RedisInfoMemory  string `json:"redis_info_memory"`
+	// Parsed sections requested by CollectRedisInfo
+	Sections map[string]map[string]string `json:"redis_info_sections,omitempty"`
 }
 
 type MessageBody struct {
@@ -79,6 +200,7 @@ type MessageBody struct {
 	IoCounter
 	VirtualMemory
 	RedisInfo
+	RuntimeInfo
 	Time time.Time `json:"time"`
 }
 
@@ -95,23 +217,39 @@ type ServiceMonitorOption struct {
 }
 
 type monitorTask struct {
-	option       *ServiceMonitorOption
-	etcdv3       *EtcdHandle
-	isActive     bool
-	atWork       bool
-	quitLoopChan chan bool
-	uniqueCode   string
-	isDown       bool
+	option   *ServiceMonitorOption
+	etcdv3   *EtcdHandle
+	isActive int32
+
+	// mux serializes the commands, at most one worker runs at a time
+	mux    sync.Mutex
+	worker *monitorWorker
+	// work the job of the worker, continuousWork when nil
+	work func(w *monitorWorker)
+}
+
+type monitorWorker struct {
+	cmd        *MonitorCommand
+	uniqueCode string
+	isDown     int32
+	quit       chan struct{}
+	done       chan struct{}
+}
+
+// stop ask the worker to exit and wait for it
+func (w *monitorWorker) stop() {
+	close(w.quit)
+	<-w.done
 }
 
 var onlineGrouting int32
 
 func OnlineGroutingAdd() {
-	onlineGrouting++
+	atomic.AddInt32(&onlineGrouting, 1)
 }
 
 func OnlineGroutingCut() {
-	onlineGrouting--
+	atomic.AddInt32(&onlineGrouting, -1)
 }
 
 func ServiceMonitorTask(option *ServiceMonitorOption) error {
@@ -120,20 +258,16 @@ func ServiceMonitorTask(option *ServiceMonitorOption) error {
 		return err
 	}
 
-	config, err := etcdv3.Get("/service/config")
-	if err != nil {
-		return err
+	var config struct {
+		ServiceMonitoringPfx string `json:"serviceMonitoringPfx"`
 	}
-
-	result, err := ExtractValAndToMap(config)
-	if err != nil {
+	if err := etcdv3.GetJSON("/service/config", &config); err != nil {
 		return err
 	}
-
-	pfx, ok := result["serviceMonitoringPfx"].(string)
-	if !ok {
-		return NewErr("serviceMonitoringPfx assertion failed")
+	if config.ServiceMonitoringPfx == "" {
+		return NewErr("serviceMonitoringPfx cannot be empty")
 	}
+	pfx := config.ServiceMonitoringPfx
 
 	taskData := monitorTask{
 		option: option,
@@ -162,41 +296,29 @@ func ServiceMonitorTask(option *ServiceMonitorOption) error {
 }
 
 func (m *monitorTask) watchPutHandler(key, value []byte) error {
-	data := make(map[string]any, 0)
-	if err := json.Unmarshal(value, &data); err != nil {
+	cmd, err := ParseMonitorCommand(value)
+	if err != nil {
 		return err
 	}
 
 	//This service is responsible for collecting
 	keyArr := strings.Split(string(key), "/")
 	if keyArr[len(keyArr)-1] == m.option.ServiceNode {
-		m.isActive = true
+		atomic.StoreInt32(&m.isActive, 1)
 	}
 
-	return m.taskController(data)
+	return m.taskController(cmd)
 }
 
-func (m *monitorTask) taskController(obj map[string]any) error {
-	stage, ok := obj["stage"].(string)
-	if !ok || stage == "" {
-		return NewErr("stage cannot be empty")
-	}
-
-	if err := checkParams(obj); err != nil {
-		return err
-	}
-
-	if stage == INIT_MODE {
-		m.quitLoopChan = make(chan bool, 1)
-		m.uniqueCode = GetMachineCode()
-
+func (m *monitorTask) taskController(cmd *MonitorCommand) error {
+	if cmd.Stage == INIT_MODE {
 		name := StringSpliceTag("/", m.option.ServiceType, m.option.ServiceName, m.option.ServiceNode)
 		body := MessageBody{
 			Stage:         INIT_MODE,
 			Name:          name,
 			Address:       m.option.ServiceAddress,
 			SystemVersion: m.option.SystemVersion,
-			PhysicalId:    m.uniqueCode,
+			PhysicalId:    GetMachineCode(),
 			Time:          time.Now(),
 		}
 		if hostInfo, err := host.Info(); err == nil {
@@ -205,100 +327,91 @@ func (m *monitorTask) taskController(obj map[string]any) error {
 			body.KernelArch = hostInfo.KernelArch
 			body.PlatformVersion = hostInfo.PlatformVersion
 		}
-		subType := obj["subType"].(string)
-		if subType == pushTypeIsMQ {
+		if cmd.SubType == pushTypeIsMQ {
 			mq, err := NewRabbitMQ()
 			if err != nil {
 				return err
 			}
 			defer mq.Close()
-			config := m.extractMqInfo(obj)
-			err = m.sendMqMessage(mq, obj, &body, config)
-			return err
+			return m.sendMqMessage(mq, cmd, &body, m.extractMqInfo(cmd))
 		}
-		if subType == pushTypeIsHttp {
-			return m.sendHttpMessage(obj, &body)
+		if cmd.SubType == pushTypeIsHttp {
+			return m.sendHttpMessage(cmd, &body)
 		}
 		return nil
 	}
 
-	if stage == WORK_MODE {
-		if m.atWork {
-			return nil
+	if cmd.Stage == WORK_MODE {
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		if m.worker != nil {
+			// the same command written again
+			if reflect.DeepEqual(m.worker.cmd, cmd) {
+				return nil
+			}
+			m.worker.stop()
 		}
-		m.quitLoopChan = make(chan bool, 1)
-		m.atWork = true
-		m.uniqueCode = GetMachineCode()
-		go m.continuousWork(obj)
+		w := &monitorWorker{
+			cmd:        cmd,
+			uniqueCode: GetMachineCode(),
+			quit:       make(chan struct{}),
+			done:       make(chan struct{}),
+		}
+		m.worker = w
+		work := m.work
+		if work == nil {
+			work = m.continuousWork
+		}
+		go func() {
+			defer close(w.done)
+			work(w)
+		}()
 	}
 
 	return nil
 }
 
+// close stop the worker, can be called multiple times
 func (m *monitorTask) close() {
-	if m.quitLoopChan == nil {
-		return
-	}
-	m.quitLoopChan <- true
-	close(m.quitLoopChan)
-	m.isActive = false
-	m.atWork = false
-	m.isDown = false
-	fmt.Println("关闭")
+	m.closeWorker(nil)
 }
 
-func (m *monitorTask) continuousWork(obj map[string]any) {
-	durationSource, ok := obj["duration"].(int)
-	if !ok {
-		durationSource = 5
-	}
-
-	duration := time.Duration(durationSource)
-	returnWorkTask, ok := obj["returnWorkTask"].(bool)
-	if !ok {
-		returnWorkTask = false
-	}
-
-	returnMem, ok := obj["returnMem"].(bool)
-	if !ok {
-		returnMem = false
-	}
-
-	returnCpu, ok := obj["returnCpu"].(bool)
-	if !ok {
-		returnCpu = false
+// closeWorker stop the running worker, only if it is w when w is not nil
+func (m *monitorTask) closeWorker(w *monitorWorker) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.worker == nil || (w != nil && m.worker != w) {
+		return
 	}
+	m.worker.stop()
+	m.worker = nil
+	atomic.StoreInt32(&m.isActive, 0)
+}
 
-	returnIoCount, ok := obj["returnIoCount"].(bool)
-	if !ok {
-		returnIoCount = false
-	}
-
-	retryCount, ok := obj["retryCount"].(uint)
-	if !ok {
-		retryCount = 5
-	}
+func (m *monitorTask) continuousWork(w *monitorWorker) {
+	cmd := w.cmd
+	duration := cmd.duration
+	retryCount := cmd.retryCount
 
 	var mq *RabbitMQ
 	var err error
-	subType := obj["subType"].(string)
-	if subType == pushTypeIsMQ {
+	if cmd.SubType == pushTypeIsMQ {
 		mq, err = NewRabbitMQ()
+		if err != nil {
+			Error("msg", "newRabbitMQ instance failed!", "err", err)
+			return
+		}
 		defer mq.Close()
 	}
-	if err != nil {
-		Error("msg", "newRabbitMQ instance failed!", "err", err)
-		return
-	}
 
-	config := m.extractMqInfo(obj)
+	config := m.extractMqInfo(cmd)
 	name := StringSpliceTag("/", m.option.ServiceType, m.option.ServiceName, m.option.ServiceNode)
 	body := MessageBody{
 		Stage:         WORK_MODE,
 		Name:          name,
 		Address:       m.option.ServiceAddress,
 		SystemVersion: m.option.SystemVersion,
-		PhysicalId:    m.uniqueCode,
+		PhysicalId:    w.uniqueCode,
 		Time:          time.Now(),
 	}
 	if hostInfo, err := host.Info(); err == nil {
@@ -307,42 +420,57 @@ func (m *monitorTask) continuousWork(obj map[string]any) {
 		body.KernelArch = hostInfo.KernelArch
 		body.PlatformVersion = hostInfo.PlatformVersion
 	}
+	sleep := func() bool {
+		t := time.NewTimer(duration)
+		defer t.Stop()
+		select {
+		case <-w.quit:
+			return false
+		case <-m.option.Context.Done():
+			return false
+		case <-t.C:
+			return true
+		}
+	}
 	for {
 		select {
-		case <-m.quitLoopChan:
-			m.quitLoopChan = nil
+		case <-w.quit:
 			return
 		case <-m.option.Context.Done():
 			return
 		default:
 		}
 
-		if m.isDown {
-			time.Sleep(time.Second * duration)
+		if atomic.LoadInt32(&w.isDown) == 1 {
+			if !sleep() {
+				return
+			}
 			continue
 		}
 
-		if returnWorkTask {
-			body.WorkTasks = onlineGrouting
+		if cmd.ReturnWorkTask {
+			body.WorkTasks = atomic.LoadInt32(&onlineGrouting)
 		}
 
-		if err := PingEtcd(); err != nil {
-			m.isDown = true
+		if err := PingEtcd(m.option.Context); err != nil {
+			atomic.StoreInt32(&w.isDown, 1)
 			go func(m *monitorTask) {
 				err := retry.Do(func() error {
-					if err := PingEtcd(); err != nil {
-						return err
-					}
-					return nil
-				}, retry.Attempts(retryCount), retry.OnRetry(func(n uint, err error) {
+					// a hung endpoint must not consume the retry budget
+					ctx, cancel := context.WithTimeout(m.option.Context, time.Second*3)
+					defer cancel()
+					_, err := PingEtcdCtx(ctx)
+					return err
+				}, retry.Context(m.option.Context), retry.Attempts(retryCount), retry.OnRetry(func(n uint, err error) {
 					Error("business", "service monitoring information collection node", "msg", "The etcd is unavailable, and the connection is being retried,The maximum number of retries is "+strconv.Itoa(int(retryCount)), "count", n+1)
 				}))
 				if err != nil {
-					m.close()
+					// stopping waits for the worker, it must not be called from the worker
+					m.closeWorker(w)
 					Error("business", "service monitoring information collection node", "msg", "The etcd is unavailable, the number of retries has reached the maximum, and the task has been closed!", "err", err)
 					return
 				}
-				m.isDown = false
+				atomic.StoreInt32(&w.isDown, 0)
 				Error("business", "service monitoring information collection node", "The etcd is unavailable, and the connection is retried successfully. The task has been recovered", "err", err)
 			}(m)
 			continue
@@ -350,25 +478,29 @@ func (m *monitorTask) continuousWork(obj map[string]any) {
 
 		body.Time = time.Now()
 
-		if m.isActive {
+		if atomic.LoadInt32(&m.isActive) == 1 {
 			if hostInfo, err := host.Info(); err == nil {
 				body.Procs = hostInfo.Procs
 			}
 
-			if returnMem {
+			if cmd.ReturnMem {
 				body.VirtualMemory = GetVirtualMemory()
 			}
 
-			if returnCpu {
+			if cmd.ReturnCpu {
 				totalPercent, _ := cpu.Percent(time.Second, false)
 				if len(totalPercent) > 0 {
 					body.CpuPercent = totalPercent[0]
 				}
 			}
 
-			if returnIoCount {
+			if cmd.ReturnIoCount {
 				body.IoCounter = GetIOCounters()
 			}
+
+			if cmd.ReturnRuntime {
+				body.RuntimeInfo = GetRuntimeInfo()
+			}
 		}
 
 		redisInfo, err := CollectRedisInfo(m.option.RecordRedisClientInfo, m.option.RecordRedisStatsInfo, m.option.RecordRedisMemoryInfo)
@@ -384,77 +516,47 @@ func (m *monitorTask) continuousWork(obj map[string]any) {
 			}
 		}
 
-		if subType == pushTypeIsMQ {
-			if err := m.sendMqMessage(mq, obj, &body, config); err != nil {
+		if cmd.SubType == pushTypeIsMQ {
+			if err := m.sendMqMessage(mq, cmd, &body, config); err != nil {
 				Error("business", "service monitoring information collection node", "msg", "mq send failed!!", "err", err)
 			}
 		}
 
-		if subType == pushTypeIsHttp {
-			if err := m.sendHttpMessage(obj, &body); err != nil {
+		if cmd.SubType == pushTypeIsHttp {
+			if err := m.sendHttpMessage(cmd, &body); err != nil {
 				Error("business", "service monitoring information collection node", "msg", "http request failed!!", "err", err)
 			}
 		}
 
-		time.Sleep(time.Second * duration)
+		if !sleep() {
+			return
+		}
 	}
 }
 
-func (m *monitorTask) extractMqInfo(obj map[string]any) *mqRemoteConfig {
-	var mqConfig mqRemoteConfig
-	mqAutoDelete, ok := obj["mqAutoDelete"].(bool)
-	if !ok {
-		mqAutoDelete = true
+func (m *monitorTask) extractMqInfo(cmd *MonitorCommand) *mqRemoteConfig {
+	mqConfig := mqRemoteConfig{mqAutoDelete: true}
+	if cmd.MqAutoDelete != nil {
+		mqConfig.mqAutoDelete = *cmd.MqAutoDelete
 	}
-	mqConfig.mqAutoDelete = mqAutoDelete
 
-	mqWorkType := obj["mqWorkType"].(string)
-	if mqWorkType == "simple" || mqWorkType == "work" {
-		mqDeclareName, ok := obj["mqDeclareName"].(string)
-		if !ok {
-			mqDeclareName = ""
-		}
-		mqDeclareDurable, ok := obj["mqDeclareDurable"].(bool)
-		if !ok {
-			mqDeclareDurable = false
-		}
-		mqConfig.mqDeclareName = mqDeclareName
-		mqConfig.mqDeclareDurable = mqDeclareDurable
-	}
-	if mqWorkType == "publish" {
-		mqExchangeName, ok := obj["mqExchangeName"].(string)
-		if !ok {
-			mqExchangeName = ""
-		}
-		mqExchangeDurable, ok := obj["mqExchangeDurable"].(bool)
-		if !ok {
-			mqExchangeDurable = false
-		}
-		mqConfig.mqExchangeName = mqExchangeName
-		mqConfig.mqExchangeDurable = mqExchangeDurable
-	}
-	if mqWorkType == "routing" {
-		mqExchangeName, ok := obj["mqExchangeName"].(string)
-		if !ok {
-			mqExchangeName = ""
-		}
-		mqExchangeDurable, ok := obj["mqExchangeDurable"].(bool)
-		if !ok {
-			mqExchangeDurable = false
-		}
-		mqRoutingKey, ok := obj["mqRoutingKey"].(string)
-		if !ok {
-			mqRoutingKey = "monitor"
-		}
-		mqConfig.mqExchangeName = mqExchangeName
-		mqConfig.mqExchangeDurable = mqExchangeDurable
-		mqConfig.mqRoutingKey = mqRoutingKey
+	switch cmd.MqWorkType {
+	case "simple", "work":
+		mqConfig.mqDeclareName = cmd.MqDeclareName
+		mqConfig.mqDeclareDurable = cmd.MqDeclareDurable
+	case "publish":
+		mqConfig.mqExchangeName = cmd.MqExchangeName
+		mqConfig.mqExchangeDurable = cmd.MqExchangeDurable
+	case "routing":
+		mqConfig.mqExchangeName = cmd.MqExchangeName
+		mqConfig.mqExchangeDurable = cmd.MqExchangeDurable
+		mqConfig.mqRoutingKey = cmd.MqRoutingKey
 	}
 	return &mqConfig
 }
 
-func (m *monitorTask) sendMqMessage(mq *RabbitMQ, obj map[string]any, body *MessageBody, mqConfig *mqRemoteConfig) error {
-	mqWorkType := obj["mqWorkType"].(string)
+func (m *monitorTask) sendMqMessage(mq *RabbitMQ, cmd *MonitorCommand, body *MessageBody, mqConfig *mqRemoteConfig) error {
+	mqWorkType := cmd.MqWorkType
 	if mqWorkType == "simple" || mqWorkType == "work" {
 		bodyMsg, err := json.Marshal(body)
 		if err != nil {
@@ -479,38 +581,22 @@ func (m *monitorTask) sendMqMessage(mq *RabbitMQ, obj map[string]any, body *Mess
 	return nil
 }
 
-func (m *monitorTask) sendHttpMessage(obj map[string]any, body *MessageBody) error {
-	url, ok := obj["subHttpUrl"].(string)
-	if !ok {
-		return NewErr("SubHttpUrl cannot be empty！")
-	}
+func (m *monitorTask) sendHttpMessage(cmd *MonitorCommand, body *MessageBody) error {
 	h := H{}
-	if subHttpToken, ok := obj["subHttpToken"].(string); ok {
-		h["Authorization"] = "Bearer " + subHttpToken
-	}
-
-	subHttpHeader, ok := obj["subHttpHeader"].(H)
-	if !ok {
-		subHttpHeader = H{}
-	} else {
-		h = subHttpHeader
+	if cmd.SubHttpToken != "" {
+		h["Authorization"] = "Bearer " + cmd.SubHttpToken
 	}
-
-	bodyStr, err := json.Marshal(body)
-	if err != nil {
-		return err
+	for k, v := range cmd.SubHttpHeader {
+		h[k] = v
 	}
 
 	return retry.Do(func() error {
-		resObj := new(HttpUtil).NewRequest(http.MethodPost, url, string(bodyStr))
-		if resObj.Err != nil {
-			return resObj.Err
-		}
-
-		response, err := resObj.Response()
+		response, err := monitorHTTPClient.Post(context.Background(), cmd.SubHttpUrl, body, h)
 		if err != nil {
 			return err
 		}
+		defer response.Body.Close()
+		_, _ = io.Copy(ioutil.Discard, response.Body)
 
 		if response.StatusCode != http.StatusOK {
 			return NewErr("request failed!")
@@ -520,31 +606,6 @@ func (m *monitorTask) sendHttpMessage(obj map[string]any, body *MessageBody) err
 	}, retry.Attempts(3))
 }
 
-func checkParams(obj map[string]any) error {
-	stage, ok := obj["stage"].(string)
-	if !ok || stage == "" {
-		return NewErr("stage cannot be empty")
-	}
-
-	subType, ok := obj["subType"].(string)
-	if !ok || subType == pushTypeIsNil {
-		return NewErr("subType cannot be empty")
-	}
-
-	if subType == pushTypeIsMQ {
-		if mqWorkType, ok := obj["mqWorkType"].(string); !ok || mqWorkType == "" {
-			return NewErr("mqWorkType cannot be empty")
-		}
-	}
-
-	if subType == pushTypeIsHttp {
-		if subHttpUrl, ok := obj["subHttpUrl"].(string); !ok || subHttpUrl == "" {
-			return NewErr("subHttpUrl cannot be empty")
-		}
-	}
-	return nil
-}
-
 func GetMachineCode(myApp ...string) string {
 	var id string
 	var err error
@@ -573,6 +634,39 @@ func GetIOCounters() (io IoCounter) {
 	return io
 }
 
+var (
+	processStartTime = time.Now()
+	currentProcess   *process.Process
+	processOnce      sync.Once
+)
+
+// GetRuntimeInfo collect the metrics of the current process, fields that cannot be read are left empty
+func GetRuntimeInfo() (info RuntimeInfo) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	info.NumGoroutine = runtime.NumGoroutine()
+	info.HeapAlloc = ms.HeapAlloc
+	info.HeapInuse = ms.HeapInuse
+	info.Sys = ms.Sys
+	info.NumGC = ms.NumGC
+	info.PauseTotalNs = ms.PauseTotalNs
+	info.Uptime = int64(time.Since(processStartTime).Seconds())
+
+	processOnce.Do(func() {
+		currentProcess, _ = process.NewProcess(int32(os.Getpid()))
+	})
+	if currentProcess == nil {
+		return info
+	}
+	if memInfo, err := currentProcess.MemoryInfo(); err == nil {
+		info.RSS = memInfo.RSS
+	}
+	if fds, err := currentProcess.NumFDs(); err == nil {
+		info.NumFDs = fds
+	}
+	return info
+}
+
 func GetVirtualMemory() (memInfo VirtualMemory) {
 	m, err := mem.VirtualMemory()
 	if err != nil {
@@ -585,34 +679,95 @@ func GetVirtualMemory() (memInfo VirtualMemory) {
 	return memInfo
 }
 
-func CollectRedisInfo(returnClient, returnStats, returnMemory bool) (*RedisInfo, error) {
-	result, err := MainRedis().GetNode().Info(context.Background()).Result()
+// CollectRedisInfo the raw text of the clients, stats and memory sections of INFO,
+// the additional sections are parsed into RedisInfo.Sections, e.g. "replication", "keyspace".
+func CollectRedisInfo(returnClient, returnStats, returnMemory bool, sections ...string) (*RedisInfo, error) {
+	if !returnClient && !returnStats && !returnMemory && len(sections) == 0 {
+		return nil, NewErr("at least one is true!")
+	}
+	node := MainRedis().GetUniversal()
+	if node == nil {
+		return nil, NewErr("redis instance not found!")
+	}
+
+	var result string
+	var err error
+	if len(sections) > 0 {
+		// the default INFO output does not contain every section
+		result, err = node.Info(context.Background(), "all").Result()
+	} else {
+		result, err = node.Info(context.Background()).Result()
+	}
 	if err != nil {
 		return nil, err
 	}
-	strArr := strings.Split(result, "\n\r")
 
-	if len(strArr) == 0 {
+	parsed, raw := parseRedisInfo(result)
+	if len(raw) == 0 {
 		return nil, NewErr("get redis info failed!")
 	}
 
-	if !returnClient && !returnStats && !returnMemory {
-		return nil, NewErr("at least one is true!")
-	}
-
 	var info RedisInfo
-
 	if returnClient {
-		info.RedisInfoClients = strArr[1]
+		info.RedisInfoClients = raw["clients"]
 	}
-
 	if returnStats {
-		info.RedisInfoStats = strArr[5]
+		info.RedisInfoStats = raw["stats"]
 	}
-
 	if returnMemory {
-		info.RedisInfoMemory = strArr[3]
+		info.RedisInfoMemory = raw["memory"]
+	}
+	if len(sections) > 0 {
+		info.Sections = make(map[string]map[string]string, len(sections))
+		for _, name := range sections {
+			name = strings.ToLower(name)
+			if section, ok := parsed[name]; ok {
+				info.Sections[name] = section
+			}
+		}
 	}
-
 	return &info, nil
 }
+
+// ParseRedisInfo split the output of INFO into sections(lower-case names, e.g. "memory") of key-value pairs
+func ParseRedisInfo(info string) map[string]map[string]string {
+	parsed, _ := parseRedisInfo(info)
+	return parsed
+}
+
+// parseRedisInfo the parsed sections and the raw text of every section, the header included
+func parseRedisInfo(info string) (map[string]map[string]string, map[string]string) {
+	parsed := make(map[string]map[string]string)
+	raw := make(map[string]string)
+
+	var name string
+	var text strings.Builder
+	flush := func() {
+		if name != "" {
+			raw[name] = strings.TrimRight(text.String(), "\r\n")
+		}
+		text.Reset()
+	}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "#") {
+			flush()
+			name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			parsed[name] = make(map[string]string)
+		}
+		if name == "" {
+			continue
+		}
+		text.WriteString(line)
+		text.WriteString("\r\n")
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			parsed[name][line[:i]] = line[i+1:]
+		}
+	}
+	flush()
+	return parsed, raw
+}
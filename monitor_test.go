@@ -0,0 +1,286 @@
+package fit
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRedisInfo(t *testing.T) {
+	tests := []struct {
+		file     string
+		sections []string
+		values   map[string]map[string]string
+		clients  string
+	}{
+		{
+			file: "testdata/redis6_info.txt",
+			sections: []string{"server", "clients", "memory", "persistence", "stats", "replication",
+				"cpu", "modules", "errorstats", "cluster", "keyspace"},
+			values: map[string]map[string]string{
+				"server":      {"redis_version": "6.2.6", "redis_mode": "standalone", "config_file": ""},
+				"clients":     {"connected_clients": "12", "blocked_clients": "0"},
+				"memory":      {"used_memory": "1452088", "used_memory_peak_perc": "93.46%", "maxmemory_policy": "noeviction"},
+				"stats":       {"keyspace_hits": "40321", "keyspace_misses": "1203"},
+				"replication": {"role": "master", "slave0": "ip=10.0.0.12,port=6379,state=online,offset=1234567,lag=0"},
+				"errorstats":  {"errorstat_ERR": "count=4"},
+				"keyspace":    {"db0": "keys=128,expires=12,avg_ttl=3456789", "db3": "keys=2,expires=0,avg_ttl=0"},
+			},
+			clients: "connected_clients:12",
+		},
+		{
+			file: "testdata/redis7_info.txt",
+			sections: []string{"server", "clients", "memory", "persistence", "stats", "replication",
+				"cpu", "modules", "errorstats", "cluster", "keyspace"},
+			values: map[string]map[string]string{
+				"server":   {"redis_version": "7.0.5", "redis_mode": "cluster", "monotonic_clock": "POSIX clock_gettime"},
+				"clients":  {"connected_clients": "25", "cluster_connections": "10"},
+				"memory":   {"used_memory": "2810496", "maxmemory_human": "1.00G", "number_of_functions": "2"},
+				"stats":    {"keyspace_hits": "99001", "evicted_clients": "0"},
+				"cluster":  {"cluster_enabled": "1"},
+				"keyspace": {"db0": "keys=5321,expires=120,avg_ttl=86213144"},
+			},
+			clients: "connected_clients:25",
+		},
+	}
+
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// INFO is sent with CRLF line endings, the fixtures are checked in with LF
+		for _, eol := range []string{"\n", "\r\n"} {
+			info := strings.ReplaceAll(string(data), "\n", eol)
+			parsed, raw := parseRedisInfo(info)
+
+			if len(parsed) != len(tt.sections) {
+				t.Errorf("%s %q: %d sections, want %d", tt.file, eol, len(parsed), len(tt.sections))
+			}
+			for _, name := range tt.sections {
+				if _, ok := parsed[name]; !ok {
+					t.Errorf("%s %q: section %s missing", tt.file, eol, name)
+				}
+			}
+			for name, values := range tt.values {
+				for k, want := range values {
+					if got, ok := parsed[name][k]; !ok || got != want {
+						t.Errorf("%s %q: %s.%s = %q, want %q", tt.file, eol, name, k, got, want)
+					}
+				}
+			}
+			// keys never leak into the next section
+			if _, ok := parsed["clients"]["used_memory"]; ok {
+				t.Errorf("%s %q: memory key in the clients section", tt.file, eol)
+			}
+
+			clients := raw["clients"]
+			if !strings.HasPrefix(clients, "# Clients\r\n"+tt.clients+"\r\n") || !strings.HasSuffix(clients, "clients_in_timeout_table:0") {
+				t.Errorf("%s %q: raw clients section = %q", tt.file, eol, clients)
+			}
+			if strings.Contains(clients, "# Memory") {
+				t.Errorf("%s %q: raw clients section runs into the next one", tt.file, eol)
+			}
+		}
+	}
+}
+
+func TestParseRedisInfoMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		info string
+		want map[string]map[string]string
+	}{
+		{"empty", "", map[string]map[string]string{}},
+		{"no header", "used_memory:1\r\n", map[string]map[string]string{}},
+		{"empty section", "# Modules\r\n\r\n# Cluster\r\ncluster_enabled:0\r\n", map[string]map[string]string{
+			"modules": {},
+			"cluster": {"cluster_enabled": "0"},
+		}},
+		{"value with colon", "# Server\r\nexecutable:C:\\redis\\redis-server.exe\r\n", map[string]map[string]string{
+			"server": {"executable": "C:\\redis\\redis-server.exe"},
+		}},
+		{"line without colon", "# Server\r\ngarbage\r\n:novalue\r\n", map[string]map[string]string{
+			"server": {},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseRedisInfo(tt.info)
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseRedisInfo = %v, want %v", got, tt.want)
+			}
+			for name, values := range tt.want {
+				if len(got[name]) != len(values) {
+					t.Fatalf("%s = %v, want %v", name, got[name], values)
+				}
+				for k, v := range values {
+					if got[name][k] != v {
+						t.Errorf("%s.%s = %q, want %q", name, k, got[name][k], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+// fakeMonitorWork a worker job recording how many jobs run at the same time
+type fakeMonitorWork struct {
+	running, maxRunning, started int32
+}
+
+func (f *fakeMonitorWork) work(w *monitorWorker) {
+	atomic.AddInt32(&f.started, 1)
+	n := atomic.AddInt32(&f.running, 1)
+	for {
+		max := atomic.LoadInt32(&f.maxRunning)
+		if n <= max || atomic.CompareAndSwapInt32(&f.maxRunning, max, n) {
+			break
+		}
+	}
+	<-w.quit
+	// exiting takes a while, the next worker must still wait for it
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&f.running, -1)
+}
+
+func workCommand(t *testing.T, duration int) *MonitorCommand {
+	t.Helper()
+	cmd, err := ParseMonitorCommand([]byte(`{"stage":"WORK","subType":"HTTP","subHttpUrl":"http://127.0.0.1/monitor","duration":` + strconv.Itoa(duration) + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestMonitorTaskWorkCommands(t *testing.T) {
+	fake := &fakeMonitorWork{}
+	m := &monitorTask{work: fake.work}
+	// closing waits for the running worker, every worker started so far has then run
+	closeAndCount := func() int32 {
+		m.close()
+		if n := atomic.LoadInt32(&fake.running); n != 0 {
+			t.Fatalf("%d workers still running after close", n)
+		}
+		return atomic.LoadInt32(&fake.started)
+	}
+
+	// the same command written concurrently: one worker
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.taskController(workCommand(t, 5)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := closeAndCount(); n != 1 {
+		t.Fatalf("identical commands started %d workers, want 1", n)
+	}
+
+	// different commands: every one replaces the running worker, one at a time
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := m.taskController(workCommand(t, 10+i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := closeAndCount(); n != 21 {
+		t.Errorf("different commands started %d workers, want 20 more", n-1)
+	}
+	if n := atomic.LoadInt32(&fake.maxRunning); n != 1 {
+		t.Errorf("%d workers ran at the same time, want 1", n)
+	}
+
+	// DELETE repeated, then WORK again
+	m.close()
+	if err := m.taskController(workCommand(t, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if n := closeAndCount(); n != 22 {
+		t.Errorf("WORK after close started %d workers, want 1 more", n-21)
+	}
+}
+
+func TestParseMonitorCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		duration   time.Duration
+		retryCount uint
+		routingKey string
+	}{
+		{"defaults", `{"stage":"WORK","subType":"HTTP","subHttpUrl":"http://127.0.0.1/monitor"}`, 5 * time.Second, 5, "monitor"},
+		{"numbers", `{"stage":"INIT","subType":"HTTP","subHttpUrl":"http://127.0.0.1/monitor","duration":10,"retryCount":0}`, 10 * time.Second, 0, "monitor"},
+		{"numbers as strings", `{"stage":"WORK","subType":"HTTP","subHttpUrl":"http://127.0.0.1/monitor","duration":"7","retryCount":"3"}`, 7 * time.Second, 3, "monitor"},
+		{"mq", `{"stage":"WORK","subType":"MQ","mqWorkType":"routing","mqRoutingKey":"metrics","retryCount":1}`, 5 * time.Second, 1, "metrics"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := ParseMonitorCommand([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cmd.duration != tt.duration || cmd.retryCount != tt.retryCount || cmd.MqRoutingKey != tt.routingKey {
+				t.Errorf("duration = %v, retryCount = %d, routingKey = %q, want %v, %d, %q",
+					cmd.duration, cmd.retryCount, cmd.MqRoutingKey, tt.duration, tt.retryCount, tt.routingKey)
+			}
+		})
+	}
+}
+
+func TestParseMonitorCommandInvalid(t *testing.T) {
+	const http = `"stage":"WORK","subType":"HTTP","subHttpUrl":"http://127.0.0.1/monitor"`
+	tests := []struct {
+		name, data, err string
+	}{
+		{"not json", `{`, "decode failed"},
+		{"array", `[]`, "decode failed"},
+		{"null", `null`, "invalid stage"},
+		{"empty", `{}`, "invalid stage"},
+		{"stage type", `{"stage":1}`, "decode failed"},
+		{"unknown stage", `{"stage":"STOP","subType":"HTTP","subHttpUrl":"http://127.0.0.1"}`, "invalid stage"},
+		{"no subType", `{"stage":"WORK"}`, "subType cannot be empty"},
+		{"unknown subType", `{"stage":"WORK","subType":"SMS"}`, "invalid subType"},
+		{"no mqWorkType", `{"stage":"WORK","subType":"MQ"}`, "mqWorkType cannot be empty"},
+		{"unknown mqWorkType", `{"stage":"WORK","subType":"MQ","mqWorkType":"fanout"}`, "invalid mqWorkType"},
+		{"mqWorkType type", `{"stage":"WORK","subType":"MQ","mqWorkType":["simple"]}`, "decode failed"},
+		{"no subHttpUrl", `{"stage":"WORK","subType":"HTTP"}`, "subHttpUrl cannot be empty"},
+		{"zero duration", `{` + http + `,"duration":0}`, "duration must be a positive integer"},
+		{"negative duration", `{` + http + `,"duration":-1}`, "duration must be a positive integer"},
+		{"fractional duration", `{` + http + `,"duration":1.5}`, "duration must be a positive integer"},
+		{"duration not a number", `{` + http + `,"duration":"5s"}`, "decode failed"},
+		{"negative retryCount", `{` + http + `,"retryCount":-1}`, "retryCount must be a non-negative integer"},
+		{"fractional retryCount", `{` + http + `,"retryCount":2.5}`, "retryCount must be a non-negative integer"},
+		{"bool type", `{` + http + `,"returnCpu":"yes"}`, "decode failed"},
+		{"header type", `{` + http + `,"subHttpHeader":"token"}`, "decode failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := ParseMonitorCommand([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+			if cmd != nil {
+				t.Errorf("cmd = %+v, want nil", cmd)
+			}
+
+			// the watcher gets the error to log instead of panicking
+			m := &monitorTask{work: func(*monitorWorker) { t.Error("worker started for an invalid command") }}
+			if err := m.watchPutHandler([]byte("/monitor/svc/node"), []byte(tt.data)); err == nil {
+				t.Error("watchPutHandler accepted an invalid command")
+			}
+		})
+	}
+}
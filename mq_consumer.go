@@ -1,29 +1,194 @@
 package fit
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/nsqio/go-nsq"
+	"sync/atomic"
 	"time"
 )
 
 type ConsumerEntity struct {
 	Topic   string
 	Channel string
+	// nsqlookupd address
 	Address string
-	Handler nsq.Handler
+	// Multiple nsqlookupd addresses, merged with Address
+	Addresses []string
+	Handler   nsq.Handler
+	// Maximum number of messages in flight, default 1
+	MaxInFlight int
+	// Number of goroutines running Handler, default 1
+	HandlerConcurrency int
+	// Optional, default nsq.NewConfig() with LookupdPollInterval 15s
+	Config *nsq.Config
+
+	// The following fields are used by InitTypedConsumer
+
+	// Timeout of the context passed to the handler, 0 means no timeout
+	MessageTimeout time.Duration
+	// Attempts before a message is sent to DeadLetterTopic, default Config.MaxAttempts(5)
+	MaxAttempts uint16
+	// Base delay of a requeued message, doubled on every attempt, default 1s
+	RequeueDelay time.Duration
+	// Topic receiving the messages that failed MaxAttempts times, published with NsqPublish(see InitNsqProducer).
+	// Empty drops them with an error log
+	DeadLetterTopic string
+	// Send the messages that cannot be decoded to DeadLetterTopic immediately, otherwise they are dropped with an error log
+	DeadLetterOnParseError bool
+}
+
+type NsqConsumer struct {
+	// accessed atomically, first for the 64-bit alignment
+	parseErrors int64
+	Consumer    *nsq.Consumer
+}
+
+// ParseErrors number of messages InitTypedConsumer failed to decode
+func (n *NsqConsumer) ParseErrors() int64 {
+	return atomic.LoadInt64(&n.parseErrors)
+}
+
+func InitConsumer(entity ConsumerEntity) (*NsqConsumer, error) {
+	n := &NsqConsumer{}
+	if err := initConsumer(n, entity, entity.Handler); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// InitTypedConsumer decode the json body of each message into T and call handler with a context of MessageTimeout.
+// When handler fails the message is requeued with a delay growing with the attempts, after MaxAttempts it is sent
+// to DeadLetterTopic. A timeout requeues with backoff so that the consumer slows down, other errors do not.
+func InitTypedConsumer[T any](entity ConsumerEntity, handler func(ctx context.Context, msg T, raw *nsq.Message) error) (*NsqConsumer, error) {
+	n := &NsqConsumer{}
+	h := nsq.HandlerFunc(func(m *nsq.Message) error {
+		m.DisableAutoResponse()
+
+		var v T
+		if err := json.Unmarshal(m.Body, &v); err != nil {
+			atomic.AddInt64(&n.parseErrors, 1)
+			if entity.DeadLetterOnParseError {
+				deadLetter(entity, m, err)
+				return nil
+			}
+			Error("msg", "nsq message cannot be decoded, dropped", "topic", entity.Topic, "channel", entity.Channel, "id", string(m.ID[:]), "err", err)
+			m.Finish()
+			return nil
+		}
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if entity.MessageTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, entity.MessageTimeout)
+		}
+		err := handler(ctx, v, m)
+		cancel()
+		if err == nil {
+			m.Finish()
+			return nil
+		}
+
+		if m.Attempts >= typedMaxAttempts(entity) {
+			deadLetter(entity, m, err)
+			return nil
+		}
+		delay := typedRequeueDelay(entity, m.Attempts)
+		if errors.Is(err, context.DeadlineExceeded) {
+			m.Requeue(delay)
+		} else {
+			m.RequeueWithoutBackoff(delay)
+		}
+		return nil
+	})
+	if err := initConsumer(n, entity, h); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func typedMaxAttempts(entity ConsumerEntity) uint16 {
+	if entity.MaxAttempts > 0 {
+		return entity.MaxAttempts
+	}
+	if entity.Config != nil && entity.Config.MaxAttempts > 0 {
+		return entity.Config.MaxAttempts
+	}
+	return 5
 }
 
-func InitConsumer(entity ConsumerEntity) (err error) {
-	config := nsq.NewConfig()
-	config.LookupdPollInterval = 15 * time.Second
+func typedRequeueDelay(entity ConsumerEntity, attempts uint16) time.Duration {
+	delay := entity.RequeueDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for i := uint16(1); i < attempts && delay < time.Minute*10; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// deadLetter publish the body to DeadLetterTopic and finish the message, it is requeued when the publish fails
+func deadLetter(entity ConsumerEntity, m *nsq.Message, cause error) {
+	if entity.DeadLetterTopic == "" {
+		Error("msg", "nsq message failed, dropped", "topic", entity.Topic, "channel", entity.Channel, "id", string(m.ID[:]), "attempts", m.Attempts, "err", cause)
+		m.Finish()
+		return
+	}
+	if err := NsqPublish(entity.DeadLetterTopic, m.Body); err != nil {
+		Error("msg", "nsq dead letter publish failed", "topic", entity.DeadLetterTopic, "id", string(m.ID[:]), "err", err)
+		m.Requeue(typedRequeueDelay(entity, m.Attempts))
+		return
+	}
+	m.Finish()
+}
+
+func initConsumer(n *NsqConsumer, entity ConsumerEntity, handler nsq.Handler) error {
+	addresses := entity.Addresses
+	if entity.Address != "" {
+		addresses = append([]string{entity.Address}, addresses...)
+	}
+	if len(addresses) == 0 {
+		return errors.New("find not nsqlookupd address")
+	}
+
+	config := entity.Config
+	if config == nil {
+		config = nsq.NewConfig()
+		config.LookupdPollInterval = 15 * time.Second
+	}
+	if entity.MaxInFlight > 0 {
+		config.MaxInFlight = entity.MaxInFlight
+	}
+	if entity.MaxAttempts > 0 {
+		config.MaxAttempts = entity.MaxAttempts
+	}
 	c, err := nsq.NewConsumer(entity.Topic, entity.Channel, config)
 	if err != nil {
 		return err
 	}
 
-	c.AddHandler(entity.Handler)
+	if entity.HandlerConcurrency > 1 {
+		c.AddConcurrentHandlers(handler, entity.HandlerConcurrency)
+	} else {
+		c.AddHandler(handler)
+	}
 
-	if err := c.ConnectToNSQLookupd(entity.Address); err != nil {
+	if err := c.ConnectToNSQLookupds(addresses); err != nil {
+		c.Stop()
 		return err
 	}
+	n.Consumer = c
 	return nil
 }
+
+// Stop stop receiving messages and wait for the in-flight messages to finish, or until ctx is done
+func (n *NsqConsumer) Stop(ctx context.Context) error {
+	n.Consumer.Stop()
+	select {
+	case <-n.Consumer.StopChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -3,6 +3,8 @@ package fit
 import (
 	"errors"
 	"github.com/nsqio/go-nsq"
+	"sync"
+	"time"
 )
 
 var producerConfig *nsq.Config
@@ -41,3 +43,103 @@ func (p *Producer) Put(topic string, body []byte) error {
 	p.Producer.Stop()
 	return nil
 }
+
+var (
+	nsqProducerMux    sync.Mutex
+	nsqProducer       *nsq.Producer
+	nsqProducerAddr   string
+	nsqProducerConfig *nsq.Config
+)
+
+// InitNsqProducer initialize the shared producer used by NsqPublish and NsqDeferredPublish,
+// cfg is optional, default nsq.NewConfig().
+func InitNsqProducer(addr string, cfg *nsq.Config) error {
+	if len(addr) == 0 {
+		return errors.New("find not producer address")
+	}
+	if cfg == nil {
+		cfg = nsq.NewConfig()
+	}
+
+	nsqProducerMux.Lock()
+	defer nsqProducerMux.Unlock()
+	if nsqProducer != nil {
+		nsqProducer.Stop()
+		nsqProducer = nil
+	}
+	nsqProducerAddr = addr
+	nsqProducerConfig = cfg
+	_, err := getNsqProducer()
+	return err
+}
+
+// NsqPublish publish body to topic with the shared producer
+func NsqPublish(topic string, body []byte) error {
+	return nsqPublish(func(p *nsq.Producer) error {
+		return p.Publish(topic, body)
+	})
+}
+
+// NsqDeferredPublish publish body to topic, it is delivered to the consumers after delay
+func NsqDeferredPublish(topic string, delay time.Duration, body []byte) error {
+	return nsqPublish(func(p *nsq.Producer) error {
+		return p.DeferredPublish(topic, delay, body)
+	})
+}
+
+// CloseNsqProducer stop the shared producer
+func CloseNsqProducer() {
+	nsqProducerMux.Lock()
+	defer nsqProducerMux.Unlock()
+	if nsqProducer != nil {
+		nsqProducer.Stop()
+		nsqProducer = nil
+	}
+}
+
+// nsqPublish the producer is recreated once when the connection is gone
+func nsqPublish(fn func(p *nsq.Producer) error) error {
+	nsqProducerMux.Lock()
+	p, err := getNsqProducer()
+	nsqProducerMux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = fn(p)
+	if err == nil || (err != nsq.ErrStopped && err != nsq.ErrNotConnected) {
+		return err
+	}
+
+	nsqProducerMux.Lock()
+	if nsqProducer == p {
+		p.Stop()
+		nsqProducer = nil
+	}
+	p, err = getNsqProducer()
+	nsqProducerMux.Unlock()
+	if err != nil {
+		return err
+	}
+	return fn(p)
+}
+
+// getNsqProducer must be called with nsqProducerMux held
+func getNsqProducer() (*nsq.Producer, error) {
+	if nsqProducer != nil {
+		return nsqProducer, nil
+	}
+	if nsqProducerAddr == "" {
+		return nil, errors.New("nsq producer is not initialized")
+	}
+	p, err := nsq.NewProducer(nsqProducerAddr, nsqProducerConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Ping(); err != nil {
+		p.Stop()
+		return nil, err
+	}
+	nsqProducer = p
+	return p, nil
+}
@@ -1,8 +1,11 @@
 package fit
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
 	"strings"
 )
 
@@ -24,38 +27,208 @@ func GetMacAddrs() (macAddrs []string) {
 }
 
 func GetOutBoundIP() (ip string, err error) {
-	conn, err := net.Dial("udp", "8.8.8.8:53")
+	return dialOutBoundIP(false)
+}
+
+// AdvertiseIPEnv environment variable overriding the result of GetOutBoundIPWithOptions
+const AdvertiseIPEnv = "FIT_ADVERTISE_IP"
+
+// OutBoundIPOptions options of GetOutBoundIPWithOptions
+type OutBoundIPOptions struct {
+	// Preferred interface name, e.g. eth1
+	Interface string
+	// Prefer IPv6 addresses, IPv4 is preferred by default
+	PreferIPv6 bool
+	// Interfaces whose name starts with one of the prefixes are skipped,
+	// default docker, br-, veth, virbr, cni, flannel
+	ExcludePrefixes []string
+	// Do not fall back to dialing a public address when the interface scan finds nothing
+	DisableDial bool
+}
+
+var defaultExcludeInterfaces = []string{"docker", "br-", "veth", "virbr", "cni", "flannel"}
+
+// GetOutBoundIPWithOptions get the ip of this machine, in order:
+// the FIT_ADVERTISE_IP environment variable, the interface scan, the udp dial of GetOutBoundIP.
+// Use net.JoinHostPort to join the result with a port, so that IPv6 addresses are bracketed.
+func GetOutBoundIPWithOptions(opts OutBoundIPOptions) (string, error) {
+	if ip := strings.TrimSpace(os.Getenv(AdvertiseIPEnv)); ip != "" {
+		if net.ParseIP(ip) == nil {
+			return "", fmt.Errorf("invalid %s: %s", AdvertiseIPEnv, ip)
+		}
+		return ip, nil
+	}
+
+	if ip, ok := scanInterfaceIP(opts, localInterfaces()); ok {
+		return ip, nil
+	}
+
+	if opts.DisableDial {
+		return "", errors.New("no usable network interface address found")
+	}
+	if opts.PreferIPv6 {
+		if ip, err := dialOutBoundIP(true); err == nil {
+			return ip, nil
+		}
+	}
+	return dialOutBoundIP(false)
+}
+
+// interfaceAddrs a network interface and its addresses
+type interfaceAddrs struct {
+	name  string
+	flags net.Flags
+	addrs []net.Addr
+}
+
+func localInterfaces() []interfaceAddrs {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	list := make([]interfaceAddrs, 0, len(interfaces))
+	for _, in := range interfaces {
+		addrs, err := in.Addrs()
+		if err != nil {
+			continue
+		}
+		list = append(list, interfaceAddrs{name: in.Name, flags: in.Flags, addrs: addrs})
+	}
+	return list
+}
+
+func scanInterfaceIP(opts OutBoundIPOptions, interfaces []interfaceAddrs) (string, bool) {
+	excludes := opts.ExcludePrefixes
+	if excludes == nil {
+		excludes = defaultExcludeInterfaces
+	}
+
+	var v4, v6 string
+	for _, in := range interfaces {
+		if in.flags&net.FlagUp == 0 || in.flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if opts.Interface != "" && in.name != opts.Interface {
+			continue
+		}
+		if opts.Interface == "" && hasAnyPrefix(in.name, excludes) {
+			continue
+		}
+
+		for _, addr := range in.addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				if v4 == "" {
+					v4 = ipNet.IP.String()
+				}
+			} else if v6 == "" {
+				v6 = ipNet.IP.String()
+			}
+		}
+	}
+
+	if opts.PreferIPv6 && v6 != "" {
+		return v6, true
+	}
+	if v4 != "" {
+		return v4, true
+	}
+	return v6, v6 != ""
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialOutBoundIP no packet is sent, the system only selects the local address of the route
+func dialOutBoundIP(ipv6 bool) (string, error) {
+	network, target := "udp4", "8.8.8.8:53"
+	if ipv6 {
+		network, target = "udp6", "[2001:4860:4860::8888]:53"
+	}
+	conn, err := net.Dial(network, target)
 	if err != nil {
 		return "", err
 	}
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	ip = strings.Split(localAddr.String(), ":")[0]
-	return ip, nil
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+var advertiseIPOptions OutBoundIPOptions
+
+// SetAdvertiseIPOptions options of the ip advertised by ListenFreePort and GrpcService(registered in etcd)
+// when the listen address has no host, see GetOutBoundIPWithOptions
+func SetAdvertiseIPOptions(opts OutBoundIPOptions) {
+	advertiseIPOptions = opts
+}
+
+// advertiseIP the ip of this machine advertised to the other services
+func advertiseIP() (string, error) {
+	return GetOutBoundIPWithOptions(advertiseIPOptions)
 }
 
 // GetRandomAvPort Randomly obtain an available port number.
+//
+// The probe listener is closed before returning, another process may take the port
+// before it is listened on again, use ListenFreePort to keep the listener open.
 func GetRandomAvPort() (int, error) {
 	listen, err := net.Listen("tcp", ":0")
 	if err != nil {
 		return 0, err
 	}
 	defer listen.Close()
-	return listen.Addr().(*net.TCPAddr).Port, nil
+	return GetListenPort(listen), nil
+}
+
+// ListenFreePort listen on a random available port of host and return the open listener
+// with its "ip:port" address, an empty host listens on all interfaces and advertises the ip of
+// GetOutBoundIPWithOptions(see SetAdvertiseIPOptions).
+func ListenFreePort(host string) (net.Listener, string, error) {
+	advertise := host
+	if host == "" {
+		ip, err := advertiseIP()
+		if err != nil {
+			return nil, "", err
+		}
+		advertise = ip
+	}
+
+	listen, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, "", err
+	}
+	return listen, net.JoinHostPort(advertise, strconv.Itoa(GetListenPort(listen))), nil
 }
 
+// GetListenPort port of the listener, 0 if the address has no port
 func GetListenPort(ls net.Listener) int {
-	return ls.Addr().(*net.TCPAddr).Port
+	if addr, ok := ls.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	_, port, err := net.SplitHostPort(ls.Addr().String())
+	if err != nil {
+		return 0
+	}
+	p, _ := strconv.Atoi(port)
+	return p
 }
 
 // GetRandomAvPortAndHost Obtain the IP+random available port number of this machine.
+//
+// The port is not reserved, see GetRandomAvPort, prefer ListenFreePort.
 func GetRandomAvPortAndHost() (string, error) {
-	localIp, err := GetOutBoundIP()
-	if err != nil {
-		return "", err
-	}
-	port, err := GetRandomAvPort()
+	listen, addr, err := ListenFreePort("")
 	if err != nil {
 		return "", err
 	}
-	return net.JoinHostPort(localIp, fmt.Sprintf("%d", port)), nil
+	listen.Close()
+	return addr, nil
 }
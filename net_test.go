@@ -0,0 +1,85 @@
+package fit
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAdvertiseIPEnv(t *testing.T) {
+	t.Setenv(AdvertiseIPEnv, "10.1.2.3")
+	if ip, err := GetOutBoundIPWithOptions(OutBoundIPOptions{DisableDial: true}); err != nil || ip != "10.1.2.3" {
+		t.Fatalf("GetOutBoundIPWithOptions = %s, %v", ip, err)
+	}
+
+	// the advertised addresses use it
+	l, addr, err := ListenFreePort("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if host, _, _ := net.SplitHostPort(addr); host != "10.1.2.3" {
+		t.Fatalf("ListenFreePort advertised %s", addr)
+	}
+	l2, addr, err := grpcServiceListen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l2.Close()
+	if host, _, _ := net.SplitHostPort(addr); host != "127.0.0.1" {
+		t.Fatalf("a listen host is advertised as is: %s", addr)
+	}
+	l3, addr, err := grpcServiceListen(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l3.Close()
+	if host, _, _ := net.SplitHostPort(addr); host != "10.1.2.3" {
+		t.Fatalf("grpcServiceListen advertised %s", addr)
+	}
+
+	t.Setenv(AdvertiseIPEnv, "not an ip")
+	if _, err := GetOutBoundIPWithOptions(OutBoundIPOptions{}); err == nil {
+		t.Fatal("invalid FIT_ADVERTISE_IP: expected an error")
+	}
+}
+
+func TestScanInterfaceIP(t *testing.T) {
+	ipNet := func(s string) net.Addr {
+		return &net.IPNet{IP: net.ParseIP(s), Mask: net.CIDRMask(24, 32)}
+	}
+	up := net.FlagUp
+	interfaces := []interfaceAddrs{
+		{name: "lo", flags: up | net.FlagLoopback, addrs: []net.Addr{ipNet("127.0.0.1")}},
+		{name: "docker0", flags: up, addrs: []net.Addr{ipNet("172.17.0.1")}},
+		{name: "veth1a2b", flags: up, addrs: []net.Addr{ipNet("172.18.0.1")}},
+		{name: "eth9", addrs: []net.Addr{ipNet("10.9.0.1")}}, // down
+		{name: "eth0", flags: up, addrs: []net.Addr{ipNet("fe80::1"), ipNet("fd00::2"), ipNet("192.168.1.10")}},
+		{name: "eth1", flags: up, addrs: []net.Addr{ipNet("10.0.0.5")}},
+	}
+
+	tests := []struct {
+		name string
+		opts OutBoundIPOptions
+		want string
+	}{
+		{"default excludes", OutBoundIPOptions{}, "192.168.1.10"},
+		{"prefer ipv6", OutBoundIPOptions{PreferIPv6: true}, "fd00::2"},
+		{"interface", OutBoundIPOptions{Interface: "eth1"}, "10.0.0.5"},
+		// a named interface is used even when excluded
+		{"excluded interface", OutBoundIPOptions{Interface: "docker0"}, "172.17.0.1"},
+		{"custom excludes", OutBoundIPOptions{ExcludePrefixes: []string{"eth"}}, "172.17.0.1"},
+		{"no excludes", OutBoundIPOptions{ExcludePrefixes: []string{}}, "172.17.0.1"},
+	}
+	for _, tt := range tests {
+		if got, ok := scanInterfaceIP(tt.opts, interfaces); !ok || got != tt.want {
+			t.Errorf("%s: scanInterfaceIP = %s, %v, want %s", tt.name, got, ok, tt.want)
+		}
+	}
+
+	if got, ok := scanInterfaceIP(OutBoundIPOptions{Interface: "eth9"}, interfaces); ok {
+		t.Errorf("down interface: got %s", got)
+	}
+	if got, ok := scanInterfaceIP(OutBoundIPOptions{}, interfaces[:3]); ok {
+		t.Errorf("only excluded and loopback interfaces: got %s", got)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"github.com/go-playground/validator/v10"
 	en_trans "github.com/go-playground/validator/v10/translations/en"
 	zh_trans "github.com/go-playground/validator/v10/translations/zh"
+	"net/http"
+	"strings"
+	"sync"
 )
 
 var validate *validator.Validate
@@ -27,6 +30,41 @@ func NewValidator() {
 	case "en":
 		_ = en_trans.RegisterDefaultTranslations(validate, trans)
 	}
+	registerCustomValidations(validate, trans)
+}
+
+func registerCustomValidations(v *validator.Validate, t ut.Translator) {
+	custom := []struct {
+		tag string
+		fn  func(string) bool
+		msg string
+	}{
+		{"mobile", IsChineseMobile, "{0}必须是有效的手机号码"},
+		{"idcard", IsChineseIDCard, "{0}必须是有效的身份证号码"},
+	}
+	for _, c := range custom {
+		fn := c.fn
+		_ = v.RegisterValidation(c.tag, func(fl validator.FieldLevel) bool {
+			return fn(fl.Field().String())
+		})
+		tag, msg := c.tag, c.msg
+		_ = v.RegisterTranslation(tag, t, func(ut ut.Translator) error {
+			return ut.Add(tag, msg, true)
+		}, func(ut ut.Translator, fe validator.FieldError) string {
+			s, _ := ut.T(tag, fe.Field())
+			return s
+		})
+	}
+}
+
+var validatorOnce sync.Once
+
+func ensureValidator() {
+	validatorOnce.Do(func() {
+		if validate == nil {
+			NewValidator()
+		}
+	})
 }
 
 func CheckParam(c *gin.Context, data interface{}) error {
@@ -77,3 +115,60 @@ func Validate(data interface{}) error {
 	}
 	return nil
 }
+
+// FieldError a field failing the validation
+type FieldError struct {
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+}
+
+// ValidationError every field failing the validation, returned by ValidateStruct
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FieldMap field name to message
+func (e *ValidationError) FieldMap() map[string]string {
+	m := make(map[string]string, len(e.Fields))
+	for _, f := range e.Fields {
+		m[f.Field] = f.Msg
+	}
+	return m
+}
+
+// ValidateStruct validate the struct tags(required, min, max, len, mobile, idcard and the other validator tags),
+// unlike Validate every failed field is reported in a *ValidationError with Chinese messages.
+func ValidateStruct(v any) error {
+	ensureValidator()
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+	ve := &ValidationError{Fields: make([]FieldError, 0, len(errs))}
+	for _, e := range errs {
+		ve.Fields = append(ve.Fields, FieldError{Field: e.Field(), Msg: e.Translate(trans)})
+	}
+	return ve
+}
+
+// FailValidation respond StatusCErr(http 400), a *ValidationError puts the field to message map in the result
+func FailValidation(c *gin.Context, err error) {
+	var ve *ValidationError
+	if errors.As(err, &ve) && len(ve.Fields) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, newResponse(c, StatusCErr, ve.Fields[0].Msg, ve.FieldMap()))
+		return
+	}
+	Fail(c, StatusCErr, err.Error())
+}
@@ -1,18 +1,26 @@
 package fit
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/streadway/amqp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var MQURL string
 
 const (
-	ALL     = iota //write in all ways according to the log configuration
-	LOCAL          //local log
-	REMOTE         //remote log，Take effect after configuring log
-	CONSOLE        //output to console
+	ALL      = iota //write in all ways according to the log configuration
+	LOCAL           //local log
+	REMOTE          //remote log，Take effect after configuring log
+	CONSOLE         //output to console
+	CALLBACK        //call the handler set by OnRabbitMqError or RabbitMQ.OnError
 
 	KIND_FANOUT = "fanout" //post messages to all queues bound to this switch
 	// KIND_DIRECT deliver the message to the queue where the bindingkey and routingkey exactly match
@@ -20,12 +28,39 @@ const (
 	// KIND_TOPIC rule matching. There are two special characters in bindingkey* Match zero or more words,
 	//35; match one word
 	KIND_TOPIC = "topic"
-	// KIND_HEADER it does not depend on the routingkey, but performs matching binding through the headers attribute in the message body.
-	//The key in the headers and the bindingkey are completely matched
-	KIND_HEADER = "header"
+	// KIND_HEADERS it does not depend on the routingkey, but performs matching binding through the headers attribute in the message body.
+	//The key in the headers and the bindingkey are completely matched, publish with PublishHeaders
+	KIND_HEADERS = "headers"
+
+	// KIND_FANOU
+	//
+	// Deprecated: use KIND_FANOUT
+	KIND_FANOU = KIND_FANOUT
+	// KIND_HEADER
+	//
+	// Deprecated: use KIND_HEADERS, the broker does not know the kind "header"
+	KIND_HEADER = KIND_HEADERS
 )
 
-var errHandles []int
+// ErrInvalidExchangeKind the kind passed to ExchangeDeclare/DefExchangeDeclare is not one of KIND_* or a plugin kind(x-*)
+var ErrInvalidExchangeKind = errors.New("invalid exchange kind")
+
+func checkExchangeKind(kind string) error {
+	switch kind {
+	case KIND_FANOUT, KIND_DIRECT, KIND_TOPIC, KIND_HEADERS:
+		return nil
+	}
+	if strings.HasPrefix(kind, "x-") {
+		return nil
+	}
+	return fmt.Errorf("%w %q, expected one of fanout, direct, topic, headers or x-*", ErrInvalidExchangeKind, kind)
+}
+
+var (
+	errHandlesMux sync.RWMutex
+	errHandles    []int
+	errCallback   func(op string, err error)
+)
 
 type PublishConfig struct {
 	Exchange  string
@@ -36,18 +71,55 @@ type PublishConfig struct {
 }
 
 type ConsumeConfig struct {
-	Consumer  string
+	Consumer string
+	// Same as AckMode AutoAck
 	AutoAck   bool
 	Exclusive bool
 	NoLocal   bool
 	NoWait    bool
 	Args      amqp.Table
+
+	// Default ManualAck
+	AckMode AckMode
+	// Maximum number of unacknowledged deliveries the broker sends with the manual modes, default 64
+	PrefetchCount int
+
+	// The following fields are used by ConsumeWithHandler
+
+	// Number of goroutines running the handler, default 1
+	Concurrency int
+	// Requeue the delivery when the handler returns an error, otherwise it is discarded(or dead-lettered)
+	RequeueOnError bool
+	// Log a warning when more than UnackedWarnCount deliveries stay unacknowledged for UnackedWarnAfter, 0 disables it
+	UnackedWarnCount int
+	UnackedWarnAfter time.Duration
+}
+
+type AckMode int
+
+const (
+	// ManualAck deliveries are acknowledged by msg.Ack, the broker sends at most PrefetchCount unacknowledged deliveries
+	ManualAck AckMode = iota
+	// AutoAck deliveries are acknowledged once sent, do not call msg.Ack
+	AutoAck
+	// ManualAckWithRequeueOnPanic like ManualAck, ConsumeWithHandler requeues the delivery when the handler panics
+	ManualAckWithRequeueOnPanic
+)
+
+const defaultPrefetchCount = 64
+
+func (c ConsumeConfig) autoAck() bool {
+	return c.AutoAck || c.AckMode == AutoAck
 }
 
 type RabbitMQ struct {
-	conn         *amqp.Connection
-	channel      *amqp.Channel
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	// errOptMux guards errHandles and errCallback, errMux serializes the error handling of the instance
+	errOptMux    sync.RWMutex
+	errMux       sync.Mutex
 	errHandles   []int
+	errCallback  func(op string, err error)
 	Queue        amqp.Queue
 	ExchangeName string
 	Key          string
@@ -55,12 +127,21 @@ type RabbitMQ struct {
 	err          error
 }
 
-// SetRabbitMqErrLogHandle Optional value
-// ALL LOCAL REMOTE
+// SetRabbitMqErrLogHandle how the errors of all instances are handled, default LOCAL.
+// Optional value ALL LOCAL REMOTE CONSOLE CALLBACK, the setting of an instance takes precedence.
 func SetRabbitMqErrLogHandle(v ...int) {
+	errHandlesMux.Lock()
+	defer errHandlesMux.Unlock()
 	errHandles = v
 }
 
+// OnRabbitMqError the handler of CALLBACK for the instances without their own handler(see RabbitMQ.OnError)
+func OnRabbitMqError(fn func(op string, err error)) {
+	errHandlesMux.Lock()
+	defer errHandlesMux.Unlock()
+	errCallback = fn
+}
+
 func SetMqURL(url string) {
 	MQURL = url
 }
@@ -76,51 +157,130 @@ func (r *RabbitMQ) Conn() *amqp.Connection {
 func (r *RabbitMQ) Close() {
 	err := r.channel.Close()
 	if err != nil {
-		r.failOnErr("mq channel close failed err:", err)
+		r.failOnErr("channel_close", err)
 	}
 	err = r.conn.Close()
 	if err != nil {
-		r.failOnErr("mq conn close failed err:", err)
-	}
-}
-
-func (r *RabbitMQ) failOnErr(msg string, err error) {
-	if r.errHandles != nil {
-		for _, kv := range r.errHandles {
-			switch kv {
-			case ALL:
-				Error("msg", msg, "err", err)
-				return
-			case CONSOLE:
-				fmt.Println(msg, err)
-			case LOCAL:
-				LocalLog().Error("msg", msg, "err", err)
-			case REMOTE:
-				RemoteLog(ErrorLevel, "msg", msg, "err", err)
+		r.failOnErr("conn_close", err)
+	}
+}
+
+// failOnErr handle the error of op with the setting of the instance, or the global one when the instance has none.
+// The handling of an instance is serialized.
+func (r *RabbitMQ) failOnErr(op string, err error) {
+	r.errOptMux.RLock()
+	handles, callback := r.errHandles, r.errCallback
+	r.errOptMux.RUnlock()
+	errHandlesMux.RLock()
+	if handles == nil {
+		handles = errHandles
+	}
+	if callback == nil {
+		callback = errCallback
+	}
+	errHandlesMux.RUnlock()
+	if handles == nil {
+		handles = []int{LOCAL}
+	}
+
+	r.errMux.Lock()
+	defer r.errMux.Unlock()
+	msg := "rabbitmq " + op + " failed"
+	for _, kv := range handles {
+		switch kv {
+		case ALL:
+			Error("msg", msg, "op", op, "err", err)
+		case CONSOLE:
+			fmt.Println(msg, err)
+		case LOCAL:
+			LocalLog().Error("msg", msg, "op", op, "err", err)
+		case REMOTE:
+			RemoteLog(ErrorLevel, "msg", msg, "op", op, "err", err)
+		case CALLBACK:
+			if callback != nil {
+				callback(op, err)
 			}
 		}
-		return
 	}
-	if errHandles != nil {
-		errHandles = append(errHandles, LOCAL)
+}
+
+// report handle err when it is not nil and return it
+func (r *RabbitMQ) report(op string, err error) error {
+	if err != nil {
+		r.failOnErr(op, err)
 	}
+	return err
 }
 
+// SetRabbitMqErrLogHandle how the errors of the instance(declare, publish, consume, ack) are handled,
+// it takes precedence over the global setting
 func (r *RabbitMQ) SetRabbitMqErrLogHandle(v ...int) {
+	r.errOptMux.Lock()
+	defer r.errOptMux.Unlock()
 	r.errHandles = v
 }
 
+// OnError the handler of CALLBACK for the instance, op is the operation that failed, e.g. publish.
+// Calls are serialized per instance, fn must not call methods of the same instance
+// that may fail, otherwise it deadlocks.
+func (r *RabbitMQ) OnError(fn func(op string, err error)) {
+	r.errOptMux.Lock()
+	defer r.errOptMux.Unlock()
+	r.errCallback = fn
+}
+
+// Ack acknowledge d, the error is handled by the error setting of the instance
+func (r *RabbitMQ) Ack(d amqp.Delivery, multiple bool) error {
+	return r.report("ack", d.Ack(multiple))
+}
+
+// Nack negatively acknowledge d, the error is handled by the error setting of the instance
+func (r *RabbitMQ) Nack(d amqp.Delivery, multiple, requeue bool) error {
+	return r.report("nack", d.Nack(multiple, requeue))
+}
+
+// Err the first error of the chained declarations, the following declarations are skipped
+// and the terminal Publish*/Consume*/Receive* call returns it
+func (r *RabbitMQ) Err() error {
+	return r.err
+}
+
+// setErr keep the first error of the chain
+func (r *RabbitMQ) setErr(op string, err error) {
+	if err == nil {
+		return
+	}
+	r.failOnErr(op, err)
+	if r.err == nil {
+		r.err = err
+	}
+}
+
 func (r *RabbitMQ) QueueDeclare(name string, durable bool, autoDelete bool, exclusive bool, noWait bool, args amqp.Table) *RabbitMQ {
-	r.Queue, _ = r.channel.QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+	if r.err != nil {
+		return r
+	}
+	var err error
+	r.Queue, err = r.channel.QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+	r.setErr("queue_declare", err)
 	return r
 }
 
 func (r *RabbitMQ) DefQueueDeclare(name string, durable, autoDel bool) *RabbitMQ {
-	r.Queue, r.err = r.channel.QueueDeclare(name, durable, autoDel, false, false, nil)
+	if r.err != nil {
+		return r
+	}
+	var err error
+	r.Queue, err = r.channel.QueueDeclare(name, durable, autoDel, false, false, nil)
+	r.setErr("queue_declare", err)
 	return r
 }
 
+// ExchangeDeclare an invalid kind is not sent to the broker, like the other errors it is returned by the terminal call
 func (r *RabbitMQ) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) *RabbitMQ {
+	if r.err != nil || !r.validKind(kind) {
+		return r
+	}
 	err := r.channel.ExchangeDeclare(
 		name,
 		kind,
@@ -130,15 +290,17 @@ func (r *RabbitMQ) ExchangeDeclare(name, kind string, durable, autoDelete, inter
 		noWait,
 		args,
 	)
-	if err != nil {
-		r.failOnErr("ExchangeDeclare fail", err)
-	}
+	r.setErr("exchange_declare", err)
 
 	r.ExchangeName = name
 	return r
 }
 
+// DefExchangeDeclare an invalid kind is not sent to the broker, like the other errors it is returned by the terminal call
 func (r *RabbitMQ) DefExchangeDeclare(name, kind string, durable, autoDel bool) *RabbitMQ {
+	if r.err != nil || !r.validKind(kind) {
+		return r
+	}
 	err := r.channel.ExchangeDeclare(
 		name,
 		kind,
@@ -148,41 +310,55 @@ func (r *RabbitMQ) DefExchangeDeclare(name, kind string, durable, autoDel bool)
 		false,
 		nil,
 	)
-	if err != nil {
-		r.failOnErr("ExchangeDeclare fail", err)
-	}
+	r.setErr("exchange_declare", err)
 
 	r.ExchangeName = name
 	return r
 }
 
+func (r *RabbitMQ) validKind(kind string) bool {
+	err := checkExchangeKind(kind)
+	r.setErr("exchange_declare", err)
+	return err == nil
+}
+
 func (r *RabbitMQ) Consume(v []ConsumeConfig) (<-chan amqp.Delivery, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
 
+	var conf ConsumeConfig
 	if len(v) > 0 {
-		conf := v[0]
-		return r.channel.Consume(
-			r.Queue.Name,
-			conf.Consumer,
-			conf.AutoAck,
-			conf.Exclusive,
-			conf.NoLocal,
-			conf.NoWait,
-			conf.Args,
-		)
-	}
-
-	return r.channel.Consume(
+		conf = v[0]
+	}
+	if err := r.qos(conf); err != nil {
+		return nil, err
+	}
+	return r.reportConsume(r.channel.Consume(
 		r.Queue.Name,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
+		conf.Consumer,
+		conf.autoAck(),
+		conf.Exclusive,
+		conf.NoLocal,
+		conf.NoWait,
+		conf.Args,
+	))
+}
+
+// qos limit the unacknowledged deliveries of the manual modes to PrefetchCount
+func (r *RabbitMQ) qos(conf ConsumeConfig) error {
+	if conf.autoAck() {
+		return nil
+	}
+	n := conf.PrefetchCount
+	if n <= 0 {
+		n = defaultPrefetchCount
+	}
+	return r.report("qos", r.channel.Qos(n, 0, false))
+}
+
+func (r *RabbitMQ) reportConsume(msgs <-chan amqp.Delivery, err error) (<-chan amqp.Delivery, error) {
+	return msgs, r.report("consume", err)
 }
 
 func NewRabbitMQ(mqUrl ...string) (*RabbitMQ, error) {
@@ -193,17 +369,23 @@ func NewRabbitMQ(mqUrl ...string) (*RabbitMQ, error) {
 	if len(mqUrl) > 0 && mqUrl[0] != "" {
 		url = mqUrl[0]
 	}
-	rabbitmq := &RabbitMQ{MqURL: url}
+	return newRabbitMQ(url, nil)
+}
+
+// newRabbitMQ the errors while connecting are already handled by errHandles(nil uses the global setting)
+func newRabbitMQ(url string, errHandles []int) (*RabbitMQ, error) {
+	rabbitmq := &RabbitMQ{MqURL: url, errHandles: errHandles}
 	var err error
 	rabbitmq.conn, err = amqp.Dial(rabbitmq.MqURL)
 	if err != nil {
-		rabbitmq.failOnErr("rabbitMq Dial an error occurred", err)
+		rabbitmq.failOnErr("dial", err)
 		return nil, err
 	}
 
 	rabbitmq.channel, err = rabbitmq.conn.Channel()
 	if err != nil {
-		rabbitmq.failOnErr("rabbitmq.conn.Channel() an error occurred", err)
+		rabbitmq.failOnErr("channel", err)
+		_ = rabbitmq.conn.Close()
 		return nil, err
 	}
 	return rabbitmq, nil
@@ -217,7 +399,7 @@ func (r *RabbitMQ) PublishSimple(message string) error {
 		return errors.New("please first declare queue")
 	}
 
-	return r.channel.Publish(
+	return r.report("publish", r.channel.Publish(
 		"",
 		r.Queue.Name,
 		false,
@@ -225,10 +407,15 @@ func (r *RabbitMQ) PublishSimple(message string) error {
 		amqp.Publishing{
 			ContentType: "text/plain",
 			Body:        []byte(message),
-		})
+		}))
 }
 
 func (r *RabbitMQ) ConsumeSimple(v ...ConsumeConfig) (<-chan amqp.Delivery, error) {
+	return deliveries(r.ConsumeSimpleCtx(context.Background(), v...))
+}
+
+// ConsumeSimpleCtx like ConsumeSimple, the consumer is canceled when ctx is done
+func (r *RabbitMQ) ConsumeSimpleCtx(ctx context.Context, v ...ConsumeConfig) (*RabbitConsumer, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -236,7 +423,7 @@ func (r *RabbitMQ) ConsumeSimple(v ...ConsumeConfig) (<-chan amqp.Delivery, erro
 		return nil, errors.New("please first declare queue")
 	}
 
-	return r.Consume(v)
+	return r.consumeCtx(ctx, v)
 }
 
 func (r *RabbitMQ) Publish(message, key string) error {
@@ -247,7 +434,7 @@ func (r *RabbitMQ) Publish(message, key string) error {
 		return errors.New("please first declare exchange")
 	}
 
-	return r.channel.Publish(
+	return r.report("publish", r.channel.Publish(
 		r.ExchangeName,
 		key,
 		false,
@@ -255,7 +442,7 @@ func (r *RabbitMQ) Publish(message, key string) error {
 		amqp.Publishing{
 			ContentType: "text/plain",
 			Body:        []byte(message),
-		})
+		}))
 }
 
 type PublishOption struct {
@@ -265,6 +452,100 @@ type PublishOption struct {
 	Msg       amqp.Publishing
 }
 
+// PublishOptions properties of a message published by PublishMsg
+type PublishOptions struct {
+	Headers     amqp.Table
+	ContentType string
+	// amqp.Transient(default) or amqp.Persistent
+	DeliveryMode uint8
+	// Message TTL, 0 means no expiration
+	Expiration time.Duration
+	MessageId  string
+	// Set the timestamp of the message to the current time
+	Timestamp bool
+}
+
+type PublishOpt func(*PublishOptions)
+
+func PublishHeaders(headers amqp.Table) PublishOpt {
+	return func(o *PublishOptions) {
+		o.Headers = headers
+	}
+}
+
+// PublishContentType default text/plain
+func PublishContentType(contentType string) PublishOpt {
+	return func(o *PublishOptions) {
+		o.ContentType = contentType
+	}
+}
+
+func PublishDeliveryMode(mode uint8) PublishOpt {
+	return func(o *PublishOptions) {
+		o.DeliveryMode = mode
+	}
+}
+
+func PublishExpiration(ttl time.Duration) PublishOpt {
+	return func(o *PublishOptions) {
+		o.Expiration = ttl
+	}
+}
+
+func PublishMessageId(id string) PublishOpt {
+	return func(o *PublishOptions) {
+		o.MessageId = id
+	}
+}
+
+func PublishTimestamp() PublishOpt {
+	return func(o *PublishOptions) {
+		o.Timestamp = true
+	}
+}
+
+// PublishMsg publish to the declared exchange(the default exchange when not declared) with the routing key,
+// the options are merged over the defaults(text/plain, transient).
+func (r *RabbitMQ) PublishMsg(msg []byte, key string, opts ...PublishOpt) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	o := PublishOptions{
+		ContentType:  "text/plain",
+		DeliveryMode: amqp.Transient,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	p := amqp.Publishing{
+		Headers:      o.Headers,
+		ContentType:  o.ContentType,
+		DeliveryMode: o.DeliveryMode,
+		MessageId:    o.MessageId,
+		Body:         msg,
+	}
+	if o.Expiration > 0 {
+		p.Expiration = strconv.FormatInt(o.Expiration.Milliseconds(), 10)
+	}
+	if o.Timestamp {
+		p.Timestamp = time.Now()
+	}
+	return r.report("publish", r.channel.Publish(r.ExchangeName, key, false, false, p))
+}
+
+// PublishHeaders publish to the declared KIND_HEADERS exchange, the queues are matched by headers
+func (r *RabbitMQ) PublishHeaders(message []byte, headers amqp.Table, opts ...PublishOpt) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.ExchangeName) == 0 {
+		return errors.New("please first declare exchange")
+	}
+	return r.PublishMsg(message, "", append(opts, PublishHeaders(headers))...)
+}
+
 func (r *RabbitMQ) Pub(key string, mandatory, immediate bool, msg amqp.Publishing) error {
 	if r.err != nil {
 		return r.err
@@ -273,7 +554,7 @@ func (r *RabbitMQ) Pub(key string, mandatory, immediate bool, msg amqp.Publishin
 		return errors.New("please first declare exchange")
 	}
 
-	return r.channel.Publish(r.ExchangeName, key, mandatory, immediate, msg)
+	return r.report("publish", r.channel.Publish(r.ExchangeName, key, mandatory, immediate, msg))
 }
 
 func (r *RabbitMQ) pub(message string, opts ...PublishOption) error {
@@ -297,7 +578,7 @@ func (r *RabbitMQ) pub(message string, opts ...PublishOption) error {
 		opt = opts[0]
 	}
 
-	return r.channel.Publish(r.ExchangeName, opt.Key, opt.Mandatory, opt.Immediate, opt.Msg)
+	return r.report("publish", r.channel.Publish(r.ExchangeName, opt.Key, opt.Mandatory, opt.Immediate, opt.Msg))
 }
 
 func (r *RabbitMQ) PublishPub(message string, opts ...PublishOption) error {
@@ -342,6 +623,11 @@ func (r *RabbitMQ) PublishTopic(message, key string, opts ...PublishOption) erro
 }
 
 func (r *RabbitMQ) ReceiveSub(v ...ConsumeConfig) (<-chan amqp.Delivery, error) {
+	return deliveries(r.ReceiveSubCtx(context.Background(), v...))
+}
+
+// ReceiveSubCtx like ReceiveSub, the consumer is canceled when ctx is done
+func (r *RabbitMQ) ReceiveSubCtx(ctx context.Context, v ...ConsumeConfig) (*RabbitConsumer, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -359,13 +645,18 @@ func (r *RabbitMQ) ReceiveSub(v ...ConsumeConfig) (<-chan amqp.Delivery, error)
 		false,
 		nil)
 	if err != nil {
-		return nil, err
+		return nil, r.report("queue_bind", err)
 	}
 
-	return r.Consume(v)
+	return r.consumeCtx(ctx, v)
 }
 
 func (r *RabbitMQ) ReceiveRouting(key string, v ...ConsumeConfig) (<-chan amqp.Delivery, error) {
+	return deliveries(r.ReceiveRoutingCtx(context.Background(), key, v...))
+}
+
+// ReceiveRoutingCtx like ReceiveRouting, the consumer is canceled when ctx is done
+func (r *RabbitMQ) ReceiveRoutingCtx(ctx context.Context, key string, v ...ConsumeConfig) (*RabbitConsumer, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -380,13 +671,18 @@ func (r *RabbitMQ) ReceiveRouting(key string, v ...ConsumeConfig) (<-chan amqp.D
 		false,
 		nil)
 	if err != nil {
-		return nil, err
+		return nil, r.report("queue_bind", err)
 	}
 
-	return r.Consume(v)
+	return r.consumeCtx(ctx, v)
 }
 
 func (r *RabbitMQ) ReceiveTopic(key string, v ...ConsumeConfig) (<-chan amqp.Delivery, error) {
+	return deliveries(r.ReceiveTopicCtx(context.Background(), key, v...))
+}
+
+// ReceiveTopicCtx like ReceiveTopic, the consumer is canceled when ctx is done
+func (r *RabbitMQ) ReceiveTopicCtx(ctx context.Context, key string, v ...ConsumeConfig) (*RabbitConsumer, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -403,9 +699,222 @@ func (r *RabbitMQ) ReceiveTopic(key string, v ...ConsumeConfig) (<-chan amqp.Del
 		r.ExchangeName,
 		false,
 		nil)
+	if err != nil {
+		return nil, r.report("queue_bind", err)
+	}
+
+	return r.consumeCtx(ctx, v)
+}
+
+// ErrConsumerCanceled the consumer was canceled by RabbitConsumer.Cancel or its context
+var ErrConsumerCanceled = errors.New("rabbitmq consumer canceled")
+
+// RabbitConsumer handle of a consumer created by the *Ctx methods
+type RabbitConsumer struct {
+	tag        string
+	channel    *amqp.Channel
+	deliveries <-chan amqp.Delivery
+	cancel     context.CancelFunc
+	done       chan struct{}
+	mux        sync.Mutex
+	err        error
+}
+
+// Deliveries the messages, closed when the consumer is canceled or the channel is closed
+func (c *RabbitConsumer) Deliveries() <-chan amqp.Delivery {
+	return c.deliveries
+}
+
+// Tag the consumer tag
+func (c *RabbitConsumer) Tag() string {
+	return c.tag
+}
+
+// Cancel basic-cancel the consumer and wait until the broker confirms it
+func (c *RabbitConsumer) Cancel() {
+	c.cancel()
+	<-c.done
+}
+
+// Err nil while consuming, ErrConsumerCanceled after a graceful cancellation,
+// the *amqp.Error(or amqp.ErrClosed) when the channel was closed by the broker or by Close.
+func (c *RabbitConsumer) Err() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.err
+}
+
+func (c *RabbitConsumer) setErr(err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.err = err
+}
+
+func (c *RabbitConsumer) watch(ctx context.Context, closed chan *amqp.Error) {
+	defer close(c.done)
+	select {
+	case <-ctx.Done():
+		if err := c.channel.Cancel(c.tag, false); err != nil {
+			c.setErr(err)
+			return
+		}
+		c.setErr(ErrConsumerCanceled)
+	case err, ok := <-closed:
+		if ok && err != nil {
+			c.setErr(err)
+			return
+		}
+		c.setErr(amqp.ErrClosed)
+	}
+}
+
+func (r *RabbitMQ) consumeCtx(ctx context.Context, v []ConsumeConfig) (*RabbitConsumer, error) {
+	var conf ConsumeConfig
+	if len(v) > 0 {
+		conf = v[0]
+	}
+	if conf.Consumer == "" {
+		conf.Consumer = "fit-" + NewULID()
+	}
+
+	if err := r.qos(conf); err != nil {
+		return nil, err
+	}
+	closed := r.channel.NotifyClose(make(chan *amqp.Error, 1))
+	msgs, err := r.channel.Consume(
+		r.Queue.Name,
+		conf.Consumer,
+		conf.autoAck(),
+		conf.Exclusive,
+		conf.NoLocal,
+		conf.NoWait,
+		conf.Args,
+	)
+	if err != nil {
+		return nil, r.report("consume", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := &RabbitConsumer{
+		tag:        conf.Consumer,
+		channel:    r.channel,
+		deliveries: msgs,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go c.watch(ctx, closed)
+	return c, nil
+}
+
+func deliveries(c *RabbitConsumer, err error) (<-chan amqp.Delivery, error) {
 	if err != nil {
 		return nil, err
 	}
+	return c.Deliveries(), nil
+}
 
-	return r.Consume(v)
+// ConsumeWithHandler consume the declared queue with handler until ctx is done or the channel is closed.
+// With the manual modes the delivery is acknowledged when handler returns nil and nacked otherwise(see RequeueOnError),
+// a panic is recovered and the delivery nacked, it is requeued with ManualAckWithRequeueOnPanic.
+// nil is returned when ctx is done, otherwise the error of the consumer(see RabbitConsumer.Err).
+func (r *RabbitMQ) ConsumeWithHandler(ctx context.Context, handler func(ctx context.Context, d amqp.Delivery) error, v ...ConsumeConfig) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.Queue.Name) == 0 {
+		return errors.New("please first declare queue")
+	}
+	var conf ConsumeConfig
+	if len(v) > 0 {
+		conf = v[0]
+	}
+	consumer, err := r.consumeCtx(ctx, []ConsumeConfig{conf})
+	if err != nil {
+		return err
+	}
+
+	n := conf.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	var unacked int64
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range consumer.Deliveries() {
+				atomic.AddInt64(&unacked, 1)
+				r.handleDelivery(ctx, conf, handler, d)
+				atomic.AddInt64(&unacked, -1)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	if conf.UnackedWarnCount > 0 && conf.UnackedWarnAfter > 0 && !conf.autoAck() {
+		go watchUnacked(consumer.Tag(), r.Queue.Name, &unacked, conf, stop)
+	}
+	wg.Wait()
+	close(stop)
+
+	// the deliveries are also closed when the broker cancels the consumer
+	consumer.Cancel()
+	if err := consumer.Err(); err != nil && !errors.Is(err, ErrConsumerCanceled) {
+		return err
+	}
+	return nil
+}
+
+func (r *RabbitMQ) handleDelivery(ctx context.Context, conf ConsumeConfig, handler func(ctx context.Context, d amqp.Delivery) error, d amqp.Delivery) {
+	manual := !conf.autoAck()
+	defer func() {
+		if p := recover(); p != nil {
+			r.failOnErr("handler", fmt.Errorf("panic: %v\n%s", p, debug.Stack()))
+			if manual {
+				_ = r.Nack(d, false, conf.AckMode == ManualAckWithRequeueOnPanic)
+			}
+		}
+	}()
+
+	err := handler(ctx, d)
+	if !manual {
+		return
+	}
+	if err != nil {
+		_ = r.Nack(d, false, conf.RequeueOnError)
+		return
+	}
+	_ = r.Ack(d, false)
+}
+
+// watchUnacked warn when more than UnackedWarnCount deliveries stay unacknowledged for UnackedWarnAfter
+func watchUnacked(tag, queue string, unacked *int64, conf ConsumeConfig, stop chan struct{}) {
+	interval := conf.UnackedWarnAfter / 4
+	if interval < time.Millisecond*100 {
+		interval = time.Millisecond * 100
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var since time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		n := atomic.LoadInt64(unacked)
+		if n <= int64(conf.UnackedWarnCount) {
+			since = time.Time{}
+			continue
+		}
+		if since.IsZero() {
+			since = time.Now()
+			continue
+		}
+		if held := time.Since(since); held >= conf.UnackedWarnAfter {
+			Warning("msg", "rabbitmq consumer holds too many unacknowledged deliveries", "consumer", tag, "queue", queue, "unacked", n, "held", held.String())
+			since = time.Now()
+		}
+	}
 }
@@ -3,9 +3,15 @@ package fit
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -16,44 +22,160 @@ const (
 var rClient *redis.Client
 var rClusterClient *redis.ClusterClient
 
+type redisTraceStartKey struct{}
+
+var redisTraceArgLength = 256
+
+var (
+	redisSlowThreshold time.Duration
+	redisSlowLogName   string
+)
+
+// SetRedisSlowLog commands(pipelines included) taking longer than threshold are written to the local log instance
+// with the command, key, cost and caller, and marked as slow in the trace. threshold <= 0 disables it.
+func SetRedisSlowLog(threshold time.Duration, logInstanceName string) {
+	redisSlowThreshold = threshold
+	redisSlowLogName = logInstanceName
+}
+
+// SetRedisTraceArgLength set the maximum length of a single argument recorded in the trace, default 256.
+// It takes effect on the hooks installed after the call.
+func SetRedisTraceArgLength(length int) {
+	if length <= 0 {
+		return
+	}
+	redisTraceArgLength = length
+}
+
+// RedisClientHook records the executed commands into the Trace carried by the context.
+// Pipelines record one entry per command.
 type RedisClientHook struct {
+	// MaxArgLength maximum length of a single string argument, 0 means no truncation
+	MaxArgLength int
+}
+
+// NewRedisTraceHook create a redis hook for link tracing,
+// installed automatically by NewRedisDefConnect/NewRedisConnect/NewRedisDefConnectCluster/NewRedisConnectCluster.
+func NewRedisTraceHook(maxArgLength ...int) *RedisClientHook {
+	l := redisTraceArgLength
+	if len(maxArgLength) > 0 {
+		l = maxArgLength[0]
+	}
+	return &RedisClientHook{MaxArgLength: l}
 }
 
 func (r RedisClientHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
-	return context.WithValue(ctx, "startTime", time.Now()), nil
+	return r.start(ctx), nil
 }
 
 func (r RedisClientHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
-	traceCtx := ctx.Value(GetTraceCtxName())
-	if traceCtx == nil {
-		return nil
+	r.record(ctx, cmd)
+	return nil
+}
+
+func (r RedisClientHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return r.start(ctx), nil
+}
+
+func (r RedisClientHook) start(ctx context.Context) context.Context {
+	if _, ok := GetTraceCtx(ctx); !ok && redisSlowThreshold <= 0 {
+		return ctx
 	}
+	return context.WithValue(ctx, redisTraceStartKey{}, time.Now())
+}
 
-	trace, ok := ToTrace(traceCtx)
+func (r RedisClientHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	r.record(ctx, cmds...)
+	return nil
+}
+
+func (r RedisClientHook) record(ctx context.Context, cmds ...redis.Cmder) {
+	st, ok := ctx.Value(redisTraceStartKey{}).(time.Time)
 	if !ok {
-		return nil
+		return
+	}
+
+	// a pipeline is timed as a whole, every command of it gets the cost of the pipeline
+	elapsed := time.Since(st)
+	slow := redisSlowThreshold > 0 && elapsed >= redisSlowThreshold
+	if slow {
+		logRedisSlow(elapsed, cmds)
 	}
 
-	st, ok := ctx.Value("startTime").(time.Time)
+	trace, ok := GetTraceCtx(ctx)
 	if !ok {
-		return nil
+		return
+	}
+	cost := elapsed.String()
+	for _, cmd := range cmds {
+		trace.AppendRedis(&LinkTraceRedis{
+			Timestamp: GetTimeStr(st),
+			Handle:    cmd.Name(),
+			Args:      r.truncateArgs(cmd.Args()),
+			StartMs:   st.UnixMilli(),
+			EndMs:     st.Add(elapsed).UnixMilli(),
+			CostMs:    DurationMs(elapsed),
+			Cost:      cost,
+			Slow:      slow,
+		})
 	}
+}
 
-	trace.AppendRedis(&LinkTraceRedis{
-		Timestamp: GetTimeStr(st),
-		Handle:    cmd.Name(),
-		Args:      cmd.Args(),
-		Cost:      time.Since(st).String(),
-	})
-	return nil
+func logRedisSlow(elapsed time.Duration, cmds []redis.Cmder) {
+	caller := reportCaller{join: redisCaller()}
+	for _, cmd := range cmds {
+		body := H{
+			"msg":  "redis slow command",
+			"cmd":  cmd.Name(),
+			"cost": elapsed.String(),
+		}
+		if args := cmd.Args(); len(args) > 1 {
+			body["key"] = fmt.Sprint(args[1])
+		}
+		if len(cmds) > 1 {
+			body["pipeline"] = len(cmds)
+		}
+		writeLocalLogInstance(redisSlowLogName, WarnLevel, body, caller)
+	}
 }
 
-func (r RedisClientHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
-	return ctx, nil
+// redisCaller the first frame outside go-redis and this package
+func redisCaller() string {
+	pc := make([]uintptr, 32)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/go-redis/") &&
+			!strings.HasPrefix(frame.Function, "github.com/source-build/go-fit.") {
+			_, fileName := filepath.Split(frame.File)
+			return StringSpliceTag(":", fileName, strconv.Itoa(frame.Line))
+		}
+		if !more {
+			return ""
+		}
+	}
 }
 
-func (r RedisClientHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
-	return nil
+func (r RedisClientHook) truncateArgs(args []interface{}) []interface{} {
+	if r.MaxArgLength <= 0 {
+		return args
+	}
+	result := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			if utf8.RuneCountInString(v) > r.MaxArgLength {
+				arg = SubStrDecodeRuneInString(v, r.MaxArgLength)
+			}
+		case []byte:
+			if len(v) > r.MaxArgLength {
+				arg = SubStrDecodeRuneInString(string(v), r.MaxArgLength)
+			}
+		}
+		result[i] = arg
+	}
+	return result
 }
 
 func notFindInstance() (string, error) {
@@ -95,7 +217,7 @@ func NewRedisDefConnect(addr, username, password string, db int) error {
 		return err
 	}
 
-	rdb.AddHook(new(RedisClientHook))
+	rdb.AddHook(NewRedisTraceHook())
 	rClient = rdb
 	return nil
 }
@@ -109,7 +231,23 @@ func NewRedisConnect(config redis.Options) error {
 		return err
 	}
 
-	rdb.AddHook(new(RedisClientHook))
+	rdb.AddHook(NewRedisTraceHook())
+	rClient = rdb
+	return nil
+}
+
+// NewRedisConnectFailover connect to the master found by redis sentinel, failover is followed automatically.
+// The client is used as the single node one(GetNode).
+func NewRedisConnectFailover(config redis.FailoverOptions) error {
+	rdb := redis.NewFailoverClient(&config)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	_, err := rdb.Ping(ctx).Result()
+	if err != nil {
+		return err
+	}
+
+	rdb.AddHook(NewRedisTraceHook())
 	rClient = rdb
 	return nil
 }
@@ -176,7 +314,7 @@ func NewRedisDefConnectCluster(addr []string, username, password string) error {
 	if err != nil {
 		return err
 	}
-	db.AddHook(new(RedisClientHook))
+	db.AddHook(NewRedisTraceHook())
 	rClusterClient = db
 	return nil
 }
@@ -189,7 +327,7 @@ func NewRedisConnectCluster(config redis.ClusterOptions) error {
 	if err != nil {
 		return err
 	}
-	db.AddHook(new(RedisClientHook))
+	db.AddHook(NewRedisTraceHook())
 	rClusterClient = db
 	return nil
 }
@@ -261,6 +399,13 @@ func (r *RedisOption) GetCluster() *redis.ClusterClient {
 	return rClusterClient
 }
 
+// GetUniversal the connected client whether single node or cluster, nil when not connected.
+// The same connection(and hooks) as GetNode/GetCluster is used.
+func (r *RedisOption) GetUniversal() redis.UniversalClient {
+	rdb, _ := redisUniversalClient()
+	return rdb
+}
+
 func (r *RedisOption) Set(key string, value interface{}) (string, error) {
 	if r.ctx == nil {
 		r.ctx = context.Background()
@@ -0,0 +1,210 @@
+package fit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"math/rand"
+	"time"
+)
+
+// ErrCacheNotFound returned by the loader when the data does not exist,
+// it is cached for NegativeTTL when the option is set.
+var ErrCacheNotFound = errors.New("cache: not found")
+
+// ErrCacheTypeMismatch the same key was loaded concurrently with another type parameter
+var ErrCacheTypeMismatch = errors.New("cache: type mismatch")
+
+const cacheNotFoundMarker = "__FIT_CACHE_NOT_FOUND__"
+
+var cacheGroup singleflight.Group
+
+// CacheCodec encode the cached value, JSONCodec is used by default.
+// Other formats(e.g. msgpack) can be plugged in by implementing this interface.
+type CacheCodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type cacheConfig struct {
+	negativeTTL time.Duration
+	jitter      time.Duration
+	codec       CacheCodec
+	loadTimeout time.Duration
+}
+
+type CacheOption func(*cacheConfig)
+
+// CacheNegativeTTL cache ErrCacheNotFound for the given time
+func CacheNegativeTTL(t time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.negativeTTL = t
+	}
+}
+
+// CacheTTLJitter add a random duration in [0, max) to the ttl to avoid keys expiring together
+func CacheTTLJitter(max time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.jitter = max
+	}
+}
+
+// CacheLoadTimeout timeout of the loader, default 10s. The loader is shared by the concurrent callers,
+// it does not stop when the caller who started it gives up.
+func CacheLoadTimeout(t time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.loadTimeout = t
+	}
+}
+
+// CacheWithCodec replace the default JSON codec
+func CacheWithCodec(codec CacheCodec) CacheOption {
+	return func(c *cacheConfig) {
+		c.codec = codec
+	}
+}
+
+// CacheKey build a namespaced key, format: namespace:part1:part2
+func CacheKey(namespace string, parts ...string) string {
+	return StringSpliceTag(":", append([]string{namespace}, parts...)...)
+}
+
+// CacheGet read the key from redis, on a miss the loader is called and the result is cached for ttl.
+// Concurrent misses of the same key share a single loader call, it runs with the values of ctx but not
+// its cancellation(see CacheLoadTimeout), every caller waits until its own ctx is done.
+//
+// Please call NewRedisDefConnect or NewRedisDefConnectCluster before calling this function
+func CacheGet[T any](ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), opts ...CacheOption) (T, error) {
+	var zero T
+	config := &cacheConfig{codec: JSONCodec{}}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.loadTimeout <= 0 {
+		config.loadTimeout = time.Second * 10
+	}
+
+	rdb, err := redisUniversalClient()
+	if err != nil {
+		return zero, err
+	}
+
+	if value, ok, err := cacheRead[T](ctx, rdb, key, config); ok || err != nil {
+		return value, err
+	}
+
+	flight := cacheGroup.DoChan(key, func() (interface{}, error) {
+		// the loader outlives the caller who started it, a cancelled caller must not fail the others
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, config.loadTimeout)
+		defer cancel()
+
+		// another caller may have filled the key
+		if value, ok, err := cacheRead[T](ctx, rdb, key, config); ok || err != nil {
+			return value, err
+		}
+
+		value, err := loader(ctx)
+		if errors.Is(err, ErrCacheNotFound) {
+			if config.negativeTTL > 0 {
+				_ = rdb.Set(ctx, key, cacheNotFoundMarker, config.negativeTTL).Err()
+			}
+			return value, err
+		}
+		if err != nil {
+			return value, err
+		}
+
+		data, err := config.codec.Marshal(value)
+		if err != nil {
+			return value, err
+		}
+		if config.jitter > 0 {
+			ttl += time.Duration(rand.Int63n(int64(config.jitter)))
+		}
+		if err := rdb.Set(ctx, key, data, ttl).Err(); err != nil {
+			Error("msg", "cache set failed", "key", key, "err", err)
+		}
+		return value, nil
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-flight:
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	if result.Val == nil {
+		return zero, result.Err
+	}
+	value, ok := result.Val.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: key %s loaded as %T, not %T", ErrCacheTypeMismatch, key, result.Val, zero)
+	}
+	return value, result.Err
+}
+
+// detachedContext keep the values of the parent without its deadline and cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// cacheRead ok is false on a cache miss, err is only ErrCacheNotFound
+func cacheRead[T any](ctx context.Context, rdb redis.UniversalClient, key string, config *cacheConfig) (value T, ok bool, err error) {
+	data, err := rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return value, false, nil
+	}
+	if err != nil {
+		// redis is unavailable, fall back to the loader
+		Error("msg", "cache get failed", "key", key, "err", err)
+		return value, false, nil
+	}
+	if string(data) == cacheNotFoundMarker {
+		return value, true, ErrCacheNotFound
+	}
+	if err := config.codec.Unmarshal(data, &value); err != nil {
+		// treat undecodable data as a miss so that it is reloaded
+		return value, false, nil
+	}
+	return value, true, nil
+}
+
+// CacheDelete delete the cached keys
+func CacheDelete(ctx context.Context, keys ...string) error {
+	rdb, err := redisUniversalClient()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		// keys may be located in different slots of the cluster
+		if err := rdb.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type cachedUser struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestCacheGet(t *testing.T) {
+	useMiniRedis(t)
+	ctx := context.Background()
+
+	var calls int32
+	loader := func(ctx context.Context) (cachedUser, error) {
+		atomic.AddInt32(&calls, 1)
+		return cachedUser{Id: 1, Name: "fit"}, nil
+	}
+	for i := 0; i < 2; i++ {
+		user, err := CacheGet(ctx, CacheKey("user", "1"), time.Minute, loader)
+		if err != nil || user.Name != "fit" {
+			t.Fatalf("CacheGet = %+v, %v", user, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("loader calls = %d, want 1", calls)
+	}
+
+	_, err := CacheGet(ctx, CacheKey("user", "2"), time.Minute, func(ctx context.Context) (cachedUser, error) {
+		return cachedUser{}, ErrCacheNotFound
+	}, CacheNegativeTTL(time.Minute))
+	if !errors.Is(err, ErrCacheNotFound) {
+		t.Fatalf("err = %v, want ErrCacheNotFound", err)
+	}
+}
+
+func TestCacheGetCancelledCallerDoesNotFailWaiters(t *testing.T) {
+	useMiniRedis(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	loader := func(ctx context.Context) (cachedUser, error) {
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return cachedUser{}, ctx.Err()
+		}
+		return cachedUser{Id: 1, Name: "fit"}, nil
+	}
+
+	// the first caller starts the loader and gives up
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := CacheGet(first, "user:shared", time.Minute, loader)
+		firstErr <- err
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	results := make([]cachedUser, 5)
+	errs := make([]error, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = CacheGet(context.Background(), "user:shared", time.Minute, loader)
+		}(i)
+	}
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller err = %v, want context.Canceled", err)
+	}
+	close(release)
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil || results[i].Name != "fit" {
+			t.Fatalf("waiter %d = %+v, %v", i, results[i], errs[i])
+		}
+	}
+}
+
+func TestCacheGetTypeMismatch(t *testing.T) {
+	useMiniRedis(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = CacheGet(context.Background(), "user:typed", time.Minute, func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+	}()
+	<-started
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := CacheGet(context.Background(), "user:typed", time.Minute, func(ctx context.Context) (string, error) {
+			return "1", nil
+		})
+		errc <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	<-done
+
+	if err := <-errc; !errors.Is(err, ErrCacheTypeMismatch) {
+		t.Fatalf("err = %v, want ErrCacheTypeMismatch", err)
+	}
+}
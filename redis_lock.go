@@ -0,0 +1,217 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// ErrLockNotHeld the lock has expired or is held by someone else
+var ErrLockNotHeld = errors.New("redis lock is not held")
+
+// ErrLockAlreadyHeld Acquire was called on a lock this RedisLock already holds, Release it first
+var ErrLockAlreadyHeld = errors.New("redis lock is already held")
+
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+var refreshLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// RedisLock distributed lock based on SET NX PX,
+// release and refresh compare the random token so that only the holder can change the lock.
+type RedisLock struct {
+	mux           sync.Mutex
+	key           string
+	ttl           time.Duration
+	token         string
+	retryInterval time.Duration
+	watchdog      bool
+	stopWatchdog  chan struct{}
+}
+
+type LockOption func(*RedisLock)
+
+// LockRetry Acquire keeps retrying at the given interval until the context is done
+func LockRetry(interval time.Duration) LockOption {
+	return func(l *RedisLock) {
+		l.retryInterval = interval
+	}
+}
+
+// LockWatchdog extend the ttl in the background(every ttl/3) while the lock is held
+func LockWatchdog() LockOption {
+	return func(l *RedisLock) {
+		l.watchdog = true
+	}
+}
+
+// NewRedisLock create a lock, works with both the single node and the cluster client.
+//
+// Please call NewRedisDefConnect or NewRedisDefConnectCluster before calling this function
+func NewRedisLock(key string, ttl time.Duration, opts ...LockOption) *RedisLock {
+	l := &RedisLock{key: key, ttl: ttl}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func redisUniversalClient() (redis.UniversalClient, error) {
+	switch testClient() {
+	case Client:
+		return rClient, nil
+	case Cluster:
+		return rClusterClient, nil
+	}
+	return nil, errors.New("not find redis instance")
+}
+
+// Acquire try to get the lock, returns false if it is held by someone else.
+// With LockRetry ctx.Err() is returned when the context is done before the lock is acquired,
+// ErrLockAlreadyHeld is returned when this RedisLock already holds the lock.
+func (l *RedisLock) Acquire(ctx context.Context) (bool, error) {
+	if l.held() {
+		return false, ErrLockAlreadyHeld
+	}
+	rdb, err := redisUniversalClient()
+	if err != nil {
+		return false, err
+	}
+
+	token := uuid.New().String()
+	for {
+		ok, err := rdb.SetNX(ctx, l.key, token, l.ttl).Result()
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			l.mux.Lock()
+			if l.token != "" {
+				// acquired concurrently through this RedisLock, keep the first one
+				l.mux.Unlock()
+				_, _ = releaseLockScript.Run(ctx, rdb, []string{l.key}, token).Result()
+				return false, ErrLockAlreadyHeld
+			}
+			l.token = token
+			if l.watchdog {
+				l.stopWatchdog = make(chan struct{})
+				go l.runWatchdog(token, l.stopWatchdog)
+			}
+			l.mux.Unlock()
+			return true, nil
+		}
+		if l.retryInterval <= 0 {
+			return false, nil
+		}
+
+		select {
+		case <-time.After(l.retryInterval):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+func (l *RedisLock) held() bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.token != ""
+}
+
+// Release release the lock if it is still held
+func (l *RedisLock) Release(ctx context.Context) error {
+	l.mux.Lock()
+	token := l.token
+	l.token = ""
+	if l.stopWatchdog != nil {
+		close(l.stopWatchdog)
+		l.stopWatchdog = nil
+	}
+	l.mux.Unlock()
+
+	if token == "" {
+		return ErrLockNotHeld
+	}
+	rdb, err := redisUniversalClient()
+	if err != nil {
+		return err
+	}
+	res, err := releaseLockScript.Run(ctx, rdb, []string{l.key}, token).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Refresh reset the ttl of the lock
+func (l *RedisLock) Refresh(ctx context.Context) error {
+	l.mux.Lock()
+	token := l.token
+	l.mux.Unlock()
+	return l.refresh(ctx, token)
+}
+
+func (l *RedisLock) refresh(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrLockNotHeld
+	}
+	rdb, err := redisUniversalClient()
+	if err != nil {
+		return err
+	}
+	res, err := refreshLockScript.Run(ctx, rdb, []string{l.key}, token, l.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// runWatchdog refresh the lock acquired with token until stop is closed or the lock is lost,
+// a lost lock(expired or taken over) is no longer held by this RedisLock, so it can be acquired again.
+func (l *RedisLock) runWatchdog(token string, stop chan struct{}) {
+	interval := l.ttl / 3
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := l.refresh(ctx, token)
+			cancel()
+			if errors.Is(err, ErrLockNotHeld) {
+				l.mux.Lock()
+				// released and acquired again meanwhile
+				if l.token == token {
+					l.token = ""
+					l.stopWatchdog = nil
+				}
+				l.mux.Unlock()
+				return
+			}
+			if err != nil {
+				Error("msg", "redis lock refresh failed", "key", l.key, "err", err)
+			}
+		}
+	}
+}
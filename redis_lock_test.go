@@ -0,0 +1,94 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// useMiniRedis start a miniredis and use it as the single node client until the end of the test
+func useMiniRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = rClient.Close()
+		rClient = nil
+	})
+	return mr
+}
+
+func TestRedisLockAcquire(t *testing.T) {
+	useMiniRedis(t)
+	ctx := context.Background()
+
+	lock := NewRedisLock("LOCK:TEST", time.Second*10, LockWatchdog())
+	if ok, err := lock.Acquire(ctx); err != nil || !ok {
+		t.Fatalf("Acquire = %v, %v", ok, err)
+	}
+
+	// held by this RedisLock: no second token nor watchdog
+	if ok, err := lock.Acquire(ctx); ok || !errors.Is(err, ErrLockAlreadyHeld) {
+		t.Fatalf("second Acquire = %v, %v, want ErrLockAlreadyHeld", ok, err)
+	}
+
+	// held by someone else
+	other := NewRedisLock("LOCK:TEST", time.Second*10)
+	if ok, err := other.Acquire(ctx); ok || err != nil {
+		t.Fatalf("contended Acquire = %v, %v, want false, nil", ok, err)
+	}
+
+	// the context ends while retrying
+	retry := NewRedisLock("LOCK:TEST", time.Second*10, LockRetry(time.Millisecond*10))
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	if ok, err := retry.Acquire(timeoutCtx); ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire with timeout = %v, %v, want context.DeadlineExceeded", ok, err)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := other.Acquire(ctx); err != nil || !ok {
+		t.Fatalf("Acquire after Release = %v, %v", ok, err)
+	}
+	if err := lock.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Release of a released lock = %v, want ErrLockNotHeld", err)
+	}
+}
+
+func TestRedisLockWatchdogLost(t *testing.T) {
+	mr := useMiniRedis(t)
+	ctx := context.Background()
+
+	lock := NewRedisLock("LOCK:LOST", time.Millisecond*300, LockWatchdog())
+	if ok, err := lock.Acquire(ctx); err != nil || !ok {
+		t.Fatalf("Acquire = %v, %v", ok, err)
+	}
+	// taken over, e.g. after a pause longer than the ttl
+	if err := mr.Set("LOCK:LOST", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, time.Second*5, func() bool { return !lock.held() }) {
+		t.Fatal("the lost lock is still held after the refresh failed")
+	}
+	// the lock of the other holder is kept
+	if err := lock.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Release of a lost lock = %v, want ErrLockNotHeld", err)
+	}
+	if v, _ := mr.Get("LOCK:LOST"); v != "other" {
+		t.Fatalf("value = %q, want the other holder kept", v)
+	}
+
+	mr.Del("LOCK:LOST")
+	if ok, err := lock.Acquire(ctx); err != nil || !ok {
+		t.Fatalf("Acquire after the lock was lost = %v, %v", ok, err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
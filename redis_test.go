@@ -0,0 +1,126 @@
+package fit
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis/v8"
+)
+
+// resetRedis forget the connected clients, and close them at the end of the test
+func resetRedis(t *testing.T) {
+	t.Helper()
+	rClient, rClusterClient = nil, nil
+	t.Cleanup(func() {
+		CloseRedis()
+		rClient, rClusterClient = nil, nil
+	})
+}
+
+// useFakeSentinel answer the sentinel commands on mr itself, mr is the master of "mymaster"
+func useFakeSentinel(t *testing.T, mr *miniredis.Miniredis) {
+	t.Helper()
+	err := mr.Server().Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch {
+		case len(args) == 2 && strings.EqualFold(args[0], "get-master-addr-by-name") && args[1] == "mymaster":
+			host, port, _ := net.SplitHostPort(mr.Addr())
+			c.WriteLen(2)
+			c.WriteBulk(host)
+			c.WriteBulk(port)
+		case len(args) == 2 && strings.EqualFold(args[0], "sentinels"):
+			c.WriteLen(0)
+		default:
+			c.WriteNull()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisGetUniversal(t *testing.T) {
+	tests := []struct {
+		name    string
+		connect func(t *testing.T, mr *miniredis.Miniredis) error
+		cluster bool
+	}{
+		{"NewRedisDefConnect", func(t *testing.T, mr *miniredis.Miniredis) error {
+			return NewRedisDefConnect(mr.Addr(), "", "", 0)
+		}, false},
+		{"NewRedisConnect", func(t *testing.T, mr *miniredis.Miniredis) error {
+			return NewRedisConnect(redis.Options{Addr: mr.Addr()})
+		}, false},
+		{"NewRedisConnectFailover", func(t *testing.T, mr *miniredis.Miniredis) error {
+			useFakeSentinel(t, mr)
+			return NewRedisConnectFailover(redis.FailoverOptions{MasterName: "mymaster", SentinelAddrs: []string{mr.Addr()}})
+		}, false},
+		{"NewRedisDefConnectCluster", func(t *testing.T, mr *miniredis.Miniredis) error {
+			return NewRedisDefConnectCluster([]string{mr.Addr()}, "", "")
+		}, true},
+		{"NewRedisConnectCluster", func(t *testing.T, mr *miniredis.Miniredis) error {
+			return NewRedisConnectCluster(redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetRedis(t)
+			mr := miniredis.RunT(t)
+			if err := tt.connect(t, mr); err != nil {
+				t.Fatal(err)
+			}
+
+			instance := MainRedis()
+			universal := instance.GetUniversal()
+			switch c := universal.(type) {
+			case *redis.Client:
+				if tt.cluster || c != instance.GetNode() {
+					t.Fatalf("GetUniversal = %T, not the single node client", universal)
+				}
+			case *redis.ClusterClient:
+				if !tt.cluster || c != instance.GetCluster() {
+					t.Fatalf("GetUniversal = %T, not the cluster client", universal)
+				}
+			default:
+				t.Fatalf("GetUniversal = %T", universal)
+			}
+
+			// the same connection as the MainRedis helpers
+			if _, err := instance.Set("universal", tt.name); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := universal.Get(instance.ctx, "universal").Result(); err != nil || v != tt.name {
+				t.Fatalf("Get = %q, %v", v, err)
+			}
+			if v, _ := mr.Get("universal"); v != tt.name {
+				t.Fatalf("stored %q, want %q", v, tt.name)
+			}
+		})
+	}
+}
+
+func TestRedisGetUniversalSelection(t *testing.T) {
+	resetRedis(t)
+	if c := MainRedis().GetUniversal(); c != nil {
+		t.Fatalf("not connected: GetUniversal = %T, want nil", c)
+	}
+
+	cluster := miniredis.RunT(t)
+	if err := NewRedisConnectCluster(redis.ClusterOptions{Addrs: []string{cluster.Addr()}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := MainRedis().GetUniversal().(*redis.ClusterClient); !ok {
+		t.Fatal("cluster only: expected the cluster client")
+	}
+
+	// both connected: the single node is used, as by the other MainRedis helpers
+	node := miniredis.RunT(t)
+	if err := NewRedisConnect(redis.Options{Addr: node.Addr()}); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := MainRedis().GetUniversal().(*redis.Client); !ok || c != rClient {
+		t.Fatal("both connected: expected the single node client")
+	}
+}
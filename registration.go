@@ -6,31 +6,47 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	"go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var serviceRegisterInstance *ServiceRegister
 
+var (
+	// ErrInvalidStatusTransition see ThisServiceStatus.CanTransitionTo
+	ErrInvalidStatusTransition = errors.New("invalid service status transition")
+	// ErrStatusConflict the value in the registry was changed concurrently, e.g. by an operator
+	ErrStatusConflict = errors.New("service value was modified concurrently")
+	// ErrServiceExited Shutdown was already called or the service exited
+	ErrServiceExited = errors.New("service already exited")
+)
+
 type ServiceRegister struct {
-	Ctx           context.Context
-	Client        *clientv3.Client
-	Key           string
-	Value         string
-	Lease         int64
-	leaseID       clientv3.LeaseID
-	keepAliveChan <-chan *clientv3.LeaseKeepAliveResponse
-	restartChan   chan struct{}
+	Ctx    context.Context
+	Client *clientv3.Client
+	Key    string
+	Value  string
+	Lease  int64
 
 	isCallClose bool
 	runRetry    bool
 
+	// mux guards the lease, the registered value, its mod revision and the exit state
+	mux         sync.Mutex
+	leaseID     clientv3.LeaseID
+	restartChan chan struct{}
+	current     RegisterCenterValue
+	modRevision int64
+	exited      bool
+
 	// Number of unexpected exits (disconnection and reconnection), 0 no retry(default).
 	RetryCount        int
 	RetryWaitDuration time.Duration
@@ -124,10 +140,15 @@ func (e *ServiceRegister) putKeyWithLease(lease int64, newVal ...string) error {
 		value = e.Value
 	}
 
-	_, err = e.Client.Put(e.Ctx, e.Key, value, clientv3.WithLease(grant.ID))
+	putResp, err := e.Client.Put(e.Ctx, e.Key, value, clientv3.WithLease(grant.ID))
 	if err != nil {
 		return err
 	}
+	e.mux.Lock()
+	e.current = RegisterCenterValue{}
+	_ = json.Unmarshal([]byte(value), &e.current)
+	e.modRevision = putResp.Header.Revision
+	e.mux.Unlock()
 
 	// keep lease
 	leaseRespChan, err := e.Client.KeepAlive(e.Ctx, grant.ID)
@@ -135,41 +156,41 @@ func (e *ServiceRegister) putKeyWithLease(lease int64, newVal ...string) error {
 		return err
 	}
 
+	restartChan := make(chan struct{}, 1)
+	e.mux.Lock()
 	e.leaseID = grant.ID
-	e.keepAliveChan = leaseRespChan
-	e.restartChan = make(chan struct{}, 1)
+	e.restartChan = restartChan
+	e.mux.Unlock()
 	go e.watcher()
-	go e.keepAlive()
+	go e.keepAlive(leaseRespChan, restartChan)
 	return nil
 }
 
 // Close cancellation of lease
 func (e *ServiceRegister) Close() {
+	e.mux.Lock()
 	e.isCallClose = true
+	leaseID := e.leaseID
+	e.mux.Unlock()
 	ctx, cancel := context.WithTimeout(e.Ctx, time.Second*10)
 	defer cancel()
-	if _, err := e.Client.Revoke(ctx, e.leaseID); err != nil {
+	if _, err := e.Client.Revoke(ctx, leaseID); err != nil {
 		Error("[ETCD Revoke]: err:" + err.Error())
 	}
 }
 
+// watcher follow the changes of the key until ctx is done. A PUT only updates the value in memory,
+// the lease is kept; the key is registered again with a new lease only when it is deleted.
 func (e *ServiceRegister) watcher() {
 	watchChan := e.Client.Watch(e.Ctx, e.Key)
 	for watchResponse := range watchChan {
 		for _, event := range watchResponse.Events {
 			if event.Type == clientv3.EventTypeDelete {
-				if !e.isCallClose {
-					e.restartChan <- struct{}{}
-					_, _ = e.Client.Revoke(e.Ctx, e.leaseID)
-					if err := e.putKeyWithLease(e.Lease, string(event.Kv.Value)); err != nil {
-						Error("msg", "service restart failed!", "err", err)
-						_, _ = e.Client.Delete(e.Ctx, e.Key)
-						e.cancel()
-						e.exit()
-						if e.OnBack != nil {
-							e.OnBack()
-						}
-					}
+				e.mux.Lock()
+				isCallClose := e.isCallClose
+				e.mux.Unlock()
+				if !isCallClose {
+					e.reRegister()
 				}
 				return
 			}
@@ -181,38 +202,97 @@ func (e *ServiceRegister) watcher() {
 				return
 			}
 
+			e.mux.Lock()
+			if event.Kv.ModRevision <= e.modRevision {
+				// written by SetStatus/Restore, the event has been emitted
+				e.mux.Unlock()
+				continue
+			}
+			if !e.current.Status.CanTransitionTo(rcv.Status) {
+				Warning("msg", "invalid service status transition in registry", "from", e.current.Status.String(), "to", rcv.Status.String())
+			}
+			e.current = rcv
+			e.modRevision = event.Kv.ModRevision
+			detached := event.Kv.Lease != int64(e.leaseID)
+			e.mux.Unlock()
+
 			if rcv.Status == ServiceStatusKill {
 				Info("Received kill instruction")
-				_, _ = e.Client.Delete(e.Ctx, e.Key)
-				e.cancel()
+				e.kill()
 				return
 			}
 
+			// a put without lease(e.g. by etcdctl) detaches the key, it would outlive the process
+			if detached {
+				e.attachLease(event.Kv.ModRevision, event.Kv.Value)
+			}
+
 			if e.OnStatusChange != nil {
 				go e.OnStatusChange(rcv, e)
 			}
+		}
+	}
+}
 
-			// update
-			if event.Type == clientv3.EventTypePut {
-				e.restartChan <- struct{}{}
-				_, _ = e.Client.Revoke(e.Ctx, e.leaseID)
-				if err := e.putKeyWithLease(e.Lease, string(event.Kv.Value)); err != nil {
-					Error("msg", "service restart failed!", "err", err)
-					_, _ = e.Client.Delete(e.Ctx, e.Key)
-					e.cancel()
-					e.exit()
-					if e.OnBack != nil {
-						e.OnBack()
-					}
-				}
-				return
-			}
-			break
+// kill delete the key and cancel Ctx, the deletion does not register the service again
+func (e *ServiceRegister) kill() {
+	e.mux.Lock()
+	e.isCallClose = true
+	e.mux.Unlock()
+	_, _ = e.Client.Delete(e.Ctx, e.Key)
+	e.cancel()
+}
+
+// attachLease put the value again with the lease of the service, unless it was modified in the meantime
+func (e *ServiceRegister) attachLease(rev int64, value []byte) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	resp, err := e.Client.Txn(e.Ctx).
+		If(clientv3.Compare(clientv3.ModRevision(e.Key), "=", rev)).
+		Then(clientv3.OpPut(e.Key, string(value), clientv3.WithLease(e.leaseID))).
+		Commit()
+	if err != nil {
+		Error("msg", "failed to attach the lease to the service", "err", err)
+		return
+	}
+	if resp.Succeeded && e.modRevision == rev {
+		e.modRevision = resp.Header.Revision
+	}
+}
+
+// reRegister the key was deleted, register the current value with a new lease
+func (e *ServiceRegister) reRegister() {
+	e.mux.Lock()
+	if e.exited {
+		e.mux.Unlock()
+		return
+	}
+	value, _ := json.Marshal(&e.current)
+	oldLease := e.leaseID
+	// stop the keepalive of the old lease, under mux so exit() cannot close the chan meanwhile
+	e.restartChan <- struct{}{}
+	e.mux.Unlock()
+
+	if err := e.putKeyWithLease(e.Lease, string(value)); err != nil {
+		Error("msg", "service restart failed!", "err", err)
+		_, _ = e.Client.Delete(e.Ctx, e.Key)
+		e.cancel()
+		e.exit()
+		if e.OnBack != nil {
+			e.OnBack()
 		}
+		return
+	}
+
+	// the key is attached to the new lease now, revoking the old one only releases it(it may have expired already)
+	ctx, cancel := context.WithTimeout(e.Ctx, time.Second*5)
+	defer cancel()
+	if _, err := e.Client.Revoke(ctx, oldLease); err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		Warning("msg", "failed to revoke the previous lease", "lease", int64(oldLease), "err", err)
 	}
 }
 
-func (e *ServiceRegister) keepAlive() {
+func (e *ServiceRegister) keepAlive(keepAliveChan <-chan *clientv3.LeaseKeepAliveResponse, restartChan <-chan struct{}) {
 	var isRestart bool
 	var ctxDone bool
 	defer func() {
@@ -233,13 +313,13 @@ func (e *ServiceRegister) keepAlive() {
 	}()
 	for {
 		select {
-		case <-e.restartChan:
+		case <-restartChan:
 			isRestart = true
 			return
 		case <-e.Ctx.Done():
 			ctxDone = true
 			return
-		case resp := <-e.keepAliveChan:
+		case resp := <-keepAliveChan:
 			if resp == nil {
 				return
 			}
@@ -298,9 +378,17 @@ func (e *ServiceRegister) retry() {
 }
 
 func (e *ServiceRegister) exit() {
+	e.mux.Lock()
+	if e.exited {
+		e.mux.Unlock()
+		return
+	}
+	e.exited = true
 	if e.restartChan != nil {
 		close(e.restartChan)
 	}
+	e.mux.Unlock()
+
 	if e.SignalChan != nil {
 		if e.SignalTag == nil {
 			e.SignalTag = os.Interrupt
@@ -309,19 +397,80 @@ func (e *ServiceRegister) exit() {
 	}
 }
 
-func (e *ServiceRegister) Shutdown() {
+// Shutdown send SignalTag to SignalChan, ErrServiceExited when the service already exited
+func (e *ServiceRegister) Shutdown() error {
+	e.mux.Lock()
+	exited := e.exited
+	e.mux.Unlock()
+	if exited {
+		return ErrServiceExited
+	}
 	e.exit()
+	return nil
+}
+
+// Status the current status of the service
+func (e *ServiceRegister) Status() ThisServiceStatus {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.current.Status
 }
 
+// Restore write value with the status ServiceStatusRun, see SetStatus
 func (e *ServiceRegister) Restore(value RegisterCenterValue) error {
 	value.Status = ServiceStatusRun
+	return e.writeValue(e.Ctx, value)
+}
+
+// SetStatus change the status of the registered value, illegal transitions return ErrInvalidStatusTransition.
+// The value is only written when it was not modified since it was last seen, otherwise ErrStatusConflict is returned.
+// OnStatusChange is called as for the changes made in the registry, ServiceStatusKill deletes the key and cancels Ctx
+// as a kill instruction in the registry does.
+func (e *ServiceRegister) SetStatus(ctx context.Context, status ThisServiceStatus, reason string) error {
+	e.mux.Lock()
+	value := e.current
+	e.mux.Unlock()
+	value.Status = status
+	value.Reason = reason
+	return e.writeValue(ctx, value)
+}
+
+func (e *ServiceRegister) writeValue(ctx context.Context, value RegisterCenterValue) error {
 	result, err := json.Marshal(&value)
 	if err != nil {
 		return err
 	}
-	if _, err := e.Client.Put(e.Ctx, e.Key, string(result)); err != nil {
+
+	// mux is held until the revision is recorded, so the watcher skips the event of this write
+	e.mux.Lock()
+	from := e.current.Status
+	if !from.CanTransitionTo(value.Status) {
+		e.mux.Unlock()
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, from, value.Status)
+	}
+	resp, err := e.Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(e.Key), "=", e.modRevision)).
+		Then(clientv3.OpPut(e.Key, string(result), clientv3.WithLease(e.leaseID))).
+		Commit()
+	if err != nil {
+		e.mux.Unlock()
 		return err
 	}
+	if !resp.Succeeded {
+		e.mux.Unlock()
+		return ErrStatusConflict
+	}
+	e.current = value
+	e.modRevision = resp.Header.Revision
+	e.mux.Unlock()
+
+	if e.OnStatusChange != nil {
+		go e.OnStatusChange(value, e)
+	}
+	// the watcher skips the event of this write, it would not see the kill
+	if value.Status == ServiceStatusKill {
+		e.kill()
+	}
 	return nil
 }
 
@@ -366,6 +515,19 @@ func (s *StatUnfinished) GrpcStatUnfinished() grpc.UnaryServerInterceptor {
 	}
 }
 
+// GrpcStreamStatUnfinished count the active streams, new streams are rejected after FiringWaitDone
+// while the active ones run until the handler returns.
+func (s *StatUnfinished) GrpcStreamStatUnfinished() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if s.waitDone || s.NotAvailable {
+			return errors.New("故障已转移,请重试")
+		}
+		s.Add()
+		defer s.Sub()
+		return handler(srv, ss)
+	}
+}
+
 func (s *StatUnfinished) GrpcHandleStatUnfinished() error {
 	if s.waitDone || s.NotAvailable {
 		return errors.New("故障已转移,请重试")
@@ -386,14 +548,32 @@ func (s *StatUnfinished) Add() {
 }
 
 func (s *StatUnfinished) Sub() {
-	atomic.AddInt32(&s.data, -1)
-	if s.data == 0 && s.waitDone {
+	if atomic.AddInt32(&s.data, -1) == 0 && s.waitDone && s.Signal != nil {
 		s.Signal <- struct{}{}
 	}
 }
 
+// Draining whether new requests are rejected, see FiringWaitDone and SetAvailable
+func (s *StatUnfinished) Draining() bool {
+	return s.waitDone || s.NotAvailable
+}
+
 func (s *StatUnfinished) Value() int32 {
-	return s.data
+	return atomic.LoadInt32(&s.data)
+}
+
+// WaitUntilDone wait until there is no unfinished request, or ctx is done
+func (s *StatUnfinished) WaitUntilDone(ctx context.Context) error {
+	t := time.NewTicker(time.Millisecond * 50)
+	defer t.Stop()
+	for s.Value() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
 }
 
 func (s *StatUnfinished) SetAvailable(is bool) {
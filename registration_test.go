@@ -0,0 +1,162 @@
+package fit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func newTestRegister(t *testing.T, c *clientv3.Client, prefix string) *ServiceRegister {
+	t.Helper()
+	reg, err := NewServiceRegister(&ServiceRegister{
+		Ctx:    context.Background(),
+		Client: c,
+		Key:    prefix,
+		Value:  NewRegisterCenterValue("127.0.0.1:8080"),
+		Lease:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		reg.Close()
+		reg.cancel()
+	})
+	return reg
+}
+
+func registryValue(t *testing.T, c *clientv3.Client, key string) (RegisterCenterValue, int64) {
+	t.Helper()
+	resp, err := c.Get(context.Background(), key)
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("get %s = %v, %v", key, resp, err)
+	}
+	var v RegisterCenterValue
+	if err := json.Unmarshal(resp.Kvs[0].Value, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v, resp.Kvs[0].Lease
+}
+
+func TestServiceSetStatusConflict(t *testing.T) {
+	c := useEmbedEtcd(t)
+	reg := newTestRegister(t, c, "/service/status-conflict-test")
+	ctx := context.Background()
+
+	if err := reg.SetStatus(ctx, ServiceStatusNotAvailable, "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := registryValue(t, c, reg.Key); v.Status != ServiceStatusNotAvailable || v.Reason != "maintenance" {
+		t.Fatalf("registry value = %+v", v)
+	}
+	if err := reg.SetStatus(ctx, ServiceStatusRun, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// an operator changes the value, the service acts on the revision it saw before
+	reg.mux.Lock()
+	seen := reg.modRevision
+	leaseID := reg.leaseID
+	reg.mux.Unlock()
+	operator := RegisterCenterValue{Addr: "127.0.0.1:8080", Status: ServiceStatusNotAvailable, Reason: "operator"}
+	data, _ := json.Marshal(operator)
+	if _, err := c.Put(ctx, reg.Key, string(data), clientv3.WithLease(leaseID)); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, time.Second*5, func() bool { return reg.Status() == ServiceStatusNotAvailable }) {
+		t.Fatal("the watcher did not apply the change of the operator")
+	}
+	reg.mux.Lock()
+	reg.modRevision = seen
+	reg.mux.Unlock()
+
+	if err := reg.SetStatus(ctx, ServiceStatusWaitDone, "deploy"); !errors.Is(err, ErrStatusConflict) {
+		t.Fatalf("SetStatus over a concurrent change: err = %v, want ErrStatusConflict", err)
+	}
+	if v, _ := registryValue(t, c, reg.Key); v.Reason != "operator" {
+		t.Fatalf("the change of the operator was clobbered: %+v", v)
+	}
+}
+
+func TestServiceSetStatusKill(t *testing.T) {
+	c := useEmbedEtcd(t)
+	reg := newTestRegister(t, c, "/service/status-kill-test")
+	changed := make(chan RegisterCenterValue, 1)
+	reg.OnStatusChange = func(value RegisterCenterValue, _ *ServiceRegister) {
+		changed <- value
+	}
+
+	if err := reg.SetStatus(context.Background(), ServiceStatusKill, "killed"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reg.Ctx.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("Ctx not canceled by the kill")
+	}
+	select {
+	case v := <-changed:
+		if v.Status != ServiceStatusKill {
+			t.Fatalf("status change event = %+v", v)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("no status change event")
+	}
+
+	// deleted and not registered again
+	time.Sleep(time.Millisecond * 300)
+	resp, err := c.Get(context.Background(), reg.Key)
+	if err != nil || len(resp.Kvs) != 0 {
+		t.Fatalf("key after kill = %v, %v", resp, err)
+	}
+	if err := reg.SetStatus(context.Background(), ServiceStatusRun, ""); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Fatalf("SetStatus after kill: err = %v, want ErrInvalidStatusTransition", err)
+	}
+}
+
+func TestServiceWatcherKeepsLease(t *testing.T) {
+	c := useEmbedEtcd(t)
+	reg := newTestRegister(t, c, "/service/keep-lease-test")
+	ctx := context.Background()
+	reg.mux.Lock()
+	leaseID := reg.leaseID
+	reg.mux.Unlock()
+
+	// a PUT with the lease only updates the value
+	v := RegisterCenterValue{Addr: "127.0.0.1:8080", Status: ServiceStatusNotAvailable, Reason: "with lease"}
+	data, _ := json.Marshal(v)
+	if _, err := c.Put(ctx, reg.Key, string(data), clientv3.WithLease(leaseID)); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, time.Second*5, func() bool { return reg.Status() == ServiceStatusNotAvailable }) {
+		t.Fatal("the watcher did not apply the PUT")
+	}
+	if _, lease := registryValue(t, c, reg.Key); lease != int64(leaseID) {
+		t.Fatalf("lease = %x, want %x", lease, leaseID)
+	}
+
+	// a PUT without lease(e.g. by etcdctl) is attached to the lease of the service again
+	v.Status, v.Reason = ServiceStatusRun, "without lease"
+	data, _ = json.Marshal(v)
+	if _, err := c.Put(ctx, reg.Key, string(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, time.Second*5, func() bool {
+		v, lease := registryValue(t, c, reg.Key)
+		return v.Reason == "without lease" && lease == int64(leaseID)
+	}) {
+		t.Fatal("the value was not attached to the lease of the service")
+	}
+	if s := reg.Status(); s != ServiceStatusRun {
+		t.Fatalf("status = %v", s)
+	}
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	if reg.leaseID != leaseID {
+		t.Fatalf("registered again with lease %x, want the lease %x kept", reg.leaseID, leaseID)
+	}
+}
@@ -0,0 +1,93 @@
+package fit
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIdHeader default header carrying the request id
+const RequestIdHeader = "X-Request-ID"
+
+const requestIdCtxName string = "FIT_REQUEST_ID"
+
+// RequestIDMiddleware read the request id from the header(default X-Request-ID), a new one is generated when missing.
+// The id is stored in the context and echoed in the response header, it does not depend on full link tracing.
+func RequestIDMiddleware(headerName ...string) gin.HandlerFunc {
+	header := RequestIdHeader
+	if len(headerName) > 0 && headerName[0] != "" {
+		header = headerName[0]
+	}
+	return func(c *gin.Context) {
+		id := c.GetHeader(header)
+		if id == "" {
+			id = NewULID()
+		}
+		c.Set(requestIdCtxName, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIdCtxName, id))
+		c.Header(header, id)
+		c.Next()
+	}
+}
+
+// GetRequestID the request id of ctx(*gin.Context or context.Context),
+// the trace id is returned when there is no request id but a Trace, otherwise empty
+func GetRequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id, ok := ctx.Value(requestIdCtxName).(string); ok && id != "" {
+		return id
+	}
+	if trace, ok := ctx.Value(trackCtxName).(*Trace); ok && trace != nil {
+		return trace.TraceId
+	}
+	return ""
+}
+
+// ContextWithRequestID returns a copy of ctx carrying id
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdCtxName, id)
+}
+
+// RequestIDServerInterceptor read the request id from the incoming metadata, a new one is generated when missing,
+// it is stored in the context and sent back in the response header
+func RequestIDServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			id = MetadataValue(md, RequestIdMetadataKey)
+		}
+		if id == "" {
+			id = NewULID()
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIdMetadataKey, id))
+		return handler(ContextWithRequestID(ctx, id), req)
+	}
+}
+
+// RequestIDClientInterceptor carry the request id of ctx in the outgoing metadata
+func RequestIDClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id := GetRequestID(ctx); id != "" {
+			ctx = SetOutgoingMetadata(ctx, RequestIdMetadataKey, id)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// requestIDFields the "request_id" log field of ctx, nil when there is no request id or ctx carries a Trace
+func requestIDFields(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+	if trace, ok := ctx.Value(trackCtxName).(*Trace); ok && trace != nil {
+		return nil
+	}
+	if id, ok := ctx.Value(requestIdCtxName).(string); ok && id != "" {
+		return map[string]interface{}{"request_id": id}
+	}
+	return nil
+}
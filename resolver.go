@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"go.etcd.io/etcd/client/v3"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc/resolver"
@@ -39,6 +41,32 @@ const (
 	ServiceStatusKill
 )
 
+func (s ThisServiceStatus) String() string {
+	switch s {
+	case ServiceStatusRun:
+		return "run"
+	case ServiceStatusNotAvailable:
+		return "not_available"
+	case ServiceStatusWaitDone:
+		return "wait_done"
+	case ServiceStatusKill:
+		return "kill"
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+// CanTransitionTo whether the status can be changed to next,
+// ServiceStatusWaitDone can only be changed to ServiceStatusKill and ServiceStatusKill is final
+func (s ThisServiceStatus) CanTransitionTo(next ThisServiceStatus) bool {
+	switch s {
+	case ServiceStatusRun, ServiceStatusNotAvailable:
+		return next >= ServiceStatusRun && next <= ServiceStatusKill
+	case ServiceStatusWaitDone:
+		return next == ServiceStatusKill
+	}
+	return false
+}
+
 type RegisterCenterValue struct {
 	CreatedAt int64 `json:"created_at"`
 	//Service address, HTTP service plus full protocol.
@@ -55,15 +83,63 @@ type RegisterCenterValue struct {
 
 	// Service status
 	Status ThisServiceStatus `json:"status"`
+
+	// Version of the service, e.g. v1.2.0
+	Version string `json:"version,omitempty"`
+
+	// Zone the service is deployed in, see LoadBalancingPolicy.PreferZone
+	Zone string `json:"zone,omitempty"`
+
+	// Weight of the instance, values missing in old registrations default to 1
+	Weight int `json:"weight,omitempty"`
+
+	Meta map[string]string `json:"meta,omitempty"`
 }
 
-func NewRegisterCenterValue(addr string) string {
-	return H{
-		"created_at":  time.Now().Unix(),
-		"addr":        addr,
-		"entity_type": ServiceTypeBasic,
-		"status":      ServiceStatusRun,
-	}.ToString()
+type RegisterValueOption func(*RegisterCenterValue)
+
+func WithVersion(version string) RegisterValueOption {
+	return func(v *RegisterCenterValue) {
+		v.Version = version
+	}
+}
+
+func WithZone(zone string) RegisterValueOption {
+	return func(v *RegisterCenterValue) {
+		v.Zone = zone
+	}
+}
+
+func WithWeight(weight int) RegisterValueOption {
+	return func(v *RegisterCenterValue) {
+		v.Weight = weight
+	}
+}
+
+// WithMeta custom key-value pairs, can be called multiple times
+func WithMeta(meta map[string]string) RegisterValueOption {
+	return func(v *RegisterCenterValue) {
+		if v.Meta == nil {
+			v.Meta = make(map[string]string, len(meta))
+		}
+		for k, val := range meta {
+			v.Meta[k] = val
+		}
+	}
+}
+
+func NewRegisterCenterValue(addr string, opts ...RegisterValueOption) string {
+	v := RegisterCenterValue{
+		CreatedAt:  time.Now().Unix(),
+		Addr:       addr,
+		EntityType: ServiceTypeBasic,
+		Status:     ServiceStatusRun,
+		Weight:     1,
+	}
+	for _, opt := range opts {
+		opt(&v)
+	}
+	return NewRegistrationCenterValueOption(v)
 }
 
 func NewRegistrationCenterValueOption(option RegisterCenterValue) string {
@@ -93,50 +169,140 @@ type Resolver struct {
 	Client *clientv3.Client
 	cc     resolver.ClientConn
 	prefix string
+	ctx    context.Context
+	cancel context.CancelFunc
+	// etcd revision of the applied addresses, an older concurrent resolve is dropped
+	revision int64
+}
+
+// ErrStaleResolution etcd is unreachable and the cached addresses are older than the resolver cache ttl
+var ErrStaleResolution = errors.New("etcd unreachable, service addresses are stale")
+
+type resolvedAddresses struct {
+	addresses []resolver.Address
+	updatedAt time.Time
+}
+
+var (
+	resolverCacheTTL = time.Minute * 5
+	resolverCacheMux sync.RWMutex
+	resolverCache    = make(map[string]resolvedAddresses)
+	resolverHealthy  = int32(1)
+)
+
+// SetResolverCacheTTL how long the last known addresses of a service are used while etcd is unreachable, default 5 minutes
+func SetResolverCacheTTL(ttl time.Duration) {
+	resolverCacheTTL = ttl
+}
+
+// ResolverHealthy false when the last etcd request of a resolver failed,
+// the services are then served from the cache(degraded mode).
+func ResolverHealthy() bool {
+	return atomic.LoadInt32(&resolverHealthy) == 1
 }
 
 func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
-	// TODO:watch It will be called after changes
+	go r.resolve()
 }
 
 func (r *Resolver) Close() {
-	// TODO: Parser off
+	if r.cancel != nil {
+		r.cancel()
+	}
 }
 
+// watcher resolve the addresses and resync on every change, the watch is restarted when etcd recovers
 func (r *Resolver) watcher() {
 	if mid := GetLocalMid(); mid != "" {
 		r.prefix = path.Join(r.prefix, mid)
 	}
-	response, err := r.Client.Get(context.Background(), r.prefix, clientv3.WithPrefix())
+	for {
+		r.resolve()
+
+		// watch from the resolved revision, a change between the resolve and the start of the watch is not missed
+		opts := []clientv3.OpOption{clientv3.WithPrefix()}
+		r.RLock()
+		if r.revision > 0 {
+			opts = append(opts, clientv3.WithRev(r.revision+1))
+		}
+		r.RUnlock()
+
+		// canceled before watching again, the broken watch would keep running otherwise
+		watchCtx, watchCancel := context.WithCancel(clientv3.WithRequireLeader(r.ctx))
+		wch := r.Client.Watch(watchCtx, r.prefix, opts...)
+		for resp := range wch {
+			if resp.Err() != nil {
+				break
+			}
+			r.resolve()
+		}
+		watchCancel()
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(time.Second * 3):
+		}
+	}
+}
+
+// resolve fetch the addresses without holding the lock, only the update of the state and the cache is locked
+func (r *Resolver) resolve() {
+	ctx, cancel := context.WithTimeout(r.ctx, time.Second*5)
+	response, err := r.Client.Get(ctx, r.prefix, clientv3.WithPrefix())
+	cancel()
+
+	r.Lock()
+	defer r.Unlock()
 	if err != nil {
+		if r.ctx.Err() != nil {
+			return
+		}
+		atomic.StoreInt32(&resolverHealthy, 0)
+		resolverCacheMux.RLock()
+		cached, ok := resolverCache[r.prefix]
+		resolverCacheMux.RUnlock()
+		if ok && time.Since(cached.updatedAt) <= resolverCacheTTL {
+			Warning("msg", "etcd unreachable, serving cached service addresses", "prefix", r.prefix, "err", err)
+			r.cc.UpdateState(resolver.State{Addresses: cached.addresses})
+			return
+		}
+		r.cc.ReportError(fmt.Errorf("%w: %v", ErrStaleResolution, err))
 		return
 	}
+	atomic.StoreInt32(&resolverHealthy, 1)
+	if response.Header.Revision < r.revision {
+		return
+	}
+	r.revision = response.Header.Revision
 
 	addresses := make([]resolver.Address, 0)
 	var desc string
-	for _, kv := range response.Kvs {
-		var rg RegisterCenterValue
-		if err := json.Unmarshal(kv.Value, &rg); err != nil {
-			break
-		}
-		if rg.Status == ServiceStatusRun {
-			addresses = append(addresses, resolver.Address{ServerName: string(kv.Key), Addr: rg.Addr})
-		} else if rg.Reason != "" {
-			desc = rg.Reason
+	for _, v := range latestByAddr(response.Kvs) {
+		if v.value.Status == ServiceStatusRun {
+			addresses = append(addresses, resolver.Address{ServerName: v.key, Addr: v.value.Addr})
+		} else if v.value.Reason != "" {
+			desc = v.value.Reason
 		}
 	}
+
+	resolverCacheMux.Lock()
+	resolverCache[r.prefix] = resolvedAddresses{addresses: addresses, updatedAt: time.Now()}
+	resolverCacheMux.Unlock()
+
 	if len(addresses) == 0 {
+		var err error = ErrServiceNotFound
+		if len(response.Kvs) > 0 {
+			err = ErrNoHealthyEndpoints
+		}
 		if desc != "" {
-			r.cc.ReportError(errors.New(desc))
-		} else {
-			r.cc.ReportError(errors.New("no available services"))
+			err = fmt.Errorf("%w: %s", err, desc)
 		}
+		r.cc.ReportError(&ServiceError{Service: r.prefix, Err: err})
 		return
 	}
 
 	r.cc.UpdateState(resolver.State{
 		Addresses: addresses,
 	})
-
-	//TODO: Listening has been canceled here
 }
@@ -0,0 +1,115 @@
+package fit
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// fakeClientConn record the states and errors reported by a resolver
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errs   chan error
+}
+
+func newFakeClientConn() *fakeClientConn {
+	return &fakeClientConn{states: make(chan resolver.State, 16), errs: make(chan error, 16)}
+}
+
+func (f *fakeClientConn) UpdateState(s resolver.State) error {
+	f.states <- s
+	return nil
+}
+
+func (f *fakeClientConn) ReportError(err error) {
+	f.errs <- err
+}
+
+// nextAddrs wait for a state with n addresses, the states with another number of addresses are skipped
+func (f *fakeClientConn) nextAddrs(t *testing.T, n int) []string {
+	t.Helper()
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case s := <-f.states:
+			if len(s.Addresses) != n {
+				continue
+			}
+			addrs := make([]string, 0, n)
+			for _, a := range s.Addresses {
+				addrs = append(addrs, a.Addr)
+			}
+			sort.Strings(addrs)
+			return addrs
+		case <-f.errs:
+		case <-timeout:
+			t.Fatalf("no state with %d addresses", n)
+			return nil
+		}
+	}
+}
+
+func TestResolverWatch(t *testing.T) {
+	c := useEmbedEtcd(t)
+	ctx := context.Background()
+	const prefix = "/service/resolver-test"
+	if _, err := c.Put(ctx, prefix+"/a", NewRegisterCenterValue("127.0.0.1:8081")); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := newFakeClientConn()
+	r, err := (&Builder{Client: c}).Build(resolver.Target{URL: url.URL{Path: prefix}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := cc.nextAddrs(t, 1); got[0] != "127.0.0.1:8081" {
+		t.Fatalf("addresses = %v", got)
+	}
+
+	if _, err := c.Put(ctx, prefix+"/b", NewRegisterCenterValue("127.0.0.1:8082")); err != nil {
+		t.Fatal(err)
+	}
+	if got := cc.nextAddrs(t, 2); got[0] != "127.0.0.1:8081" || got[1] != "127.0.0.1:8082" {
+		t.Fatalf("addresses = %v", got)
+	}
+	if !ResolverHealthy() {
+		t.Error("ResolverHealthy = false")
+	}
+}
+
+func TestResolverDropsStaleResolution(t *testing.T) {
+	c := useEmbedEtcd(t)
+	const prefix = "/service/resolver-stale-test"
+	if _, err := c.Put(context.Background(), prefix+"/a", NewRegisterCenterValue("127.0.0.1:8081")); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := newFakeClientConn()
+	r := &Resolver{Client: c, cc: cc, prefix: prefix}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	defer r.Close()
+
+	// a concurrent resolve already applied a newer revision
+	r.revision = 1 << 40
+	r.resolve()
+	select {
+	case s := <-cc.states:
+		t.Fatalf("stale resolution applied: %+v", s)
+	default:
+	}
+
+	r.revision = 0
+	r.resolve()
+	if got := cc.nextAddrs(t, 1); got[0] != "127.0.0.1:8081" {
+		t.Fatalf("addresses = %v", got)
+	}
+	if r.revision == 0 {
+		t.Error("the revision of the applied addresses was not recorded")
+	}
+}
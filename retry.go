@@ -0,0 +1,221 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sentinel "github.com/alibaba/sentinel-golang/api"
+	"github.com/alibaba/sentinel-golang/core/base"
+	"google.golang.org/grpc"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted returned(wrapping the last error) when the shared RetryBudget has no token left
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+type retryBudgetError struct {
+	err error
+}
+
+func (e *retryBudgetError) Error() string {
+	return ErrRetryBudgetExhausted.Error() + ": " + e.err.Error()
+}
+
+func (e *retryBudgetError) Is(target error) bool {
+	return target == ErrRetryBudgetExhausted
+}
+
+func (e *retryBudgetError) Unwrap() error {
+	return e.err
+}
+
+type retryConfig struct {
+	attempts     uint
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	jitter       float64
+	retryIf      func(err error) bool
+	onRetry      func(attempt uint, err error)
+	budget       *RetryBudget
+	resource     string
+}
+
+type RetryOption func(*retryConfig)
+
+// RetryMaxAttempts number of calls including the first one, default 3
+func RetryMaxAttempts(n uint) RetryOption {
+	return func(c *retryConfig) {
+		c.attempts = n
+	}
+}
+
+// RetryInitialDelay delay before the first retry, doubled on every retry, default 100ms
+func RetryInitialDelay(d time.Duration) RetryOption {
+	return func(c *retryConfig) {
+		c.initialDelay = d
+	}
+}
+
+// RetryMaxDelay upper bound of the delay, default 2s
+func RetryMaxDelay(d time.Duration) RetryOption {
+	return func(c *retryConfig) {
+		c.maxDelay = d
+	}
+}
+
+// RetryJitter randomize the delay by ±fraction(0-1), default 0.2
+func RetryJitter(fraction float64) RetryOption {
+	return func(c *retryConfig) {
+		c.jitter = fraction
+	}
+}
+
+// RetryIf only errors matching fn are retried, by default every error except context.Canceled and context.DeadlineExceeded
+func RetryIf(fn func(err error) bool) RetryOption {
+	return func(c *retryConfig) {
+		c.retryIf = fn
+	}
+}
+
+// RetryOnRetry called before every retry with the number of the failed attempt(starting from 1)
+func RetryOnRetry(fn func(attempt uint, err error)) RetryOption {
+	return func(c *retryConfig) {
+		c.onRetry = fn
+	}
+}
+
+// RetryWithBudget every retry takes a token from b, retries stop when it is empty
+func RetryWithBudget(b *RetryBudget) RetryOption {
+	return func(c *retryConfig) {
+		c.budget = b
+	}
+}
+
+// RetryBreaker every attempt passes the sentinel resource(see LoadBreakerRule),
+// retries stop as soon as it is blocked, e.g. the circuit breaker is open
+func RetryBreaker(resource string) RetryOption {
+	return func(c *retryConfig) {
+		c.resource = resource
+	}
+}
+
+// Retry call op until it succeeds, the error is not retryable, the attempts are used up or ctx is done.
+// The error of the last attempt is returned.
+func Retry(ctx context.Context, op func(ctx context.Context) error, opts ...RetryOption) error {
+	c := &retryConfig{
+		attempts:     3,
+		initialDelay: time.Millisecond * 100,
+		maxDelay:     time.Second * 2,
+		jitter:       0.2,
+		retryIf: func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.attempts == 0 {
+		c.attempts = 1
+	}
+
+	delay := c.initialDelay
+	var err error
+	for attempt := uint(1); ; attempt++ {
+		if err = c.call(ctx, op); err == nil {
+			return nil
+		}
+		var blocked *base.BlockError
+		if attempt >= c.attempts || errors.As(err, &blocked) || !c.retryIf(err) {
+			return err
+		}
+		if c.budget != nil && !c.budget.Allow() {
+			return &retryBudgetError{err: err}
+		}
+		if c.onRetry != nil {
+			c.onRetry(attempt, err)
+		}
+
+		t := time.NewTimer(c.withJitter(delay))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w, last error: %v", ctx.Err(), err)
+		case <-t.C:
+		}
+		if delay *= 2; delay > c.maxDelay {
+			delay = c.maxDelay
+		}
+	}
+}
+
+func (c *retryConfig) call(ctx context.Context, op func(ctx context.Context) error) error {
+	if c.resource == "" {
+		return op(ctx)
+	}
+	e, b := sentinel.Entry(c.resource, sentinel.WithResourceType(base.ResTypeRPC), sentinel.WithTrafficType(base.Outbound))
+	if b != nil {
+		return b
+	}
+	defer e.Exit()
+	err := op(ctx)
+	if err != nil {
+		sentinel.TraceError(e, err)
+	}
+	return err
+}
+
+func (c *retryConfig) withJitter(d time.Duration) time.Duration {
+	if c.jitter <= 0 || d <= 0 {
+		return d
+	}
+	delta := float64(d) * c.jitter
+	return d + time.Duration(delta*(2*rand.Float64()-1))
+}
+
+// RetryBudget token bucket shared by the call sites to limit the total rate of retries and prevent retry storms
+type RetryBudget struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRetryBudget allow ratePerSecond retries per second on average and burst retries at once
+func NewRetryBudget(ratePerSecond float64, burst int) *RetryBudget {
+	return &RetryBudget{
+		rate:   ratePerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow take a token, false when there is none
+func (b *RetryBudget) Allow() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RetryUnaryClientInterceptor retry the unary calls with Retry, by default only the retryable errors(see IsRetryable)
+func RetryUnaryClientInterceptor(opts ...RetryOption) grpc.UnaryClientInterceptor {
+	opts = append([]RetryOption{RetryIf(IsRetryable)}, opts...)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		return Retry(ctx, func(ctx context.Context) error {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}, opts...)
+	}
+}
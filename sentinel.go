@@ -1,11 +1,22 @@
 package fit
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	sentinel "github.com/alibaba/sentinel-golang/api"
+	"github.com/alibaba/sentinel-golang/core/base"
 	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
 	"github.com/alibaba/sentinel-golang/core/config"
 	"github.com/alibaba/sentinel-golang/core/flow"
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
 )
 
 type SentinelConfig struct {
@@ -73,3 +84,177 @@ func Entry(ruleName string, fn func() error) error {
 		return nil
 	}
 }
+
+// SentinelGinMiddleware open a sentinel entry for every request.
+// resourceFunc default to method+path, fallback default to http 429 with ResponseOK.
+// Errors attached to the context or a 5xx status are reported through TraceError.
+func SentinelGinMiddleware(resourceFunc func(*gin.Context) string, fallback func(*gin.Context, *base.BlockError)) gin.HandlerFunc {
+	if resourceFunc == nil {
+		resourceFunc = func(c *gin.Context) string {
+			path := c.FullPath()
+			if path == "" {
+				path = c.Request.URL.Path
+			}
+			return c.Request.Method + ":" + path
+		}
+	}
+	if fallback == nil {
+		fallback = func(c *gin.Context, _ *base.BlockError) {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, ResponseOK{
+				Code: StatusTooManyRequests,
+				Msg:  SLimited,
+			})
+		}
+	}
+
+	return func(c *gin.Context) {
+		e, b := sentinel.Entry(resourceFunc(c), sentinel.WithResourceType(base.ResTypeWeb), sentinel.WithTrafficType(base.Inbound))
+		if b != nil {
+			fallback(c, b)
+			return
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				sentinel.TraceError(e, fmt.Errorf("panic: %v", r))
+				e.Exit()
+				panic(r)
+			}
+			e.Exit()
+		}()
+
+		c.Next()
+
+		if err := c.Errors.Last(); err != nil {
+			sentinel.TraceError(e, err)
+		} else if c.Writer.Status() >= http.StatusInternalServerError {
+			sentinel.TraceError(e, fmt.Errorf("http status %d", c.Writer.Status()))
+		}
+	}
+}
+
+// SentinelGrpcUnaryInterceptor open a sentinel entry for every call, resourceFunc default to info.FullMethod.
+// Blocked calls return codes.ResourceExhausted.
+// Combine it with other interceptors by ChainDefaultInterceptors(InterceptorSentinel(nil)) or grpc.ChainUnaryInterceptor.
+func SentinelGrpcUnaryInterceptor(resourceFunc func(info *grpc.UnaryServerInfo) string) grpc.UnaryServerInterceptor {
+	if resourceFunc == nil {
+		resourceFunc = func(info *grpc.UnaryServerInfo) string {
+			return info.FullMethod
+		}
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		e, b := sentinel.Entry(resourceFunc(info), sentinel.WithResourceType(base.ResTypeRPC), sentinel.WithTrafficType(base.Inbound))
+		if b != nil {
+			return nil, status.Error(codes.ResourceExhausted, b.Error())
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				sentinel.TraceError(e, fmt.Errorf("panic: %v", r))
+				e.Exit()
+				panic(r)
+			}
+			e.Exit()
+		}()
+
+		resp, err = handler(ctx, req)
+		if err != nil {
+			sentinel.TraceError(e, err)
+		}
+		return resp, err
+	}
+}
+
+const (
+	// SentinelFlowRulesKey etcd key(relative to the prefix) holding a JSON array of flow.Rule
+	SentinelFlowRulesKey = "flow"
+	// SentinelBreakerRulesKey etcd key(relative to the prefix) holding a JSON array of circuitbreaker.Rule
+	SentinelBreakerRulesKey = "circuitbreaker"
+)
+
+// WatchSentinelRules load the flow and circuit breaker rules from keyPrefix/flow and keyPrefix/circuitbreaker
+// and reload them whenever they change, until ctx is done.
+//
+// Every load replaces all rules of that kind, including those loaded by LoadFlowRule/LoadBreakerRule,
+// deleting a key clears the rules of that kind. A document that fails to decode or validate is not applied,
+// the error is passed to onError(default: logged) and the previous rules stay active.
+func WatchSentinelRules(ctx context.Context, etcdClient *clientv3.Client, keyPrefix string, onError func(key string, err error)) error {
+	if etcdClient == nil {
+		return NewErr("etcd instance not found!")
+	}
+	if onError == nil {
+		onError = func(key string, err error) {
+			Error("msg", "sentinel rules reload failed", "key", key, "err", err)
+		}
+	}
+	keyPrefix = strings.TrimRight(keyPrefix, "/")
+
+	resp, err := etcdClient.Get(ctx, keyPrefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.Kvs {
+		if err := applySentinelRules(keyPrefix, string(kv.Key), kv.Value); err != nil {
+			onError(string(kv.Key), err)
+		}
+	}
+
+	go func() {
+		rch := etcdClient.Watch(ctx, keyPrefix+"/", clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+		for wresp := range rch {
+			if err := wresp.Err(); err != nil {
+				onError(keyPrefix, err)
+				continue
+			}
+			for _, ev := range wresp.Events {
+				var value []byte
+				if ev.Type == clientv3.EventTypePut {
+					value = ev.Kv.Value
+				}
+				if err := applySentinelRules(keyPrefix, string(ev.Kv.Key), value); err != nil {
+					onError(string(ev.Kv.Key), err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// SentinelRules the currently active rules, for debugging
+func SentinelRules() ([]flow.Rule, []circuitbreaker.Rule) {
+	return flow.GetRules(), circuitbreaker.GetRules()
+}
+
+// applySentinelRules empty value clears the rules, keys of other kinds are ignored
+func applySentinelRules(keyPrefix, key string, value []byte) error {
+	switch strings.TrimPrefix(key, keyPrefix+"/") {
+	case SentinelFlowRulesKey:
+		rules := make([]*flow.Rule, 0)
+		if len(value) > 0 {
+			if err := json.Unmarshal(value, &rules); err != nil {
+				return err
+			}
+		}
+		for _, rule := range rules {
+			if err := flow.IsValidRule(rule); err != nil {
+				return fmt.Errorf("invalid flow rule %s: %w", rule.Resource, err)
+			}
+		}
+		_, err := flow.LoadRules(rules)
+		return err
+	case SentinelBreakerRulesKey:
+		rules := make([]*circuitbreaker.Rule, 0)
+		if len(value) > 0 {
+			if err := json.Unmarshal(value, &rules); err != nil {
+				return err
+			}
+		}
+		for _, rule := range rules {
+			if err := circuitbreaker.IsValidRule(rule); err != nil {
+				return fmt.Errorf("invalid circuit breaker rule %s: %w", rule.Resource, err)
+			}
+		}
+		_, err := circuitbreaker.LoadRules(rules)
+		return err
+	}
+	return nil
+}
@@ -4,15 +4,28 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
 	"math/rand"
 	"path"
+	"strings"
+	"sync"
 	"time"
 )
 
 type LoadBalancingPolicy struct {
 	Services []RegisterCenterValue
 	Desc     string
+	// How long a failed instance is skipped by DoWithRetry, default 30s
+	SuspectCooldown time.Duration
+	// Instances of the zone are preferred by SelectByRand and DoWithRetry,
+	// other zones are only used when the zone has no instance, empty disables the preference
+	PreferZone string
+
+	mux        sync.Mutex
+	suspect    map[string]time.Time
+	hasStopped bool
 }
 
 func NewLoadBalancing() *LoadBalancingPolicy {
@@ -27,30 +40,211 @@ func (l *LoadBalancingPolicy) Add(s RegisterCenterValue) {
 func (l *LoadBalancingPolicy) SelectByRand() (RegisterCenterValue, error) {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	if len(l.Services) == 0 {
-		return RegisterCenterValue{}, errors.New(l.Desc)
+		return RegisterCenterValue{}, l.notFoundErr()
+	}
+	candidates := l.sameZone(l.Services)
+	index := r.Intn(len(candidates))
+	return candidates[index], nil
+}
+
+// sameZone the instances of PreferZone, all the instances when there is none
+func (l *LoadBalancingPolicy) sameZone(services []RegisterCenterValue) []RegisterCenterValue {
+	if l.PreferZone == "" {
+		return services
+	}
+	var zone []RegisterCenterValue
+	for _, s := range services {
+		if s.Zone == l.PreferZone {
+			zone = append(zone, s)
+		}
+	}
+	if len(zone) == 0 {
+		return services
+	}
+	return zone
+}
+
+// DoWithRetry run fn with a random instance, on error the instance is marked as suspect(skipped for SuspectCooldown)
+// and fn is retried with another instance, an instance is never tried twice in a call while alternatives exist.
+// When all attempts fail, the error lists every attempted address.
+func (l *LoadBalancingPolicy) DoWithRetry(attempts int, fn func(s RegisterCenterValue) error) error {
+	if len(l.Services) == 0 {
+		return l.notFoundErr()
+	}
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	tried := make(map[string]bool, attempts)
+	var errs, endpoints []string
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		s := l.selectExcluding(tried)
+		tried[s.Addr] = true
+		err := fn(s)
+		if err == nil {
+			return nil
+		}
+		l.markSuspect(s.Addr)
+		lastErr = err
+		endpoints = append(endpoints, s.Addr)
+		errs = append(errs, s.Addr+": "+err.Error())
+	}
+	return &ServiceError{
+		Endpoints: endpoints,
+		Err:       &attemptsError{msg: fmt.Sprintf("all %d attempts failed: %s", len(errs), strings.Join(errs, "; ")), last: lastErr},
+	}
+}
+
+type attemptsError struct {
+	msg  string
+	last error
+}
+
+func (e *attemptsError) Error() string {
+	return e.msg
+}
+
+// Unwrap the error of the last attempt
+func (e *attemptsError) Unwrap() error {
+	return e.last
+}
+
+// discoveryError keep the description of the registry as message
+type discoveryError struct {
+	msg string
+	err error
+}
+
+func (e *discoveryError) Error() string {
+	return e.msg
+}
+
+func (e *discoveryError) Unwrap() error {
+	return e.err
+}
+
+// notFoundErr ErrNoHealthyEndpoints when instances reported a reason for not running, otherwise ErrServiceNotFound
+func (l *LoadBalancingPolicy) notFoundErr() error {
+	err := ErrServiceNotFound
+	if l.hasStopped {
+		err = ErrNoHealthyEndpoints
 	}
-	index := r.Intn(len(l.Services))
-	return l.Services[index], nil
+	return &discoveryError{msg: l.Desc, err: err}
+}
+
+// selectExcluding prefer instances neither tried nor suspect, then untried, then any
+func (l *LoadBalancingPolicy) selectExcluding(tried map[string]bool) RegisterCenterValue {
+	l.mux.Lock()
+	now := time.Now()
+	var fresh, untried []RegisterCenterValue
+	for _, s := range l.Services {
+		if tried[s.Addr] {
+			continue
+		}
+		untried = append(untried, s)
+		if until, ok := l.suspect[s.Addr]; ok && now.Before(until) {
+			continue
+		}
+		fresh = append(fresh, s)
+	}
+	l.mux.Unlock()
+
+	candidates := fresh
+	if len(candidates) == 0 {
+		candidates = untried
+	}
+	if len(candidates) == 0 {
+		candidates = l.Services
+	}
+	candidates = l.sameZone(candidates)
+	return candidates[rand.Intn(len(candidates))]
+}
+
+func (l *LoadBalancingPolicy) markSuspect(addr string) {
+	cooldown := l.SuspectCooldown
+	if cooldown <= 0 {
+		cooldown = time.Second * 30
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.suspect == nil {
+		l.suspect = make(map[string]time.Time)
+	}
+	l.suspect[addr] = time.Now().Add(cooldown)
 }
 
 func NewServiceDiscovery(ctx context.Context, client *clientv3.Client, prefix string, notUseIsolate ...bool) (*LoadBalancingPolicy, error) {
+	l, _, err := discoverServices(ctx, client, isolatePrefix(prefix, notUseIsolate...))
+	return l, err
+}
+
+// WaitForService block until a running instance is registered under prefix, or ctx is done
+func WaitForService(ctx context.Context, client *clientv3.Client, prefix string, notUseIsolate ...bool) (*LoadBalancingPolicy, error) {
+	return WaitForInstances(ctx, client, prefix, 1, notUseIsolate...)
+}
+
+// WaitForInstances block until at least n running instances are registered under prefix, or ctx is done.
+// The current state is checked first, then the prefix is watched, no polling is involved.
+func WaitForInstances(ctx context.Context, client *clientv3.Client, prefix string, n int, notUseIsolate ...bool) (*LoadBalancingPolicy, error) {
+	prefix = isolatePrefix(prefix, notUseIsolate...)
+	l, rev, err := discoverServices(ctx, client, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(l.Services) >= n {
+		return l, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	for resp := range wch {
+		if err := resp.Err(); err != nil {
+			return nil, err
+		}
+		if len(resp.Events) == 0 {
+			continue
+		}
+		if l, _, err = discoverServices(ctx, client, prefix); err != nil {
+			return nil, err
+		}
+		if len(l.Services) >= n {
+			return l, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("service watch closed")
+}
+
+func isolatePrefix(prefix string, notUseIsolate ...bool) string {
 	if len(notUseIsolate) == 0 || !notUseIsolate[0] {
 		if mid := GetLocalMid(); mid != "" {
 			prefix = path.Join(prefix, mid)
 		}
 	}
+	return prefix
+}
+
+func discoverServices(ctx context.Context, client *clientv3.Client, prefix string) (*LoadBalancingPolicy, int64, error) {
 	result, err := client.Get(ctx, prefix, []clientv3.OpOption{clientv3.WithPrefix()}...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	var l LoadBalancingPolicy
-	for _, v := range result.Kvs {
-		var rcv RegisterCenterValue
-		if err := json.Unmarshal(v.Value, &rcv); err == nil {
-			if rcv.Status == ServiceStatusRun {
-				l.Add(rcv)
-			} else if rcv.Reason != "" {
+	for _, v := range latestByAddr(result.Kvs) {
+		rcv := v.value
+		if rcv.Weight <= 0 {
+			rcv.Weight = 1
+		}
+		if rcv.Status == ServiceStatusRun {
+			l.Add(rcv)
+		} else {
+			l.hasStopped = true
+			if rcv.Reason != "" {
 				l.Desc = rcv.Reason
 			}
 		}
@@ -58,5 +252,37 @@ func NewServiceDiscovery(ctx context.Context, client *clientv3.Client, prefix st
 	if len(l.Services) == 0 && l.Desc == "" {
 		l.Desc = "找不到可用的节点"
 	}
-	return &l, nil
+	return &l, result.Header.Revision, nil
+}
+
+type registeredValue struct {
+	key   string
+	value RegisterCenterValue
+}
+
+// latestByAddr keep one instance per address, the most recently registered one(CreatedAt, then ModRevision).
+// A stale key of a restarted or re-registered instance would otherwise be counted twice until its lease expires.
+// Invalid values are skipped, the order of kvs is kept.
+func latestByAddr(kvs []*mvccpb.KeyValue) []registeredValue {
+	values := make([]registeredValue, 0, len(kvs))
+	revisions := make([]int64, 0, len(kvs))
+	index := make(map[string]int, len(kvs))
+	for _, kv := range kvs {
+		var rcv RegisterCenterValue
+		if err := json.Unmarshal(kv.Value, &rcv); err != nil {
+			continue
+		}
+		if i, ok := index[rcv.Addr]; ok {
+			old := values[i].value
+			if rcv.CreatedAt > old.CreatedAt || (rcv.CreatedAt == old.CreatedAt && kv.ModRevision > revisions[i]) {
+				values[i] = registeredValue{key: string(kv.Key), value: rcv}
+				revisions[i] = kv.ModRevision
+			}
+			continue
+		}
+		index[rcv.Addr] = len(values)
+		values = append(values, registeredValue{key: string(kv.Key), value: rcv})
+		revisions = append(revisions, kv.ModRevision)
+	}
+	return values
 }
@@ -0,0 +1,279 @@
+package fit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// useEmbedEtcd start a single node etcd until the end of the test
+func useEmbedEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+	freeURL := func() url.URL {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		return url.URL{Scheme: "http", Host: l.Addr().String()}
+	}
+
+	cfg := embed.NewConfig()
+	// etcd warns about a data dir readable by others
+	cfg.Dir = filepath.Join(t.TempDir(), "etcd")
+	if err := os.Mkdir(cfg.Dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(), freeURL()
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(time.Second * 10):
+		t.Fatal("etcd not ready")
+	}
+
+	c, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}, DialTimeout: time.Second * 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// waitFor check cond until it is true or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	return cond()
+}
+
+func registeredKV(key string, rev int64, v RegisterCenterValue) *mvccpb.KeyValue {
+	data, _ := json.Marshal(v)
+	return &mvccpb.KeyValue{Key: []byte(key), Value: data, ModRevision: rev}
+}
+
+func TestLatestByAddr(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		registeredKV("/svc/a-old", 1, RegisterCenterValue{Addr: "10.0.0.1:80", CreatedAt: 100}),
+		registeredKV("/svc/b", 2, RegisterCenterValue{Addr: "10.0.0.2:80", CreatedAt: 100}),
+		{Key: []byte("/svc/invalid"), Value: []byte("{"), ModRevision: 3},
+		// newer CreatedAt wins over a higher ModRevision
+		registeredKV("/svc/c-new", 4, RegisterCenterValue{Addr: "10.0.0.3:80", CreatedAt: 200}),
+		registeredKV("/svc/a-new", 5, RegisterCenterValue{Addr: "10.0.0.1:80", CreatedAt: 200}),
+		registeredKV("/svc/c-old", 6, RegisterCenterValue{Addr: "10.0.0.3:80", CreatedAt: 100}),
+		// same CreatedAt: the newer ModRevision wins, whatever the order of the keys
+		registeredKV("/svc/b-rewritten", 7, RegisterCenterValue{Addr: "10.0.0.2:80", CreatedAt: 100, Version: "v2"}),
+		registeredKV("/svc/b-stale", 1, RegisterCenterValue{Addr: "10.0.0.2:80", CreatedAt: 100, Version: "v0"}),
+		{Key: []byte("/svc/empty"), Value: nil, ModRevision: 8},
+	}
+
+	got := latestByAddr(kvs)
+	want := []registeredValue{
+		{key: "/svc/a-new", value: RegisterCenterValue{Addr: "10.0.0.1:80", CreatedAt: 200}},
+		{key: "/svc/b-rewritten", value: RegisterCenterValue{Addr: "10.0.0.2:80", CreatedAt: 100, Version: "v2"}},
+		{key: "/svc/c-new", value: RegisterCenterValue{Addr: "10.0.0.3:80", CreatedAt: 200}},
+	}
+	// one entry per address, in the order the addresses first appear
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("latestByAddr =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := latestByAddr(nil); len(got) != 0 {
+		t.Errorf("latestByAddr(nil) = %v", got)
+	}
+}
+
+func TestServiceLeaseExpiry(t *testing.T) {
+	c := useEmbedEtcd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const prefix, addr = "/service/lease-test", "127.0.0.1:8080"
+
+	// the key of the previous incarnation, its keepalive stopped(e.g. a long GC pause or a crash)
+	stale, err := c.Grant(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := NewRegisterCenterValue(addr)
+	var oldValue RegisterCenterValue
+	_ = json.Unmarshal([]byte(old), &oldValue)
+	oldValue.CreatedAt -= 60
+	oldData, _ := json.Marshal(oldValue)
+	if _, err = c.Put(ctx, prefix+"/stale", string(oldData), clientv3.WithLease(stale.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := NewServiceRegister(&ServiceRegister{
+		Ctx:    ctx,
+		Client: c,
+		Key:    prefix,
+		Value:  NewRegisterCenterValue(addr),
+		Lease:  5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(reg.Close)
+
+	assertOneInstance := func(stage string) {
+		t.Helper()
+		l, err := NewServiceDiscovery(ctx, c, prefix, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Services) != 1 || l.Services[0].Addr != addr || l.Services[0].CreatedAt == oldValue.CreatedAt {
+			t.Fatalf("%s: services = %+v, want the new registration only", stage, l.Services)
+		}
+	}
+
+	// both keys exist until the stale lease expires: the address is counted once
+	resp, err := c.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil || len(resp.Kvs) != 2 {
+		t.Fatalf("keys = %v, %v, want the stale and the new one", resp, err)
+	}
+	assertOneInstance("before expiry")
+
+	if !waitFor(t, time.Second*10, func() bool {
+		resp, err := c.Get(ctx, prefix+"/stale")
+		return err == nil && len(resp.Kvs) == 0
+	}) {
+		t.Fatal("the stale lease did not expire")
+	}
+	assertOneInstance("after expiry")
+
+	// the lease of the running service is lost: it registers again with a new lease and releases the old one
+	reg.mux.Lock()
+	oldLease := reg.leaseID
+	reg.mux.Unlock()
+	if _, err = c.Revoke(ctx, oldLease); err != nil {
+		t.Fatal(err)
+	}
+	var newLease int64
+	if !waitFor(t, time.Second*10, func() bool {
+		resp, err := c.Get(ctx, reg.Key)
+		if err != nil || len(resp.Kvs) == 0 {
+			return false
+		}
+		newLease = resp.Kvs[0].Lease
+		return newLease != 0 && newLease != int64(oldLease)
+	}) {
+		t.Fatal("the service did not register again")
+	}
+	ttl, err := c.TimeToLive(ctx, clientv3.LeaseID(newLease))
+	if err != nil || ttl.TTL <= 0 {
+		t.Fatalf("new lease TTL = %v, %v", ttl, err)
+	}
+	assertOneInstance("after re-registration")
+	if s := reg.Status(); s != ServiceStatusRun {
+		t.Errorf("status = %v after re-registration", s)
+	}
+	if resp, err := c.Get(ctx, prefix, clientv3.WithPrefix()); err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("keys after re-registration = %v, %v", resp, err)
+	}
+}
+
+func TestWaitForInstancesWatch(t *testing.T) {
+	c := useEmbedEtcd(t)
+	ctx := context.Background()
+	const prefix = "/service/wait-test"
+	if _, err := c.Put(ctx, prefix+"/a", NewRegisterCenterValue("127.0.0.1:8081")); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		l   *LoadBalancingPolicy
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		l, err := WaitForInstances(ctx, c, prefix, 2, true)
+		done <- result{l, err}
+	}()
+
+	// a stopped instance is not counted
+	time.Sleep(time.Millisecond * 200)
+	stopped := RegisterCenterValue{Addr: "127.0.0.1:8082", Status: ServiceStatusNotAvailable, CreatedAt: time.Now().Unix()}
+	data, _ := json.Marshal(stopped)
+	if _, err := c.Put(ctx, prefix+"/b", string(data)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("returned with a stopped instance: %+v, %v", r.l, r.err)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	registered := time.Now()
+	if _, err := c.Put(ctx, prefix+"/c", NewRegisterCenterValue("127.0.0.1:8083")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if len(r.l.Services) != 2 {
+			t.Fatalf("services = %+v, want 2", r.l.Services)
+		}
+		// woken up by the watch, not by a poll interval
+		if d := time.Since(registered); d > time.Millisecond*500 {
+			t.Errorf("returned %v after the registration", d)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("WaitForInstances did not return after the instance was registered")
+	}
+}
+
+func TestWaitForInstancesContextDone(t *testing.T) {
+	c := useEmbedEtcd(t)
+	const prefix = "/service/wait-cancel-test"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := WaitForService(ctx, c, prefix, true)
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 200)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) || err != ctx.Err() {
+			t.Fatalf("err = %v, want ctx.Err()", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("WaitForService did not return after ctx was cancelled")
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	if _, err := WaitForInstances(timeoutCtx, c, prefix, 1, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
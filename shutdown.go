@@ -0,0 +1,160 @@
+package fit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	shutdownPhaseDeregister = iota
+	shutdownPhaseDrain
+	shutdownPhaseServers
+	shutdownPhaseHooks
+	shutdownPhases
+)
+
+type shutdownHook struct {
+	phase int
+	fn    func(ctx context.Context) error
+}
+
+// Shutdown coordinate the graceful shutdown of a service, the steps run in the order:
+// deregister(AttachRegister) -> stop accepting and wait for the in-flight requests(AttachStat)
+// -> stop the servers(AttachGrpcServer, AttachHTTPServer) -> Register hooks in reverse order.
+type Shutdown struct {
+	mux     sync.Mutex
+	hooks   []shutdownHook
+	timeout time.Duration
+	signals []os.Signal
+	once    sync.Once
+	err     error
+}
+
+type ShutdownOption func(*Shutdown)
+
+// ShutdownTimeout overall timeout of all the steps, default 30s
+func ShutdownTimeout(t time.Duration) ShutdownOption {
+	return func(s *Shutdown) {
+		s.timeout = t
+	}
+}
+
+// ShutdownSignals signals Wait blocks on, default SIGINT and SIGTERM
+func ShutdownSignals(sig ...os.Signal) ShutdownOption {
+	return func(s *Shutdown) {
+		s.signals = sig
+	}
+}
+
+func NewShutdown(opts ...ShutdownOption) *Shutdown {
+	s := &Shutdown{
+		timeout: time.Second * 30,
+		signals: []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register add a hook, e.g. closing the database or redis pools, hooks run in reverse order of registration
+func (s *Shutdown) Register(fn func(ctx context.Context) error) {
+	s.add(shutdownPhaseHooks, fn)
+}
+
+// AttachRegister revoke the lease of the service so that no new traffic is routed to it
+func (s *Shutdown) AttachRegister(r *ServiceRegister) {
+	s.add(shutdownPhaseDeregister, func(ctx context.Context) error {
+		r.Close()
+		if r.cancel != nil {
+			r.cancel()
+		}
+		return nil
+	})
+}
+
+// AttachStat reject new requests and wait for the in-flight ones to finish
+func (s *Shutdown) AttachStat(stat *StatUnfinished) {
+	s.add(shutdownPhaseDrain, func(ctx context.Context) error {
+		stat.FiringWaitDone()
+		return stat.WaitUntilDone(ctx)
+	})
+}
+
+// AttachGrpcServer GracefulStop the server, Stop it when the timeout is reached
+func (s *Shutdown) AttachGrpcServer(server *grpc.Server) {
+	s.add(shutdownPhaseServers, func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			server.Stop()
+			return ctx.Err()
+		}
+	})
+}
+
+// AttachHTTPServer Shutdown the server
+func (s *Shutdown) AttachHTTPServer(server *http.Server) {
+	s.add(shutdownPhaseServers, func(ctx context.Context) error {
+		return server.Shutdown(ctx)
+	})
+}
+
+func (s *Shutdown) add(phase int, fn func(ctx context.Context) error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.hooks = append(s.hooks, shutdownHook{phase: phase, fn: fn})
+}
+
+// Wait block until one of the signals is received, then Shutdown
+func (s *Shutdown) Wait() error {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, s.signals...)
+	defer signal.Stop(quit)
+	<-quit
+	return s.Shutdown()
+}
+
+// Shutdown run all the steps once, the errors of the steps are aggregated
+func (s *Shutdown) Shutdown() error {
+	s.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		s.mux.Lock()
+		hooks := make([]shutdownHook, len(s.hooks))
+		copy(hooks, s.hooks)
+		s.mux.Unlock()
+
+		var errs []string
+		for phase := 0; phase < shutdownPhases; phase++ {
+			for i := len(hooks) - 1; i >= 0; i-- {
+				if hooks[i].phase != phase {
+					continue
+				}
+				if err := hooks[i].fn(ctx); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+		}
+		if len(errs) > 0 {
+			s.err = errors.New("shutdown: " + strings.Join(errs, "; "))
+		}
+	})
+	return s.err
+}
@@ -2,9 +2,73 @@ package fit
 
 import (
 	"github.com/golang-module/carbon"
+	"strconv"
 	"time"
 )
 
+// TimeFormat layout used by FormatUnix and ParseInLocation
+type TimeFormat string
+
+const (
+	// DateTime 2006-01-02 15:04:05, used by logs and traces
+	DateTime TimeFormat = "2006-01-02 15:04:05"
+	// Date 2006-01-02
+	Date TimeFormat = "2006-01-02"
+	// HMS 15:04:05
+	HMS TimeFormat = "15:04:05"
+	// HM 15:04
+	HM TimeFormat = "15:04"
+	// RFC3339Milli 2006-01-02T15:04:05.000Z07:00
+	RFC3339Milli TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// FormatUnix format the unix seconds in loc(default Local)
+func FormatUnix(unix int64, f TimeFormat, loc ...*time.Location) string {
+	return time.Unix(unix, 0).In(timeLocation(loc)).Format(string(f))
+}
+
+// FormatTime format t in loc(default the location of t)
+func FormatTime(t time.Time, f TimeFormat, loc ...*time.Location) string {
+	if len(loc) > 0 && loc[0] != nil {
+		t = t.In(loc[0])
+	}
+	return t.Format(string(f))
+}
+
+// ParseInLocation parse s in loc(nil is Local)
+func ParseInLocation(s string, f TimeFormat, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	return time.ParseInLocation(string(f), s, loc)
+}
+
+// ParseTime
+//
+// Deprecated: use FormatUnix
+type ParseTime struct{}
+
+// YMDHMS
+//
+// Deprecated: use FormatUnix(unix, DateTime)
+func (ParseTime) YMDHMS(unix int64) string {
+	return FormatUnix(unix, DateTime)
+}
+
+// YMD
+//
+// Deprecated: use FormatUnix(unix, Date)
+func (ParseTime) YMD(unix int64) string {
+	return FormatUnix(unix, Date)
+}
+
+// HSM
+//
+// Deprecated: use FormatUnix(unix, HMS)
+func (ParseTime) HSM(unix int64) string {
+	return FormatUnix(unix, HMS)
+}
+
 // BeforeDawnTimeDifference Time difference between now and 00:00 am tomorrow
 func BeforeDawnTimeDifference() time.Duration {
 	now := time.Now()
@@ -23,24 +87,117 @@ func SpecifiedTimeExceeded(unix int64) bool {
 
 // GetFullTime return format: yy-mm-dd h:m:s
 func GetFullTime(unix int64) string {
-	return time.Unix(unix, 0).Format("2006-01-02 15:04:05")
+	return FormatUnix(unix, DateTime)
 }
 
 // GetTimeStr return format: yy-mm-dd h:m:s
 func GetTimeStr(t time.Time) string {
-	return t.Format("2006-01-02 15:04:05")
+	return FormatTime(t, DateTime)
 }
 
 // GetHMS  h:m:s
 func GetHMS(unix int64) string {
-	return time.Unix(unix, 0).Format("15:04:05")
+	return FormatUnix(unix, HMS)
 }
 
 // GetMS  h:s
 func GetMS(unix int64) string {
-	return time.Unix(unix, 0).Format("15:04")
+	return FormatUnix(unix, HM)
+}
+
+// UnixToTime carbon value of unix in loc(default Local), e.g. UnixToTime(unix).ToDateTimeString()
+func UnixToTime(unix int64, loc ...*time.Location) carbon.Carbon {
+	return carbon.CreateFromTimestamp(unix, timeLocation(loc).String())
 }
 
-func UnixToTime(unix int64) carbon.Carbon {
-	return carbon.CreateFromTimestamp(unix)
+func timeLocation(loc []*time.Location) *time.Location {
+	if len(loc) > 0 && loc[0] != nil {
+		return loc[0]
+	}
+	return time.Local
+}
+
+// StartOfDay 00:00:00 of the day of t in loc(default Local),
+// the first hour of the day when DST skips midnight(e.g. America/Sao_Paulo before 2019).
+func StartOfDay(t time.Time, loc ...*time.Location) time.Time {
+	t = t.In(timeLocation(loc))
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for h := 1; start.Day() != t.Day() && h < 24; h++ {
+		start = time.Date(t.Year(), t.Month(), t.Day(), h, 0, 0, 0, t.Location())
+	}
+	return start
+}
+
+// EndOfDay 23:59:59.999999999 of the day of t in loc(default Local)
+func EndOfDay(t time.Time, loc ...*time.Location) time.Time {
+	t = t.In(timeLocation(loc))
+	return time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, int(time.Second-time.Nanosecond), t.Location())
+}
+
+// IsSameDay whether a and b are on the same calendar day in loc(default Local)
+func IsSameDay(a, b time.Time, loc ...*time.Location) bool {
+	l := timeLocation(loc)
+	a, b = a.In(l), b.In(l)
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// DaysBetween number of calendar days from a to b in loc(default Local), negative when b is before a.
+// Days shortened or lengthened by DST still count as one day.
+func DaysBetween(a, b time.Time, loc ...*time.Location) int {
+	l := timeLocation(loc)
+	a, b = a.In(l), b.In(l)
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(db.Sub(da).Hours() / 24)
+}
+
+// NextBusinessDay the first day after t that is neither Saturday, Sunday nor one of holidays,
+// the time of day of t is kept.
+func NextBusinessDay(t time.Time, holidays []time.Time, loc ...*time.Location) time.Time {
+	l := timeLocation(loc)
+	t = t.In(l)
+	// always count from t, a day skipped over may not have the time of day(DST)
+	for i := 1; ; i++ {
+		next := time.Date(t.Year(), t.Month(), t.Day()+i, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), l)
+		if next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+			continue
+		}
+		isHoliday := false
+		for _, h := range holidays {
+			if IsSameDay(next, h, l) {
+				isHoliday = true
+				break
+			}
+		}
+		if !isHoliday {
+			return next
+		}
+	}
+}
+
+// DurationMs d in milliseconds
+func DurationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// HumanDuration d ago, e.g. 3分钟前, a negative d is in the future, e.g. 3分钟后
+func HumanDuration(d time.Duration) string {
+	suffix := "前"
+	if d < 0 {
+		d = -d
+		suffix = "后"
+	}
+	switch {
+	case d < time.Minute:
+		return "刚刚"
+	case d < time.Hour:
+		return strconv.Itoa(int(d/time.Minute)) + "分钟" + suffix
+	case d < time.Hour*24:
+		return strconv.Itoa(int(d/time.Hour)) + "小时" + suffix
+	case d < time.Hour*24*30:
+		return strconv.Itoa(int(d/(time.Hour*24))) + "天" + suffix
+	case d < time.Hour*24*365:
+		return strconv.Itoa(int(d/(time.Hour*24*30))) + "个月" + suffix
+	}
+	return strconv.Itoa(int(d/(time.Hour*24*365))) + "年" + suffix
 }
@@ -0,0 +1,176 @@
+package fit
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestStartEndOfDay(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+	// DST started at midnight, 00:00 did not exist on that day
+	sp := mustLoadLocation(t, "America/Sao_Paulo")
+
+	tests := []struct {
+		name       string
+		t          time.Time
+		loc        *time.Location
+		start, end time.Time
+		hours      float64
+	}{
+		{"spring forward", time.Date(2021, 3, 14, 12, 0, 0, 0, ny), ny,
+			time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC), time.Date(2021, 3, 15, 3, 59, 59, 999999999, time.UTC), 23},
+		{"fall back", time.Date(2021, 11, 7, 12, 0, 0, 0, ny), ny,
+			time.Date(2021, 11, 7, 4, 0, 0, 0, time.UTC), time.Date(2021, 11, 8, 4, 59, 59, 999999999, time.UTC), 25},
+		{"no midnight", time.Date(2018, 11, 4, 12, 0, 0, 0, sp), sp,
+			time.Date(2018, 11, 4, 3, 0, 0, 0, time.UTC), time.Date(2018, 11, 5, 1, 59, 59, 999999999, time.UTC), 23},
+		{"leap day", time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC), time.UTC,
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 23, 59, 59, 999999999, time.UTC), 24},
+		// the day is taken in loc, not in the location of t
+		{"other location", time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), ny,
+			time.Date(2024, 2, 29, 5, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 4, 59, 59, 999999999, time.UTC), 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := StartOfDay(tt.t, tt.loc), EndOfDay(tt.t, tt.loc)
+			if !start.Equal(tt.start) {
+				t.Errorf("StartOfDay = %v, want %v", start, tt.start.In(tt.loc))
+			}
+			if !end.Equal(tt.end) {
+				t.Errorf("EndOfDay = %v, want %v", end, tt.end.In(tt.loc))
+			}
+			if start.Location() != tt.loc || end.Location() != tt.loc {
+				t.Errorf("locations = %v, %v, want %v", start.Location(), end.Location(), tt.loc)
+			}
+			if hours := end.Add(time.Nanosecond).Sub(start).Hours(); hours != tt.hours {
+				t.Errorf("day length = %vh, want %vh", hours, tt.hours)
+			}
+		})
+	}
+}
+
+func TestIsSameDayAndDaysBetween(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name string
+		a, b time.Time
+		loc  *time.Location
+		same bool
+		days int
+	}{
+		{"spring forward day", time.Date(2021, 3, 14, 0, 30, 0, 0, ny), time.Date(2021, 3, 14, 23, 30, 0, 0, ny), ny, true, 0},
+		{"across spring forward", time.Date(2021, 3, 13, 12, 0, 0, 0, ny), time.Date(2021, 3, 15, 12, 0, 0, 0, ny), ny, false, 2},
+		// 24 hours elapsed but the day is 25 hours long
+		{"fall back day", time.Date(2021, 11, 7, 0, 0, 0, 0, ny), time.Date(2021, 11, 7, 23, 0, 0, 0, ny), ny, true, 0},
+		{"across fall back", time.Date(2021, 11, 6, 23, 0, 0, 0, ny), time.Date(2021, 11, 7, 23, 0, 0, 0, ny), ny, false, 1},
+		{"leap year february", time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), time.UTC, false, 2},
+		{"common year february", time.Date(2023, 2, 28, 12, 0, 0, 0, time.UTC), time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC), time.UTC, false, 1},
+		{"leap year", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.UTC, false, 366},
+		{"from leap day", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC), time.UTC, false, 365},
+		{"backwards", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 28, 23, 0, 0, 0, time.UTC), time.UTC, false, -2},
+		// the same instants are one day apart in UTC but the same day in New York
+		{"same day in loc", time.Date(2024, 2, 29, 20, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC), ny, true, 0},
+		{"different day in loc", time.Date(2024, 2, 29, 20, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC), time.UTC, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSameDay(tt.a, tt.b, tt.loc); got != tt.same {
+				t.Errorf("IsSameDay = %v, want %v", got, tt.same)
+			}
+			if got := DaysBetween(tt.a, tt.b, tt.loc); got != tt.days {
+				t.Errorf("DaysBetween = %d, want %d", got, tt.days)
+			}
+		})
+	}
+}
+
+func TestNextBusinessDay(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name     string
+		t        time.Time
+		holidays []time.Time
+		loc      *time.Location
+		want     time.Time
+	}{
+		{"to leap day", time.Date(2024, 2, 28, 9, 0, 0, 0, time.UTC), nil, time.UTC, time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC)},
+		{"leap day holiday", time.Date(2024, 2, 28, 9, 0, 0, 0, time.UTC), []time.Time{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)}, time.UTC,
+			time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{"no leap day", time.Date(2023, 2, 28, 9, 0, 0, 0, time.UTC), nil, time.UTC, time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{"weekend", time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC), nil, time.UTC, time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)},
+		{"weekend and holiday", time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC), []time.Time{time.Date(2024, 3, 4, 15, 0, 0, 0, time.UTC)}, time.UTC,
+			time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)},
+		// the time of day is kept across DST, not the elapsed hours
+		{"over fall back", time.Date(2021, 11, 5, 9, 0, 0, 0, ny), nil, ny, time.Date(2021, 11, 8, 9, 0, 0, 0, ny)},
+		{"over spring forward", time.Date(2021, 3, 12, 9, 0, 0, 0, ny), nil, ny, time.Date(2021, 3, 15, 9, 0, 0, 0, ny)},
+		// 02:30 does not exist on 2021-03-14, the weekend day is skipped anyway
+		{"nonexistent time on weekend", time.Date(2021, 3, 12, 2, 30, 0, 0, ny), nil, ny, time.Date(2021, 3, 15, 2, 30, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NextBusinessDay(tt.t, tt.holidays, tt.loc)
+			if !got.Equal(tt.want) {
+				t.Errorf("NextBusinessDay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnixToTime(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	ny := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name string
+		unix int64
+		loc  *time.Location
+		want string
+	}{
+		{"leap day", 1709164800, time.UTC, "2024-02-29 00:00:00"},
+		{"leap day shanghai", 1709164800, shanghai, "2024-02-29 08:00:00"},
+		{"leap day new york", 1709164800, ny, "2024-02-28 19:00:00"},
+		// 01:30 happens twice on the fall back day, EDT then EST
+		{"fall back first", 1636263000, ny, "2021-11-07 01:30:00"},
+		{"fall back second", 1636263000 + 3600, ny, "2021-11-07 01:30:00"},
+		{"spring forward", 1615705200, ny, "2021-03-14 03:00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnixToTime(tt.unix, tt.loc).ToDateTimeString(); got != tt.want {
+				t.Errorf("UnixToTime = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "刚刚"},
+		{time.Second * 59, "刚刚"},
+		{-time.Second * 59, "刚刚"},
+		{time.Minute * 3, "3分钟前"},
+		{-time.Minute * 3, "3分钟后"},
+		{time.Minute*59 + time.Second*59, "59分钟前"},
+		{time.Hour, "1小时前"},
+		{time.Hour * 25, "1天前"},
+		// a leap year is still counted as one year
+		{time.Hour * 24 * 366, "1年前"},
+		{-time.Hour * 24 * 31, "1个月后"},
+	}
+	for _, tt := range tests {
+		if got := HumanDuration(tt.d); got != tt.want {
+			t.Errorf("HumanDuration(%v) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
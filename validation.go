@@ -0,0 +1,72 @@
+package fit
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var chineseMobileRegexp = regexp.MustCompile(`^1[3-9]\d{9}$`)
+
+// IsChineseMobile mainland China mobile number, an optional +86/86 prefix is accepted
+func IsChineseMobile(s string) bool {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "+"), "86")
+	return chineseMobileRegexp.MatchString(s)
+}
+
+var idCardWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+const idCardCheckCodes = "10X98765432"
+
+// IsChineseIDCard 18-digit resident identity card number, the birthdate and the check digit are verified
+func IsChineseIDCard(s string) bool {
+	_, ok := ChineseIDCardBirthday(s)
+	return ok
+}
+
+// ChineseIDCardBirthday the birthdate of a valid 18-digit resident identity card number
+func ChineseIDCardBirthday(s string) (time.Time, bool) {
+	if len(s) != 18 {
+		return time.Time{}, false
+	}
+	sum := 0
+	for i := 0; i < 17; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return time.Time{}, false
+		}
+		sum += int(s[i]-'0') * idCardWeights[i]
+	}
+	if strings.ToUpper(s[17:]) != string(idCardCheckCodes[sum%11]) {
+		return time.Time{}, false
+	}
+
+	birthday, err := time.ParseInLocation("20060102", s[6:14], time.Local)
+	if err != nil || birthday.After(time.Now()) || birthday.Year() < 1900 {
+		return time.Time{}, false
+	}
+	return birthday, true
+}
+
+func IsEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// IsURL absolute URL with scheme and host
+func IsURL(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func IsIPv4(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+}
+
+func IsIPv6(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && strings.Contains(s, ":")
+}